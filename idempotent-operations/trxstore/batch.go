@@ -0,0 +1,269 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+)
+
+// CheckMany looks up all trxs taking each shard lock once. Only live
+// entries are included in the result, and the values are copies.
+func (p *BytesTrxStore) CheckMany(trxs []uuid.UUID) map[uuid.UUID][]byte {
+	exclusive := p.exclusiveReads()
+	res := make(map[uuid.UUID][]byte, len(trxs))
+	var limited []uuid.UUID
+	var evicted []expiredEntry
+	for i, group := range p.groupByShard(trxs) {
+		if len(group) == 0 {
+			continue
+		}
+
+		s := p.shards[i]
+		if exclusive {
+			s.cacheLock.Lock()
+		} else {
+			s.cacheLock.RLock()
+		}
+
+		now := p.opts.clock.Now()
+		for _, trx := range group {
+			if !exclusive && s.limitedReads(trx) {
+				limited = append(limited, trx)
+				continue
+			}
+			value, exists := p.get(s, trx, now)
+			if exists {
+				if exclusive {
+					p.accessed(s, trx, now)
+					if entry, consumed := s.consumeRead(trx, now); consumed {
+						evicted = append(evicted, entry)
+					}
+				}
+				res[trx] = value
+			}
+			p.countLookup(exists)
+		}
+
+		if exclusive {
+			s.cacheLock.Unlock()
+		} else {
+			s.cacheLock.RUnlock()
+		}
+	}
+	p.notify(evicted)
+
+	// entries with a read limit need the write lock to count the read
+	for _, trx := range limited {
+		if value, exists := p.lookupExclusive(trx, p.get); exists {
+			res[trx] = value
+		}
+	}
+
+	return res
+}
+
+// StoreMany stores all entries with the store-wide TTL, taking each shard
+// lock once. Values are copied, like in Store. Values over the
+// WithMaxValueSize limit, unless truncated, and entries rejected by
+// InFlightStoreReject are logged and skipped, as Store does.
+func (p *BytesTrxStore) StoreMany(entries map[uuid.UUID][]byte) {
+	if p.opts.maxValueSize > 0 {
+		entries = p.limitSizes(entries)
+	}
+	trxs := make([]uuid.UUID, 0, len(entries))
+	for trx := range entries {
+		trxs = append(trxs, trx)
+	}
+
+	var stored int
+	var evicted []expiredEntry
+	var writes []writeThroughEntry
+	var conflicts map[uuid.UUID][]byte
+	for i, group := range p.groupByShard(trxs) {
+		if len(group) == 0 {
+			continue
+		}
+
+		s := p.shards[i]
+		s.cacheLock.Lock()
+		now := p.opts.clock.Now()
+		for _, trx := range group {
+			if skip, err := p.inFlightStore(s, trx, nil); skip {
+				p.countRejected(err)
+				p.logRejected(trx, entries[trx], err)
+				continue
+			}
+			if old, conflict := p.conflict(s, trx, now, entries[trx]); conflict {
+				if conflicts == nil {
+					conflicts = map[uuid.UUID][]byte{}
+				}
+				conflicts[trx] = old
+			}
+			evicted = append(evicted, s.store(trx, p.encode(entries[trx]), p.createdAt(s, trx, now), now, p.entryTTL(trx))...)
+			stored++
+			if write, mirror := p.mirrorEntry(s, trx, entries[trx], now); mirror {
+				writes = append(writes, write)
+			}
+		}
+		s.cacheLock.Unlock()
+	}
+	p.stats.stores.Add(uint64(stored))
+
+	p.mirrorLogged(writes...)
+	for trx, old := range conflicts {
+		p.opts.onConflict(trx, old, entries[trx])
+	}
+	p.notify(evicted)
+}
+
+// StoreAll stores all entries as one unit, for operations whose
+// idempotency rests on several keys that must be marked together. The
+// locks of all shards involved are held until every entry is stored, so
+// CheckAll sees either none or all of them. Entries do not evict each
+// other: a shard at its WithMaxEntries or WithMaxBytes limit goes over it
+// rather than drop part of the unit, and the eviction policy sees each
+// entry stored as by Store. If any value is rejected, for being
+// over the WithMaxValueSize limit or in flight under InFlightStoreReject,
+// nothing is stored, the rejection is logged and StoreAll returns false.
+func (p *BytesTrxStore) StoreAll(entries map[uuid.UUID][]byte) bool {
+	limited := make(map[uuid.UUID][]byte, len(entries))
+	trxs := make([]uuid.UUID, 0, len(entries))
+	for trx, value := range entries {
+		res, err := p.limitSize(value)
+		if err != nil {
+			p.countRejected(err)
+			p.logRejected(trx, value, err)
+
+			return false
+		}
+		limited[trx] = res
+		trxs = append(trxs, trx)
+	}
+
+	groups := p.groupByShard(trxs)
+	unlock := p.lockShards(groups)
+	for i, group := range groups {
+		for _, trx := range group {
+			if skip, err := p.inFlightStore(p.shards[i], trx, nil); skip {
+				unlock()
+				p.countRejected(err)
+				p.logRejected(trx, entries[trx], err)
+
+				return false
+			}
+		}
+	}
+
+	var evicted []expiredEntry
+	var writes []writeThroughEntry
+	var conflicts map[uuid.UUID][]byte
+	now := p.opts.clock.Now()
+	for i, group := range groups {
+		s := p.shards[i]
+		// the members of the unit in this shard do not evict each other
+		unit := make(map[uuid.UUID]struct{}, len(group))
+		for _, trx := range group {
+			unit[trx] = struct{}{}
+		}
+		for _, trx := range group {
+			if old, conflict := p.conflict(s, trx, now, limited[trx]); conflict {
+				if conflicts == nil {
+					conflicts = map[uuid.UUID][]byte{}
+				}
+				conflicts[trx] = old
+			}
+			evicted = append(evicted, s.storeUnit(trx, p.encode(limited[trx]), p.createdAt(s, trx, now), now, p.entryTTL(trx), unit)...)
+			if write, mirror := p.mirrorEntry(s, trx, limited[trx], now); mirror {
+				writes = append(writes, write)
+			}
+		}
+	}
+	unlock()
+	p.stats.stores.Add(uint64(len(trxs)))
+
+	// a slow secondary must not stall the locked shards
+	p.mirrorLogged(writes...)
+	for trx, old := range conflicts {
+		p.opts.onConflict(trx, old, limited[trx])
+	}
+	p.notify(evicted)
+
+	return true
+}
+
+// CheckAll looks up all keys as one unit: it holds the locks of all shards
+// involved until every key is read, so entries stored together by
+// StoreAll are found all or not at all. The result holds copies of the
+// live values, and allPresent tells whether every key has one. Unlike
+// CheckMany it takes the shard write locks.
+func (p *BytesTrxStore) CheckAll(keys []uuid.UUID) (res map[uuid.UUID][]byte, allPresent bool) {
+	res = make(map[uuid.UUID][]byte, len(keys))
+	groups := p.groupByShard(keys)
+	unlock := p.lockShards(groups)
+	var evicted []expiredEntry
+	allPresent = true
+	now := p.opts.clock.Now()
+	for i, group := range groups {
+		s := p.shards[i]
+		for _, trx := range group {
+			if _, read := res[trx]; read {
+				// a repeated key counts as one read
+				continue
+			}
+			value, exists := p.get(s, trx, now)
+			if exists {
+				p.accessed(s, trx, now)
+				if entry, consumed := s.consumeRead(trx, now); consumed {
+					evicted = append(evicted, entry)
+				}
+				res[trx] = value
+			}
+			allPresent = allPresent && exists
+			p.countLookup(exists)
+		}
+	}
+	unlock()
+	p.notify(evicted)
+
+	return res, allPresent
+}
+
+// lockShards takes the write locks of the shards that have keys in groups
+// and returns the function releasing them. The locks are taken in shard
+// order, as rlockAll does for SnapshotWithTTL and Range, the other
+// holders of several shard locks, so that they cannot deadlock; all other
+// methods hold at most one shard lock at a time.
+func (p *BytesTrxStore) lockShards(groups [][]uuid.UUID) (unlock func()) {
+	for i, group := range groups {
+		if len(group) > 0 {
+			p.shards[i].cacheLock.Lock()
+		}
+	}
+
+	return func() {
+		for i, group := range groups {
+			if len(group) > 0 {
+				p.shards[i].cacheLock.Unlock()
+			}
+		}
+	}
+}
+
+func (p *BytesTrxStore) limitSizes(entries map[uuid.UUID][]byte) map[uuid.UUID][]byte {
+	res := make(map[uuid.UUID][]byte, len(entries))
+	for trx, value := range entries {
+		if limited, ok := p.limitLogged(trx, value); ok {
+			res[trx] = limited
+		}
+	}
+
+	return res
+}
+
+func (p *BytesTrxStore) groupByShard(trxs []uuid.UUID) [][]uuid.UUID {
+	res := make([][]uuid.UUID, len(p.shards))
+	for _, trx := range trxs {
+		i := p.shardIndex(trx)
+		res[i] = append(res[i], trx)
+	}
+
+	return res
+}
@@ -0,0 +1,225 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCheckManyStoreMany(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	entries := map[uuid.UUID][]byte{}
+	for i := 0; i < 100; i++ {
+		entries[uuid.New()] = []byte{byte(i)}
+	}
+	store.StoreMany(entries)
+	if store.Len() != len(entries) {
+		t.Fatalf("expected %d entries, got %d", len(entries), store.Len())
+	}
+
+	trxs := []uuid.UUID{uuid.New()}
+	for trx := range entries {
+		trxs = append(trxs, trx)
+	}
+	res := store.CheckMany(trxs)
+	if len(res) != len(entries) {
+		t.Fatalf("expected %d results, got %d", len(entries), len(res))
+	}
+	for trx, value := range entries {
+		if !bytes.Equal(res[trx], value) {
+			t.Fatalf("unexpected value %v for %s", res[trx], trx)
+		}
+	}
+}
+
+func TestStoreAllCheckAll(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, false))
+	defer store.Close()
+
+	a, b, c := uuid.New(), uuid.New(), uuid.New()
+	if !store.StoreAll(map[uuid.UUID][]byte{a: []byte("a"), b: []byte("b")}) {
+		t.Fatalf("unit not stored")
+	}
+	if res, all := store.CheckAll([]uuid.UUID{a, b, a}); !all || len(res) != 2 || !bytes.Equal(res[b], []byte("b")) {
+		t.Fatalf("got %v, %v", res, all)
+	}
+	if res, all := store.CheckAll([]uuid.UUID{a, c}); all || len(res) != 1 {
+		t.Fatalf("missing key reported present: %v", res)
+	}
+
+	if store.StoreAll(map[uuid.UUID][]byte{a: []byte("new"), c: []byte("too large")}) {
+		t.Fatalf("unit with a rejected value stored")
+	}
+	if !bytes.Equal(store.Check(a), []byte("a")) || store.Check(c) != nil {
+		t.Fatalf("rejected unit partly stored")
+	}
+}
+
+func TestStoreAllDoesNotEvictItself(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, FIFO} {
+		store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(2), WithEvictionPolicy(policy))
+		store.Close()
+
+		old := uuid.New()
+		store.Store(old, nil)
+		unit := map[uuid.UUID][]byte{uuid.New(): nil, uuid.New(): nil, uuid.New(): nil}
+		store.StoreAll(unit)
+
+		keys := make([]uuid.UUID, 0, len(unit))
+		for trx := range unit {
+			keys = append(keys, trx)
+		}
+		if _, all := store.CheckAll(keys); !all {
+			t.Fatalf("unit evicted part of itself")
+		}
+		if _, exists := store.Lookup(old); exists {
+			t.Fatalf("older entry kept over the unit")
+		}
+		// once complete, the unit is evictable again
+		store.Store(uuid.New(), nil)
+		if store.Len() != 2 {
+			t.Fatalf("expected the store back at its limit, got %d entries", store.Len())
+		}
+	}
+}
+
+func TestStoreAllKeepsEvictionOrder(t *testing.T) {
+	t.Run("fifo", func(t *testing.T) {
+		store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(2), WithEvictionPolicy(FIFO))
+		store.Close()
+
+		old, newer := uuid.New(), uuid.New()
+		store.Store(old, nil)
+		store.Store(newer, nil)
+		// storing old again keeps its place as the first stored entry
+		store.StoreAll(map[uuid.UUID][]byte{old: []byte("again")})
+		store.Store(uuid.New(), nil)
+		if _, exists := store.Lookup(old); exists {
+			t.Fatalf("re-stored entry moved ahead of newer ones")
+		}
+		if _, exists := store.Lookup(newer); !exists {
+			t.Fatalf("newer entry evicted")
+		}
+	})
+	t.Run("lfu", func(t *testing.T) {
+		store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(2), WithEvictionPolicy(LFU))
+		store.Close()
+
+		hot, cold := uuid.New(), uuid.New()
+		store.Store(hot, nil)
+		for i := 0; i < 3; i++ {
+			store.Check(hot)
+		}
+		store.Store(cold, nil)
+		// storing hot again keeps its read count
+		store.StoreAll(map[uuid.UUID][]byte{hot: []byte("again")})
+		store.Check(cold)
+		store.Store(uuid.New(), nil)
+		if _, exists := store.Peek(hot); !exists {
+			t.Fatalf("hot entry evicted")
+		}
+		if _, exists := store.Peek(cold); exists {
+			t.Fatalf("cold entry kept")
+		}
+	})
+}
+
+func TestStoreAllNoPartialVisibility(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	keys := make([]uuid.UUID, 32)
+	for i := range keys {
+		keys[i] = uuid.New()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for generation := 0; generation < 1000; generation++ {
+			unit := make(map[uuid.UUID][]byte, len(keys))
+			for _, trx := range keys {
+				unit[trx] = []byte{byte(generation)}
+			}
+			store.StoreAll(unit)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		res, all := store.CheckAll(keys)
+		if len(res) == 0 {
+			continue
+		}
+		if !all {
+			t.Fatalf("saw %d of %d keys", len(res), len(keys))
+		}
+		for _, value := range res {
+			if !bytes.Equal(value, res[keys[0]]) {
+				t.Fatalf("saw values of different units: %v and %v", value, res[keys[0]])
+			}
+		}
+	}
+}
+
+func BenchmarkBatch(b *testing.B) {
+	const batch = 500
+
+	entries := make(map[uuid.UUID][]byte, batch)
+	trxs := make([]uuid.UUID, 0, batch)
+	for i := 0; i < batch; i++ {
+		trx := uuid.New()
+		entries[trx] = []byte("test")
+		trxs = append(trxs, trx)
+	}
+
+	b.Run("singles", func(b *testing.B) {
+		store := NewBytesTRXStore(time.Minute)
+		defer store.Close()
+
+		for i := 0; i < b.N; i++ {
+			for trx, value := range entries {
+				store.Store(trx, value)
+			}
+			for _, trx := range trxs {
+				store.Check(trx)
+			}
+		}
+	})
+
+	b.Run("many", func(b *testing.B) {
+		store := NewBytesTRXStore(time.Minute)
+		defer store.Close()
+
+		for i := 0; i < b.N; i++ {
+			store.StoreMany(entries)
+			store.CheckMany(trxs)
+		}
+	})
+}
+
+func TestStoreManyInFlightReject(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithInFlightStorePolicy(InFlightStoreReject))
+	defer store.Close()
+
+	running, other := uuid.New(), uuid.New()
+	_, _, done := store.Begin(running)
+	store.StoreMany(map[uuid.UUID][]byte{running: []byte("batch"), other: []byte("batch")})
+	if err := done.Complete([]byte("operation")); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(store.Check(running), []byte("operation")) || !bytes.Equal(store.Check(other), []byte("batch")) {
+		t.Fatalf("batch overwrote an operation in flight")
+	}
+	if stats := store.Stats(); stats.Rejected.InFlight != 1 || stats.Stores != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,87 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"hash/maphash"
+	"math/bits"
+	"sync/atomic"
+)
+
+const (
+	// bloomBitsPerEntry and bloomHashes give about 1% false positives at
+	// the expected number of entries.
+	bloomBitsPerEntry = 10
+	bloomHashes       = 4
+)
+
+// bloomFilter records the keys stored in a shard, so that lookups of keys
+// never stored can miss without taking the shard lock. Bits are set and
+// read atomically, and keys cannot be removed, so the filter is rebuilt
+// once it holds many removed keys, see rebuildBloom.
+type bloomFilter struct {
+	words []atomic.Uint64
+	mask  uint64
+	seed  maphash.Seed
+}
+
+func newBloomFilter(entries int) *bloomFilter {
+	size := uint64(1) << bits.Len64(uint64(max(entries*bloomBitsPerEntry, 64))-1)
+
+	return &bloomFilter{
+		words: make([]atomic.Uint64, size/64),
+		mask:  size - 1,
+		seed:  maphash.MakeSeed(),
+	}
+}
+
+func (f *bloomFilter) add(trx uuid.UUID) {
+	h := maphash.Bytes(f.seed, trx[:])
+	for i := range uint64(bloomHashes) {
+		bit := (h + i*(h>>32|1)) & f.mask
+		f.words[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+// mayContain reports false only for keys never added.
+func (f *bloomFilter) mayContain(trx uuid.UUID) bool {
+	h := maphash.Bytes(f.seed, trx[:])
+	for i := range uint64(bloomHashes) {
+		bit := (h + i*(h>>32|1)) & f.mask
+		if f.words[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// mayContain reports whether trx may be held, without locking. It is true
+// for every key when the store has no bloom filter.
+func (p *BytesTrxStore) mayContain(trx uuid.UUID) bool {
+	f := p.shard(trx).bloom.Load()
+
+	return f == nil || f.mayContain(trx)
+}
+
+// enableBloom gives s a bloom filter sized for entries keys.
+func (s *shard) enableBloom(entries int) {
+	s.bloomSize = entries
+	s.bloom.Store(newBloomFilter(entries))
+}
+
+// rebuildBloom replaces the filter with one of the held keys once more
+// keys were removed since the last rebuild, counted by bloomStale, than
+// are held, as each of them still turns lookups of other keys into false
+// positives. It must be called with the shard write lock held.
+func (s *shard) rebuildBloom() {
+	if s.bloom.Load() == nil || s.bloomStale <= len(s.cache) {
+		return
+	}
+
+	f := newBloomFilter(max(s.bloomSize, len(s.cache)))
+	for trx := range s.cache {
+		f.add(trx)
+	}
+	s.bloom.Store(f)
+	s.bloomStale = 0
+}
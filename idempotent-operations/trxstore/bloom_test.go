@@ -0,0 +1,110 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestBloomFilter(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithBloomFilter(1000))
+	defer store.Close()
+
+	keys := make([]uuid.UUID, 1000)
+	for i := range keys {
+		keys[i] = uuid.New()
+		store.Store(keys[i], nil)
+	}
+	for _, trx := range keys {
+		if _, exists := store.Lookup(trx); !exists {
+			t.Fatalf("stored trx filtered out")
+		}
+	}
+
+	falsePositives := 0
+	for range 10000 {
+		if store.mayContain(uuid.New()) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Fatalf("%d of 10000 missing keys passed the filter", falsePositives)
+	}
+	if store.Stats().Misses != 0 {
+		t.Fatalf("misses counted for filtered lookups")
+	}
+	if _, exists := store.Lookup(uuid.New()); exists || store.Stats().Misses != 1 {
+		t.Fatalf("filtered miss not counted")
+	}
+}
+
+func TestBloomFilterRebuild(t *testing.T) {
+	kept := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithBloomFilter(100))
+	defer store.Close()
+
+	expired := make([]uuid.UUID, 100)
+	for i := range expired {
+		expired[i] = uuid.New()
+		store.Store(expired[i], nil)
+	}
+	clock.Advance(30 * time.Second)
+	store.StoreWithTTL(kept, nil, time.Hour)
+	clock.Advance(31 * time.Second)
+	store.ForceCleanup()
+
+	if !store.mayContain(kept) {
+		t.Fatalf("live trx dropped from the rebuilt filter")
+	}
+	passed := 0
+	for _, trx := range expired {
+		if store.mayContain(trx) {
+			passed++
+		}
+	}
+	if passed > 10 {
+		t.Fatalf("%d of 100 removed keys still in the filter", passed)
+	}
+
+	store.Clear()
+	if store.mayContain(kept) {
+		t.Fatalf("filter not reset by Clear")
+	}
+	store.Store(kept, nil)
+	if _, exists := store.Lookup(kept); !exists {
+		t.Fatalf("trx stored after Clear filtered out")
+	}
+}
+
+func BenchmarkCheckMiss(b *testing.B) {
+	for _, bench := range []struct {
+		name string
+		opts []Option
+	}{
+		{name: "Plain"},
+		{name: "Bloom", opts: []Option{WithBloomFilter(10000)}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			store := NewBytesTRXStore(time.Minute, bench.opts...)
+			defer store.Close()
+			for range 10000 {
+				store.Store(uuid.New(), []byte("test"))
+			}
+			missing := make([]uuid.UUID, 1024)
+			for i := range missing {
+				missing[i] = uuid.New()
+			}
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					store.Check(missing[i%len(missing)])
+					i++
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMaxEntriesEvictsLeastRecentlyUsed(t *testing.T) {
+	var evicted []uuid.UUID
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(3), WithOnEvict(func(trx uuid.UUID, value []byte) {
+		evicted = append(evicted, trx)
+	}))
+	defer store.Close()
+
+	keys := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, trx := range keys {
+		store.Store(trx, []byte("test"))
+	}
+	store.Check(keys[0])
+
+	extra := uuid.New()
+	store.Store(extra, []byte("test"))
+
+	if store.Len() != 3 {
+		t.Fatalf("expected 3 entries, got %d", store.Len())
+	}
+	if _, exists := store.Lookup(keys[1]); exists {
+		t.Fatalf("least recently used trx not evicted")
+	}
+	for _, trx := range []uuid.UUID{keys[0], keys[2], extra} {
+		if _, exists := store.Lookup(trx); !exists {
+			t.Fatalf("trx %s evicted", trx)
+		}
+	}
+	if len(evicted) != 1 || evicted[0] != keys[1] {
+		t.Fatalf("unexpected evictions %v", evicted)
+	}
+}
+
+func TestMaxEntriesPrefersExpired(t *testing.T) {
+	clock := manualclock.New()
+	var evicted, expired int
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithMaxEntries(2),
+		WithOnEvict(func(uuid.UUID, []byte) { evicted++ }),
+		WithOnExpire(func(uuid.UUID, []byte) { expired++ }))
+	store.Close()
+
+	short, long := uuid.New(), uuid.New()
+	store.StoreWithTTL(short, nil, time.Second)
+	store.Store(long, nil)
+	clock.Advance(2 * time.Second)
+	store.Store(uuid.New(), nil)
+
+	if _, exists := store.Lookup(long); !exists {
+		t.Fatalf("live trx evicted while expired one was available")
+	}
+	if evicted != 0 || expired != 1 {
+		t.Fatalf("unexpected evicted=%d expired=%d", evicted, expired)
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxBytes(10))
+	defer store.Close()
+
+	first, second := uuid.New(), uuid.New()
+	store.Store(first, []byte("12345"))
+	store.Store(second, []byte("12345"))
+	if store.Bytes() != 10 {
+		t.Fatalf("expected 10 bytes, got %d", store.Bytes())
+	}
+
+	store.Store(second, []byte("123"))
+	if store.Bytes() != 8 || store.Len() != 2 {
+		t.Fatalf("replace not accounted: %d bytes, %d entries", store.Bytes(), store.Len())
+	}
+
+	store.Store(uuid.New(), []byte("1234"))
+	if _, exists := store.Lookup(first); exists {
+		t.Fatalf("oldest trx not evicted")
+	}
+	if store.Bytes() != 7 {
+		t.Fatalf("expected 7 bytes, got %d", store.Bytes())
+	}
+
+	store.Delete(second)
+	if store.Bytes() != 4 {
+		t.Fatalf("expected 4 bytes after delete, got %d", store.Bytes())
+	}
+}
+
+func TestEvictionLogged(t *testing.T) {
+	logs := &lockedBuffer{}
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(1),
+		WithLogger(slog.New(slog.NewTextHandler(logs, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	defer store.Close()
+
+	store.Store(uuid.New(), nil)
+	store.Store(uuid.New(), nil)
+	if !strings.Contains(logs.String(), "reason=capacity") {
+		t.Fatalf("eviction not logged: %q", logs.String())
+	}
+}
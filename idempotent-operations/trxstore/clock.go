@@ -0,0 +1,47 @@
+package trxstore
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"time"
+)
+
+// Clock is the time source of a store. It drives both expiry checks and
+// the background cleanup ticker. Deadlines are computed from Now, so with
+// SystemClock they carry the monotonic clock reading and wall clock
+// adjustments, such as NTP steps, do not move them. Clocks whose times
+// lack a monotonic reading are trusted as they are: moving them backwards
+// extends every entry by the jump.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a Clock on C until it is stopped.
+type Ticker = manualclock.Ticker
+
+// SystemClock returns the wall clock, the default of every store.
+func SystemClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+type realTicker struct {
+	ticker *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time {
+	return t.ticker.C
+}
+
+func (t realTicker) Stop() {
+	t.ticker.Stop()
+}
@@ -0,0 +1,520 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"log/slog"
+	"math"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestClockExpiryBoundary(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.Store(trx, []byte("test"))
+	clock.Advance(time.Minute)
+	if store.Check(trx) == nil {
+		t.Fatalf("trx expired at TTL boundary")
+	}
+
+	clock.Advance(time.Nanosecond)
+	if store.Check(trx) != nil {
+		t.Fatalf("trx not expired after TTL")
+	}
+
+	clock.Advance(sleepBetweenExpireCheck)
+	waitFor(t, func() bool { return store.Len() == 0 })
+}
+
+func TestOnExpire(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	expired := make(chan uuid.UUID, 1)
+	var store *BytesTrxStore
+	store = NewBytesTRXStore(time.Minute, WithClock(clock), WithOnExpire(func(id uuid.UUID, value []byte) {
+		if string(value) != "test" {
+			t.Errorf("unexpected value %q", value)
+		}
+		if store.Check(id) != nil {
+			t.Errorf("expired trx still readable")
+		}
+		expired <- id
+	}))
+	defer store.Close()
+
+	store.Store(trx, []byte("test"))
+	clock.Advance(time.Minute + sleepBetweenExpireCheck)
+
+	select {
+	case id := <-expired:
+		if id != trx {
+			t.Fatalf("unexpected trx %s", id)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expire callback not called")
+	}
+}
+
+func TestCleanupInterval(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Second, WithClock(clock), WithCleanupInterval(time.Minute))
+	defer store.Close()
+
+	store.Store(trx, []byte("test"))
+	clock.Advance(30 * time.Second)
+	if store.Len() != 1 {
+		t.Fatalf("cleanup ran before interval")
+	}
+
+	clock.Advance(30 * time.Second)
+	waitFor(t, func() bool { return store.Len() == 0 })
+}
+
+func TestTTLRemaining(t *testing.T) {
+	trx, custom := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	if _, exists := store.TTLRemaining(trx); exists {
+		t.Fatalf("missing trx has TTL")
+	}
+
+	store.Store(trx, nil)
+	store.StoreWithTTL(custom, nil, time.Hour)
+	clock.Advance(20 * time.Second)
+
+	if ttl, _ := store.TTLRemaining(trx); ttl != 40*time.Second {
+		t.Fatalf("unexpected TTL %s", ttl)
+	}
+	if ttl, _ := store.TTLRemaining(custom); ttl != time.Hour-20*time.Second {
+		t.Fatalf("unexpected custom TTL %s", ttl)
+	}
+
+	clock.Advance(time.Minute)
+	if _, exists := store.TTLRemaining(trx); exists {
+		t.Fatalf("expired trx has TTL")
+	}
+}
+
+func TestTouch(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	if store.Touch(trx) {
+		t.Fatalf("missing trx touched")
+	}
+
+	store.Store(trx, []byte("test"))
+	clock.Advance(50 * time.Second)
+	if !store.Touch(trx) {
+		t.Fatalf("live trx not touched")
+	}
+
+	clock.Advance(50 * time.Second)
+	if store.Check(trx) == nil {
+		t.Fatalf("touched trx expired at original deadline")
+	}
+
+	clock.Advance(11 * time.Second)
+	if store.Touch(trx) {
+		t.Fatalf("expired trx touched")
+	}
+}
+
+func TestForceCleanup(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Second, WithClock(clock), WithCleanupInterval(time.Hour))
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		store.Store(uuid.New(), nil)
+	}
+	clock.Advance(2 * time.Second)
+	store.Store(uuid.New(), nil)
+
+	if removed := store.ForceCleanup(); removed != 3 {
+		t.Fatalf("expected 3 removed entries, got %d", removed)
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected 1 entry left, got %d", store.Len())
+	}
+}
+
+func TestSetTTL(t *testing.T) {
+	old, recent := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(0, WithClock(clock))
+	defer store.Close()
+
+	store.Store(old, nil)
+	if err := store.SetTTL(-time.Second); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+	if err := store.SetTTL(time.Second); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store.Store(recent, nil)
+
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return store.Len() == 1 })
+	if _, exists := store.Lookup(old); !exists {
+		t.Fatalf("entry stored before SetTTL expired")
+	}
+}
+
+func TestStoreCtxDeadline(t *testing.T) {
+	capped, uncapped, full := uuid.New(), uuid.New(), uuid.New()
+	// context deadlines are wall clock times, so the clock starts now
+	clock := manualclock.NewAt(time.Now().Round(0))
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	ctx, cancel := context.WithDeadline(context.Background(), clock.Now().Add(10*time.Second))
+	defer cancel()
+	if err := store.StoreCtx(ctx, capped, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.StoreCtx(context.Background(), uncapped, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := store.StoreE(ctx, full, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ttl, _ := store.TTLRemaining(capped); ttl != 10*time.Second {
+		t.Fatalf("expected the ctx deadline to cap the ttl, got %v", ttl)
+	}
+	for _, trx := range []uuid.UUID{uncapped, full} {
+		if ttl, _ := store.TTLRemaining(trx); ttl != time.Minute {
+			t.Fatalf("expected the full ttl, got %v", ttl)
+		}
+	}
+
+	long, cancel := context.WithDeadline(context.Background(), clock.Now().Add(time.Hour))
+	defer cancel()
+	if err := store.StoreCtx(long, capped, nil); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if ttl, _ := store.TTLRemaining(capped); ttl != time.Minute {
+		t.Fatalf("expected a later deadline to leave the ttl, got %v", ttl)
+	}
+}
+
+func TestTTLJitter(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(10*time.Minute, WithClock(clock), WithTTLJitter(0.2))
+	defer store.Close()
+
+	keys := make([]uuid.UUID, 200)
+	remaining := map[time.Duration]bool{}
+	shortest, longest := time.Duration(math.MaxInt64), time.Duration(0)
+	for i := range keys {
+		keys[i] = uuid.New()
+		store.Store(keys[i], nil)
+		ttl, _ := store.TTLRemaining(keys[i])
+		if ttl < 8*time.Minute || ttl > 12*time.Minute {
+			t.Fatalf("ttl %v outside the jitter", ttl)
+		}
+		remaining[ttl] = true
+		shortest, longest = min(shortest, ttl), max(longest, ttl)
+	}
+	if len(remaining) < len(keys)/2 || shortest > 9*time.Minute || longest < 11*time.Minute {
+		t.Fatalf("deadlines not spread: %d distinct between %v and %v", len(remaining), shortest, longest)
+	}
+
+	before, _ := store.TTLRemaining(keys[0])
+	store.Store(keys[0], []byte("again"))
+	if after, _ := store.TTLRemaining(keys[0]); after != before {
+		t.Fatalf("ttl of a key changed from %v to %v on store", before, after)
+	}
+	store.StoreWithTTL(keys[1], nil, time.Minute)
+	if ttl, _ := store.TTLRemaining(keys[1]); ttl != time.Minute {
+		t.Fatalf("explicit ttl jittered to %v", ttl)
+	}
+}
+
+func TestConfigGetters(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithCleanupInterval(time.Second), WithMaxEntries(8), WithMaxBytes(1024), WithShards(4))
+	defer store.Close()
+
+	if store.TTL() != time.Minute || store.CleanupInterval() != time.Second {
+		t.Fatalf("got ttl %v and interval %v", store.TTL(), store.CleanupInterval())
+	}
+	if store.MaxEntries() != 8 || store.MaxBytes() != 1024 || store.Shards() != 4 {
+		t.Fatalf("got limits %d, %d and %d shards", store.MaxEntries(), store.MaxBytes(), store.Shards())
+	}
+	if err := store.SetTTL(time.Hour); err != nil || store.TTL() != time.Hour {
+		t.Fatalf("TTL not updated by SetTTL: %v, %v", store.TTL(), err)
+	}
+
+	typed := NewTypedTRXStore[int](time.Minute)
+	defer typed.Close()
+	if typed.TTL() != time.Minute || typed.CleanupInterval() != sleepBetweenExpireCheck {
+		t.Fatalf("got typed ttl %v and interval %v", typed.TTL(), typed.CleanupInterval())
+	}
+}
+
+func TestAnchoredExpiry(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithAnchoredExpiry(true))
+	defer store.Close()
+
+	store.Store(trx, []byte("first"))
+	clock.Advance(40 * time.Second)
+	store.Store(trx, []byte("retry"))
+
+	if remaining, _ := store.TTLRemaining(trx); remaining != 20*time.Second {
+		t.Fatalf("expected original deadline to hold, got %v left", remaining)
+	}
+	clock.Advance(30 * time.Second)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("re-stored trx outlived its first deadline")
+	}
+
+	store.Store(trx, []byte("fresh"))
+	if remaining, _ := store.TTLRemaining(trx); remaining != time.Minute {
+		t.Fatalf("expired trx not stored with a full ttl, got %v left", remaining)
+	}
+}
+
+type lockedBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.buf.String()
+}
+
+func TestStoreWithDeadline(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithAnchoredExpiry(true))
+	defer store.Close()
+
+	store.Store(trx, []byte("first"))
+	deadline := clock.Now().Add(90 * time.Second)
+	store.StoreWithDeadline(trx, []byte("second"), deadline)
+	if remaining, _ := store.TTLRemaining(trx); remaining != 90*time.Second {
+		t.Fatalf("expected the deadline to replace the anchored one, got %v left", remaining)
+	}
+
+	clock.Advance(90 * time.Second)
+	if !bytes.Equal(store.Check(trx), []byte("second")) {
+		t.Fatalf("trx expired at its deadline")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("trx outlived its deadline")
+	}
+}
+
+func TestStoreWithDeadlineInPast(t *testing.T) {
+	trx, absent := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.Store(trx, []byte("live"))
+	store.StoreWithDeadline(trx, []byte("late"), clock.Now().Add(-time.Second))
+	store.StoreWithDeadline(absent, []byte("late"), clock.Now())
+	if !bytes.Equal(store.Check(trx), []byte("live")) {
+		t.Fatalf("past deadline replaced the live entry")
+	}
+	if _, exists := store.Lookup(absent); exists || store.Len() != 1 {
+		t.Fatalf("entry with a past deadline stored")
+	}
+	if store.Stats().Stores != 1 {
+		t.Fatalf("past deadlines counted as stores")
+	}
+}
+
+func TestWallClockDeadline(t *testing.T) {
+	trx, ctxTrx := uuid.New(), uuid.New()
+	// times from time.Now carry a monotonic reading, which Advance keeps
+	clock := manualclock.NewAt(time.Now())
+	store := NewBytesTRXStore(time.Hour, WithClock(clock))
+	defer store.Close()
+
+	// a deadline from outside the process, such as a token exp claim, is a
+	// plain wall clock time
+	deadline := clock.Now().Round(0).Add(time.Minute)
+	store.StoreWithDeadline(trx, []byte("value"), deadline)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+	if err := store.StoreCtx(ctx, ctxTrx, []byte("value")); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+
+	for _, trx := range []uuid.UUID{trx, ctxTrx} {
+		_, meta, _ := store.CheckWithMeta(trx)
+		if meta.ExpiresAt == meta.ExpiresAt.Round(0) || !meta.ExpiresAt.Equal(deadline) {
+			t.Fatalf("deadline %v not kept on the monotonic clock", meta.ExpiresAt)
+		}
+	}
+
+	clock.Advance(time.Minute)
+	if _, exists := store.Lookup(trx); !exists {
+		t.Fatalf("trx expired before its deadline")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("trx outlived its deadline")
+	}
+}
+
+func TestCleanupSurvivesPanic(t *testing.T) {
+	clock := manualclock.New()
+	logs := &lockedBuffer{}
+	var calls atomic.Int32
+	store := NewBytesTRXStore(time.Second, WithClock(clock),
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))),
+		WithOnExpire(func(uuid.UUID, []byte) {
+			if calls.Add(1) == 1 {
+				panic("callback failed")
+			}
+		}))
+	defer store.Close()
+
+	store.Store(uuid.New(), nil)
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return strings.Contains(logs.String(), "callback failed") })
+
+	store.Store(uuid.New(), nil)
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return store.Len() == 0 && calls.Load() == 2 })
+}
+
+func TestExpire(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	expired := make(chan uuid.UUID, 1)
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithOnExpire(func(id uuid.UUID, value []byte) {
+		expired <- id
+	}))
+	defer store.Close()
+
+	if store.Expire(trx) {
+		t.Fatalf("missing trx expired")
+	}
+	store.Store(trx, []byte("test"))
+	store.Store(uuid.New(), nil)
+	if !store.Expire(trx) {
+		t.Fatalf("live trx not expired")
+	}
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("expired trx still readable")
+	}
+
+	if removed := store.ForceCleanup(); removed != 1 {
+		t.Fatalf("expected 1 removed entry, got %d", removed)
+	}
+	if id := <-expired; id != trx {
+		t.Fatalf("unexpected expired trx %s", id)
+	}
+}
+
+func TestPeekKeepsSlidingDeadline(t *testing.T) {
+	peeked, checked := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithSlidingExpiration(true))
+	defer store.Close()
+
+	store.Store(peeked, []byte("test"))
+	store.Store(checked, []byte("test"))
+	clock.Advance(40 * time.Second)
+
+	if _, exists := store.Peek(peeked); !exists {
+		t.Fatalf("live trx not peeked")
+	}
+	store.Check(checked)
+	if remaining, _ := store.TTLRemaining(peeked); remaining != 20*time.Second {
+		t.Fatalf("peek moved the deadline, %v left", remaining)
+	}
+	if remaining, _ := store.TTLRemaining(checked); remaining != time.Minute {
+		t.Fatalf("check did not move the deadline, %v left", remaining)
+	}
+	if stats := store.Stats(); stats.Hits != 1 || stats.Misses != 0 {
+		t.Fatalf("peek counted in stats %+v", stats)
+	}
+}
+
+func TestDeleteOlderThan(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Hour, WithClock(clock))
+	defer store.Close()
+
+	for i := 0; i < 3; i++ {
+		store.Store(uuid.New(), nil)
+	}
+	clock.Advance(10 * time.Minute)
+	recent := uuid.New()
+	store.Store(recent, nil)
+	clock.Advance(time.Minute)
+
+	if removed := store.DeleteOlderThan(5 * time.Minute); removed != 3 {
+		t.Fatalf("expected 3 removed entries, got %d", removed)
+	}
+	if _, exists := store.Lookup(recent); !exists || store.Len() != 1 {
+		t.Fatalf("recent entry removed")
+	}
+}
+
+func TestCleanupBatchSize(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Second, WithClock(clock), WithShards(1), WithCleanupBatchSize(100))
+	store.Close()
+
+	for i := 0; i < 1050; i++ {
+		store.Store(uuid.New(), nil)
+	}
+	clock.Advance(2 * time.Second)
+
+	var batches int
+	for more := true; more; batches++ {
+		var expired []expiredEntry
+		expired, more = store.cleanupBatch(store.shards[0], clock.Now(), nil)
+		if len(expired) > 100 {
+			t.Fatalf("batch removed %d entries", len(expired))
+		}
+	}
+	if batches != 11 || store.Len() != 0 {
+		t.Fatalf("expected 11 batches to empty the store, got %d with %d left", batches, store.Len())
+	}
+}
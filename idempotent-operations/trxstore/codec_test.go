@@ -0,0 +1,83 @@
+package trxstore
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+type codecReceipt struct {
+	Amount   int64
+	Currency string
+	Tags     []string
+}
+
+func TestTypedStoreWithCodec(t *testing.T) {
+	for name, codec := range map[string]Codec[codecReceipt]{
+		"json": JSONCodec[codecReceipt]{},
+		"gob":  GobCodec[codecReceipt]{},
+	} {
+		t.Run(name, func(t *testing.T) {
+			trx := uuid.New()
+			store := NewTypedTRXStore[codecReceipt](time.Minute, WithCodec(codec))
+			defer store.Close()
+
+			receipt := codecReceipt{Amount: 100, Currency: "USD", Tags: []string{"card"}}
+			if err := store.StoreE(trx, receipt); err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+			// the store holds an encoded copy
+			receipt.Tags[0] = "changed"
+			res, exists, err := store.CheckE(trx)
+			if err != nil || !exists || res.Amount != 100 || res.Currency != "USD" || res.Tags[0] != "card" {
+				t.Fatalf("unexpected result %+v, %v, %v", res, exists, err)
+			}
+			if _, exists, err := store.CheckE(uuid.New()); exists || err != nil {
+				t.Fatalf("unexpected result for missing trx %v, %v", exists, err)
+			}
+		})
+	}
+}
+
+// failingCodec decodes nothing.
+type failingCodec struct {
+	JSONCodec[any]
+}
+
+func (failingCodec) Decode([]byte) (any, error) {
+	return nil, errors.New("corrupt")
+}
+
+func TestTypedStoreCodecErrors(t *testing.T) {
+	trx := uuid.New()
+	store := NewTypedTRXStore[any](time.Minute, WithCodec[any](JSONCodec[any]{}))
+	defer store.Close()
+
+	if err := store.StoreE(trx, make(chan int)); err == nil {
+		t.Fatalf("expected encode error")
+	}
+	store.Store(trx, make(chan int))
+	if store.Len() != 0 {
+		t.Fatalf("value stored despite encode error")
+	}
+
+	failing := NewTypedTRXStore[any](time.Minute, WithCodec[any](failingCodec{}))
+	defer failing.Close()
+	failing.Store(trx, "value")
+	if _, exists, err := failing.CheckE(trx); !exists || err == nil {
+		t.Fatalf("expected decode error, got %v, %v", exists, err)
+	}
+	if _, exists := failing.Check(trx); exists {
+		t.Fatalf("undecodable value reported present")
+	}
+}
+
+func TestTypedStoreCodecMismatch(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("codec for another type accepted")
+		}
+	}()
+	NewTypedTRXStore[int](time.Minute, WithCodec[string](JSONCodec[string]{}))
+}
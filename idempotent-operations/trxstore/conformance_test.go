@@ -0,0 +1,26 @@
+package trxstore_test
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/trxstoretest"
+	"testing"
+	"time"
+)
+
+func TestBytesTrxStoreConformance(t *testing.T) {
+	trxstoretest.RunTrxStoreConformance(t, func(t *testing.T, ttl time.Duration) (trxstore.TrxStore, func(time.Duration)) {
+		clock := trxstoretest.NewManualClock()
+
+		return trxstore.NewBytesTRXStore(ttl, trxstore.WithClock(clock)), clock.Advance
+	})
+}
+
+func TestTieredTrxStoreConformance(t *testing.T) {
+	trxstoretest.RunTrxStoreConformance(t, func(t *testing.T, ttl time.Duration) (trxstore.TrxStore, func(time.Duration)) {
+		clock := trxstoretest.NewManualClock()
+		local := trxstore.NewBytesTRXStore(ttl, trxstore.WithClock(clock))
+		remote := trxstore.NewBytesTRXStore(ttl, trxstore.WithClock(clock))
+
+		return trxstore.NewTieredTRXStore(local, remote), clock.Advance
+	})
+}
@@ -0,0 +1,70 @@
+package trxstore
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/google/uuid"
+	"time"
+)
+
+// CounterTrxStore counts occurrences per key within a window: a key starts
+// at zero, and its window starts with the first Increment and lasts for
+// the TTL no matter how often it is incremented afterwards. It takes the
+// same options as BytesTrxStore.
+type CounterTrxStore struct {
+	store *BytesTrxStore
+}
+
+func NewCounterTRXStoreWithContext(ctx context.Context, ttl time.Duration, opts ...Option) *CounterTrxStore {
+	return &CounterTrxStore{store: NewBytesTRXStoreWithContext(ctx, ttl, opts...)}
+}
+
+func NewCounterTRXStore(ttl time.Duration, opts ...Option) *CounterTrxStore {
+	return NewCounterTRXStoreWithContext(nil, ttl, opts...)
+}
+
+// Increment atomically adds delta to the counter of trx and returns the
+// new value.
+func (p *CounterTrxStore) Increment(trx uuid.UUID, delta int64) int64 {
+	var res int64
+	p.store.update(trx, func(existing []byte, existed bool) []byte {
+		if existed {
+			res = decodeCounter(existing)
+		}
+		res += delta
+
+		return binary.BigEndian.AppendUint64(nil, uint64(res))
+	})
+
+	return res
+}
+
+// Get returns the counter of trx, reporting false if its window is over.
+func (p *CounterTrxStore) Get(trx uuid.UUID) (int64, bool) {
+	value, exists := p.store.Lookup(trx)
+	if !exists {
+		return 0, false
+	}
+
+	return decodeCounter(value), true
+}
+
+func (p *CounterTrxStore) Delete(trx uuid.UUID) bool {
+	return p.store.Delete(trx)
+}
+
+func (p *CounterTrxStore) Len() int {
+	return p.store.Len()
+}
+
+func (p *CounterTrxStore) Close() {
+	p.store.Close()
+}
+
+func decodeCounter(value []byte) int64 {
+	if len(value) != 8 {
+		return 0
+	}
+
+	return int64(binary.BigEndian.Uint64(value))
+}
@@ -0,0 +1,54 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCounterIncrement(t *testing.T) {
+	trx := uuid.New()
+	store := NewCounterTRXStore(time.Minute)
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Increment(trx, 1)
+		}()
+	}
+	wg.Wait()
+
+	if res, exists := store.Get(trx); !exists || res != 100 {
+		t.Fatalf("expected 100, got %d", res)
+	}
+	if res := store.Increment(trx, -10); res != 90 {
+		t.Fatalf("expected 90, got %d", res)
+	}
+	if res, exists := store.Get(uuid.New()); exists || res != 0 {
+		t.Fatalf("unexpected counter %d for missing trx", res)
+	}
+}
+
+func TestCounterWindow(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewCounterTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.Increment(trx, 1)
+	clock.Advance(40 * time.Second)
+	store.Increment(trx, 1)
+	clock.Advance(30 * time.Second)
+
+	if _, exists := store.Get(trx); exists {
+		t.Fatalf("increment extended the window")
+	}
+	if res := store.Increment(trx, 1); res != 1 {
+		t.Fatalf("new window not started at zero, got %d", res)
+	}
+}
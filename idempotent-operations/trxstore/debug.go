@@ -0,0 +1,117 @@
+package trxstore
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"github.com/google/uuid"
+	"sort"
+	"strings"
+	"time"
+)
+
+// debugKeysLimit is how many keys Debug lists before truncating.
+const debugKeysLimit = 20
+
+// Debug returns a human readable summary for diagnostics: the store name,
+// if it has one, the number of live entries, the bytes held and, soonest
+// expiring first, up to 20 keys with their remaining TTL, "never" for
+// entries without one. Values are never included, as they may be
+// sensitive. Shards are summarized one at a time, each under its own read
+// lock, so writes made meanwhile may be counted in some shards only.
+func (p *BytesTrxStore) Debug() string {
+	now := p.opts.clock.Now()
+	var entries []debugEntry
+	var live int
+	var size int64
+	for _, s := range p.shards {
+		top := make(debugHeap, 0, debugKeysLimit)
+		s.cacheLock.RLock()
+		size += s.bytes.Load()
+		for trx := range s.cache {
+			if _, exists := s.lookup(trx, now); !exists {
+				continue
+			}
+			live++
+			entry := debugEntry{trx: trx, deadline: s.cacheMeta[trx].deadline}
+			switch {
+			case len(top) < debugKeysLimit:
+				heap.Push(&top, entry)
+			case entry.before(top[0]):
+				top[0] = entry
+				heap.Fix(&top, 0)
+			}
+		}
+		s.cacheLock.RUnlock()
+		entries = append(entries, top...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].before(entries[j])
+	})
+
+	var res strings.Builder
+	res.WriteString("trxstore")
+	if p.opts.name != "" {
+		fmt.Fprintf(&res, " %s", p.opts.name)
+	}
+	fmt.Fprintf(&res, ": %d entries, %d bytes", live, size)
+	for i, entry := range entries {
+		if i == debugKeysLimit {
+			break
+		}
+		if entry.deadline.Equal(neverExpires) {
+			fmt.Fprintf(&res, "\n  %s ttl=never", entry.trx)
+		} else {
+			fmt.Fprintf(&res, "\n  %s ttl=%s", entry.trx, entry.deadline.Sub(now))
+		}
+	}
+	if live > debugKeysLimit {
+		fmt.Fprintf(&res, "\n  ... %d more", live-debugKeysLimit)
+	}
+
+	return res.String()
+}
+
+type debugEntry struct {
+	trx      uuid.UUID
+	deadline time.Time
+}
+
+// before orders entries by deadline, and by key among equal deadlines.
+func (e debugEntry) before(other debugEntry) bool {
+	if !e.deadline.Equal(other.deadline) {
+		return e.deadline.Before(other.deadline)
+	}
+
+	return bytes.Compare(e.trx[:], other.trx[:]) < 0
+}
+
+// debugHeap keeps the soonest expiring entries of a shard seen so far. It
+// is a max-heap, so the entry a sooner one replaces is on top.
+type debugHeap []debugEntry
+
+func (h debugHeap) Len() int {
+	return len(h)
+}
+
+func (h debugHeap) Less(i, j int) bool {
+	return h[j].before(h[i])
+}
+
+func (h debugHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *debugHeap) Push(x any) {
+	*h = append(*h, x.(debugEntry))
+}
+
+func (h *debugHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
@@ -0,0 +1,69 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDebug(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	trx := uuid.New()
+	store.StoreWithTTL(trx, []byte("secret"), time.Second)
+	for i := 0; i < debugKeysLimit+5; i++ {
+		store.Store(uuid.New(), nil)
+	}
+
+	res := store.Debug()
+	lines := strings.Split(res, "\n")
+	if lines[0] != "trxstore: 26 entries, 6 bytes" {
+		t.Fatalf("unexpected summary %q", lines[0])
+	}
+	if lines[1] != "  "+trx.String()+" ttl=1s" {
+		t.Fatalf("soonest expiring key not listed first: %q", lines[1])
+	}
+	if len(lines) != debugKeysLimit+2 || lines[len(lines)-1] != "  ... 6 more" {
+		t.Fatalf("keys not truncated:\n%s", res)
+	}
+	if strings.Contains(res, "secret") {
+		t.Fatalf("value printed:\n%s", res)
+	}
+}
+
+func TestDebugNeverExpires(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithClock(manualclock.New()), WithShards(4))
+	defer store.Close()
+
+	soon, forever := uuid.New(), uuid.New()
+	store.StoreWithTTL(forever, nil, 0)
+	store.StoreWithTTL(soon, nil, time.Second)
+
+	expected := "trxstore: 2 entries, 0 bytes\n  " + soon.String() + " ttl=1s\n  " + forever.String() + " ttl=never"
+	if res := store.Debug(); res != expected {
+		t.Fatalf("unexpected summary %q", res)
+	}
+}
+
+func TestWithName(t *testing.T) {
+	logs := &lockedBuffer{}
+	store := NewBytesTRXStore(time.Minute, WithName("payments"), WithMaxValueSize(1, false),
+		WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	defer store.Close()
+
+	store.Store(uuid.New(), []byte("too large"))
+	if store.Name() != "payments" || store.Stats().Name != "payments" {
+		t.Fatalf("name not reported, got %q", store.Stats().Name)
+	}
+	if !strings.Contains(logs.String(), "store=payments") {
+		t.Fatalf("name not logged: %s", logs.String())
+	}
+	if res := store.Debug(); res != "trxstore payments: 0 entries, 0 bytes" {
+		t.Fatalf("unexpected summary %q", res)
+	}
+}
@@ -0,0 +1,105 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"sync"
+)
+
+const defaultExpireChannelBuffer = 1024
+
+// EvictionReason tells why an entry was removed, see WithOnRemove and
+// ExpireEvent.
+type EvictionReason int
+
+const (
+	// ReasonExpired entries outlived their TTL or deadline.
+	ReasonExpired EvictionReason = iota
+	// ReasonCapacity entries were dropped to stay within WithMaxEntries.
+	ReasonCapacity
+	// ReasonMaxBytes entries were dropped to stay within WithMaxBytes.
+	ReasonMaxBytes
+	// ReasonMaxReads entries used up the reads of StoreWithMaxReads.
+	ReasonMaxReads
+	// ReasonManual entries were made due by Expire.
+	ReasonManual
+)
+
+// expiry reports whether the entry ran out on its own limits rather than
+// being pushed out by others.
+func (r EvictionReason) expiry() bool {
+	return r == ReasonExpired || r == ReasonMaxReads || r == ReasonManual
+}
+
+func (r EvictionReason) String() string {
+	switch r {
+	case ReasonExpired:
+		return "expired"
+	case ReasonCapacity:
+		return "capacity"
+	case ReasonMaxBytes:
+		return "max_bytes"
+	case ReasonMaxReads:
+		return "max_reads"
+	case ReasonManual:
+		return "manual"
+	default:
+		return "unknown"
+	}
+}
+
+// ExpireEvent reports an entry removed by the background cleanup or, with
+// Evicted set, dropped to stay within the entry or byte limits. Reason
+// tells the two apart in more detail; memory pressure shows up as
+// ReasonCapacity and ReasonMaxBytes.
+type ExpireEvent struct {
+	Trx     uuid.UUID
+	Value   []byte
+	Evicted bool
+	Reason  EvictionReason
+}
+
+// eventChannel is a buffered channel of store events that is safe to send
+// to after it was closed.
+type eventChannel[T any] struct {
+	lock   sync.RWMutex
+	ch     chan T
+	closed bool
+}
+
+func newEventChannel[T any](buffer int) *eventChannel[T] {
+	return &eventChannel[T]{ch: make(chan T, buffer)}
+}
+
+// send never blocks: events that do not fit the buffer are dropped.
+func (e *eventChannel[T]) send(event T) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	if e.closed {
+		return
+	}
+	select {
+	case e.ch <- event:
+	default:
+	}
+}
+
+func (e *eventChannel[T]) close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if !e.closed {
+		e.closed = true
+		close(e.ch)
+	}
+}
+
+// ExpireChannel returns the channel enabled by WithExpireChannel, or nil if
+// it is not enabled. It is closed by Close.
+func (p *BytesTrxStore) ExpireChannel() <-chan ExpireEvent {
+	if p.events == nil {
+		return nil
+	}
+
+	return p.events.ch
+}
@@ -0,0 +1,129 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestExpireChannel(t *testing.T) {
+	expired, evicted := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithMaxEntries(2), WithShards(1), WithExpireChannel(2))
+
+	store.StoreWithTTL(expired, []byte("expired"), time.Second)
+	store.Store(evicted, []byte("evicted"))
+	clock.Advance(2 * time.Second)
+	store.ForceCleanup()
+	store.Store(uuid.New(), nil)
+	store.Store(uuid.New(), nil)
+	// the buffer is full, so this eviction is dropped instead of blocking
+	store.Store(uuid.New(), nil)
+
+	events := store.ExpireChannel()
+	if event := <-events; event.Trx != expired || event.Evicted || string(event.Value) != "expired" {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if event := <-events; event.Trx != evicted || !event.Evicted {
+		t.Fatalf("unexpected event %+v", event)
+	}
+
+	store.Close()
+	select {
+	case event, ok := <-events:
+		if ok {
+			t.Fatalf("unexpected event %+v after full buffer", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("channel not closed by Close")
+	}
+	store.Store(uuid.New(), nil)
+	store.Store(uuid.New(), nil)
+}
+
+func TestEvictionReasons(t *testing.T) {
+	for _, tc := range []struct {
+		want    EvictionReason
+		opts    []Option
+		trigger func(store *BytesTrxStore, trx uuid.UUID, clock *manualclock.Clock)
+	}{
+		{want: ReasonExpired, trigger: func(store *BytesTrxStore, _ uuid.UUID, clock *manualclock.Clock) {
+			clock.Advance(time.Minute + time.Nanosecond)
+			store.ForceCleanup()
+		}},
+		{want: ReasonCapacity, opts: []Option{WithMaxEntries(1)}, trigger: func(store *BytesTrxStore, _ uuid.UUID, _ *manualclock.Clock) {
+			store.Store(uuid.New(), nil)
+		}},
+		{want: ReasonMaxBytes, opts: []Option{WithMaxBytes(8)}, trigger: func(store *BytesTrxStore, _ uuid.UUID, _ *manualclock.Clock) {
+			store.Store(uuid.New(), []byte("value"))
+		}},
+		{want: ReasonMaxReads, trigger: func(store *BytesTrxStore, trx uuid.UUID, _ *manualclock.Clock) {
+			store.StoreWithMaxReads(trx, []byte("value"), 1)
+			store.Check(trx)
+		}},
+		{want: ReasonManual, trigger: func(store *BytesTrxStore, trx uuid.UUID, _ *manualclock.Clock) {
+			store.Expire(trx)
+			store.ForceCleanup()
+		}},
+	} {
+		t.Run(tc.want.String(), func(t *testing.T) {
+			trx := uuid.New()
+			clock := manualclock.New()
+			var reasons []EvictionReason
+			opts := append([]Option{WithClock(clock), WithShards(1), WithExpireChannel(1),
+				WithOnRemove(func(removed uuid.UUID, _ []byte, reason EvictionReason) {
+					if removed == trx {
+						reasons = append(reasons, reason)
+					}
+				}),
+			}, tc.opts...)
+			store := NewBytesTRXStore(time.Minute, opts...)
+			defer store.Close()
+
+			store.Store(trx, []byte("value"))
+			tc.trigger(store, trx, clock)
+			if len(reasons) != 1 || reasons[0] != tc.want {
+				t.Fatalf("got reasons %v, want %v", reasons, tc.want)
+			}
+			event := <-store.ExpireChannel()
+			if event.Trx != trx || event.Reason != tc.want || event.Evicted == tc.want.expiry() {
+				t.Fatalf("unexpected event %+v", event)
+			}
+		})
+	}
+}
+
+func TestOnExpireBatch(t *testing.T) {
+	clock := manualclock.New()
+	var batches [][]ExpireEvent
+	var single int
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithOnExpire(func(uuid.UUID, []byte) { single++ }),
+		WithOnExpireBatch(func(entries []ExpireEvent) { batches = append(batches, entries) }))
+	store.Close()
+
+	swept := map[uuid.UUID]bool{}
+	for i := 0; i < 100; i++ {
+		trx := uuid.New()
+		swept[trx] = true
+		store.StoreWithTTL(trx, []byte("value"), time.Second)
+	}
+	for i := 0; i < 10; i++ {
+		store.Store(uuid.New(), nil)
+	}
+	if store.ForceCleanup(); len(batches) != 0 {
+		t.Fatalf("empty sweep delivered %d batches", len(batches))
+	}
+
+	clock.Advance(2 * time.Second)
+	store.ForceCleanup()
+	if len(batches) != 1 || len(batches[0]) != len(swept) || single != len(swept) {
+		t.Fatalf("expected one batch of %d entries, got %d batches and %d single calls", len(swept), len(batches), single)
+	}
+	for _, entry := range batches[0] {
+		if !swept[entry.Trx] || entry.Reason != ReasonExpired || string(entry.Value) != "value" {
+			t.Fatalf("unexpected entry %+v", entry)
+		}
+		delete(swept, entry.Trx)
+	}
+}
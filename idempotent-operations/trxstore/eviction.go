@@ -0,0 +1,229 @@
+package trxstore
+
+import (
+	"container/heap"
+	"container/list"
+	"github.com/google/uuid"
+)
+
+// Evictor decides which entry of a shard to drop when the shard is over
+// its entry or byte limit. Expired entries are always dropped first, so
+// the evictor is only asked once there are none. Its methods are called
+// with the shard write lock held and must not use the store.
+type Evictor interface {
+	// Stored is called when trx is stored, both for new and replaced
+	// entries.
+	Stored(trx uuid.UUID)
+	// Accessed is called when the live entry trx is read.
+	Accessed(trx uuid.UUID)
+	// Removed is called when trx leaves the shard for any reason.
+	Removed(trx uuid.UUID)
+	// Victim returns the entry to evict next other than exclude, or false
+	// if there is none.
+	Victim(exclude uuid.UUID) (uuid.UUID, bool)
+}
+
+// unitEvictor is implemented by the built-in policies, which can pick a
+// victim outside the members of a unit stored by StoreAll.
+type unitEvictor interface {
+	victimOutside(unit map[uuid.UUID]struct{}) (uuid.UUID, bool)
+}
+
+// EvictionPolicy creates the Evictor of one shard. See WithEvictionPolicy.
+type EvictionPolicy func() Evictor
+
+// LRU evicts the least recently stored or read entry. It is the default.
+func LRU() Evictor {
+	return &orderEvictor{entries: map[uuid.UUID]*list.Element{}, order: list.New(), onAccess: true}
+}
+
+// FIFO evicts the entry stored first. Reads and later stores of the same
+// trx do not change its place.
+func FIFO() Evictor {
+	return &orderEvictor{entries: map[uuid.UUID]*list.Element{}, order: list.New()}
+}
+
+// LFU evicts the least frequently read entry, the least recently used one
+// among equally popular entries. Storing trx again counts as a use but
+// keeps its read count.
+func LFU() Evictor {
+	return &frequencyEvictor{entries: map[uuid.UUID]*frequencyEntry{}}
+}
+
+// orderEvictor keeps entries in a list ordered from most to least recently
+// stored, and also read if onAccess is set.
+type orderEvictor struct {
+	entries  map[uuid.UUID]*list.Element
+	order    *list.List
+	onAccess bool
+}
+
+func (e *orderEvictor) Stored(trx uuid.UUID) {
+	if elem, exists := e.entries[trx]; exists {
+		if e.onAccess {
+			e.order.MoveToFront(elem)
+		}
+		return
+	}
+
+	e.entries[trx] = e.order.PushFront(trx)
+}
+
+func (e *orderEvictor) Accessed(trx uuid.UUID) {
+	if elem, exists := e.entries[trx]; exists && e.onAccess {
+		e.order.MoveToFront(elem)
+	}
+}
+
+func (e *orderEvictor) Removed(trx uuid.UUID) {
+	if elem, exists := e.entries[trx]; exists {
+		e.order.Remove(elem)
+		delete(e.entries, trx)
+	}
+}
+
+func (e *orderEvictor) Victim(exclude uuid.UUID) (uuid.UUID, bool) {
+	for elem := e.order.Back(); elem != nil; elem = elem.Prev() {
+		if trx := elem.Value.(uuid.UUID); trx != exclude {
+			return trx, true
+		}
+	}
+
+	return uuid.UUID{}, false
+}
+
+func (e *orderEvictor) victimOutside(unit map[uuid.UUID]struct{}) (uuid.UUID, bool) {
+	for elem := e.order.Back(); elem != nil; elem = elem.Prev() {
+		trx := elem.Value.(uuid.UUID)
+		if _, member := unit[trx]; !member {
+			return trx, true
+		}
+	}
+
+	return uuid.UUID{}, false
+}
+
+type frequencyEntry struct {
+	trx   uuid.UUID
+	reads uint64
+	used  uint64
+	index int
+}
+
+// frequencyEvictor keeps entries in a min-heap ordered by read count and
+// then by the tick of their last use.
+type frequencyEvictor struct {
+	entries map[uuid.UUID]*frequencyEntry
+	heap    frequencyHeap
+	tick    uint64
+}
+
+func (e *frequencyEvictor) Stored(trx uuid.UUID) {
+	e.tick++
+	if entry, exists := e.entries[trx]; exists {
+		entry.used = e.tick
+		heap.Fix(&e.heap, entry.index)
+		return
+	}
+
+	entry := &frequencyEntry{trx: trx, used: e.tick}
+	e.entries[trx] = entry
+	heap.Push(&e.heap, entry)
+}
+
+func (e *frequencyEvictor) Accessed(trx uuid.UUID) {
+	if entry, exists := e.entries[trx]; exists {
+		e.tick++
+		entry.reads++
+		entry.used = e.tick
+		heap.Fix(&e.heap, entry.index)
+	}
+}
+
+func (e *frequencyEvictor) Removed(trx uuid.UUID) {
+	if entry, exists := e.entries[trx]; exists {
+		heap.Remove(&e.heap, entry.index)
+		delete(e.entries, trx)
+	}
+}
+
+func (e *frequencyEvictor) Victim(exclude uuid.UUID) (uuid.UUID, bool) {
+	switch {
+	case len(e.heap) == 0:
+		return uuid.UUID{}, false
+	case e.heap[0].trx != exclude:
+		return e.heap[0].trx, true
+	}
+
+	// the children of the root are the next candidates
+	var res *frequencyEntry
+	for _, i := range []int{1, 2} {
+		if i < len(e.heap) && (res == nil || e.heap.Less(i, res.index)) {
+			res = e.heap[i]
+		}
+	}
+	if res == nil {
+		return uuid.UUID{}, false
+	}
+
+	return res.trx, true
+}
+
+// victimOutside walks the heap best first, so it visits about as many
+// entries as there are members of unit ahead of the victim.
+func (e *frequencyEvictor) victimOutside(unit map[uuid.UUID]struct{}) (uuid.UUID, bool) {
+	walk := &heapWalk{entries: e.heap}
+	if len(e.heap) > 0 {
+		walk.next = []int{0}
+	}
+	for walk.Len() > 0 {
+		i := heap.Pop(walk).(int)
+		if _, member := unit[e.heap[i].trx]; !member {
+			return e.heap[i].trx, true
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(e.heap) {
+				heap.Push(walk, child)
+			}
+		}
+	}
+
+	return uuid.UUID{}, false
+}
+
+type frequencyHeap []*frequencyEntry
+
+func (h frequencyHeap) Len() int {
+	return len(h)
+}
+
+func (h frequencyHeap) Less(i, j int) bool {
+	if h[i].reads != h[j].reads {
+		return h[i].reads < h[j].reads
+	}
+
+	return h[i].used < h[j].used
+}
+
+func (h frequencyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *frequencyHeap) Push(x any) {
+	item := x.(*frequencyEntry)
+	item.index = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *frequencyHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.index = -1
+	*h = old[:n-1]
+
+	return item
+}
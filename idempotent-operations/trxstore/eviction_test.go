@@ -0,0 +1,115 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"math/rand/v2"
+	"testing"
+	"time"
+)
+
+func TestEvictionPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  EvictionPolicy
+		evicted int
+	}{
+		// keys[0] is stored first and read most, keys[1] is used least
+		// recently and keys[2] is read least
+		{name: "LRU", policy: LRU, evicted: 1},
+		{name: "LFU", policy: LFU, evicted: 2},
+		{name: "FIFO", policy: FIFO, evicted: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(3), WithEvictionPolicy(test.policy))
+			defer store.Close()
+
+			keys := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+			for _, trx := range keys {
+				store.Store(trx, nil)
+			}
+			for _, i := range []int{1, 1, 0, 0, 0, 2} {
+				store.Check(keys[i])
+			}
+			store.Store(keys[0], nil)
+
+			store.Store(uuid.New(), nil)
+			for i, trx := range keys {
+				if _, exists := store.Lookup(trx); exists == (i == test.evicted) {
+					t.Fatalf("expected keys[%d] evicted, keys[%d] exists: %t", test.evicted, i, exists)
+				}
+			}
+		})
+	}
+}
+
+func TestEvictionPolicyPrefersExpired(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, FIFO} {
+		clock := manualclock.New()
+		store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithMaxEntries(2), WithEvictionPolicy(policy))
+
+		short, long := uuid.New(), uuid.New()
+		store.Store(long, nil)
+		store.StoreWithTTL(short, nil, time.Second)
+		store.Check(short)
+		clock.Advance(2 * time.Second)
+		store.Store(uuid.New(), nil)
+
+		if _, exists := store.Lookup(long); !exists {
+			t.Fatalf("live trx evicted while expired one was available")
+		}
+		store.Close()
+	}
+}
+
+func TestLFUVictimExcludesRoot(t *testing.T) {
+	evictor := LFU()
+	keys := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+	for _, trx := range keys {
+		evictor.Stored(trx)
+	}
+	evictor.Accessed(keys[1])
+
+	if victim, ok := evictor.Victim(keys[0]); !ok || victim != keys[2] {
+		t.Fatalf("expected keys[2], got %v", victim)
+	}
+	evictor.Removed(keys[2])
+	evictor.Removed(keys[1])
+	if _, ok := evictor.Victim(keys[0]); ok {
+		t.Fatalf("found victim other than the excluded entry")
+	}
+}
+
+// BenchmarkEvictionPolicyZipf reports the hit rate of each policy for a
+// cache holding 10% of the keys under a Zipfian workload, where a miss
+// stores the key.
+func BenchmarkEvictionPolicyZipf(b *testing.B) {
+	const keys = 10000
+	trxs := make([]uuid.UUID, keys)
+	for i := range trxs {
+		trxs[i] = uuid.New()
+	}
+
+	for _, policy := range []struct {
+		name   string
+		policy EvictionPolicy
+	}{{"LRU", LRU}, {"LFU", LFU}, {"FIFO", FIFO}} {
+		b.Run(policy.name, func(b *testing.B) {
+			store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(keys/10), WithEvictionPolicy(policy.policy))
+			defer store.Close()
+			zipf := rand.NewZipf(rand.New(rand.NewPCG(1, 2)), 1.1, 1, keys-1)
+
+			var hits int
+			for b.Loop() {
+				trx := trxs[zipf.Uint64()]
+				if _, exists := store.Lookup(trx); exists {
+					hits++
+				} else {
+					store.Store(trx, nil)
+				}
+			}
+			b.ReportMetric(float64(hits)/float64(b.N), "hits/op")
+		})
+	}
+}
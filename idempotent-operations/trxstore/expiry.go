@@ -0,0 +1,86 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// neverExpires is the deadline of entries stored with a zero TTL. It sorts
+// after every real deadline, so such entries sink to the bottom of the heap.
+var neverExpires = time.Unix(1<<62, 0)
+
+type entryMeta struct {
+	trx      uuid.UUID
+	deadline time.Time
+	ttl      time.Duration
+	version  uint64
+	reads    int
+	warned   bool
+	manual   bool
+	pinned   bool
+	dropAt   time.Time
+	index    int
+}
+
+// deadlineHeap orders entries by deadline, so the cleanup only visits
+// entries that are actually due. The shards and the typed stores keep
+// their entries in one.
+type deadlineHeap[E heapEntry] []E
+
+// heapEntry is an entry of a deadlineHeap, which keeps its index in the
+// heap up to date for heap.Fix and heap.Remove.
+type heapEntry interface {
+	due() time.Time
+	setIndex(i int)
+}
+
+// expiryHeap is the deadline heap of a shard.
+type expiryHeap = deadlineHeap[*entryMeta]
+
+func (m *entryMeta) due() time.Time {
+	return m.deadline
+}
+
+func (m *entryMeta) setIndex(i int) {
+	m.index = i
+}
+
+func (h deadlineHeap[E]) Len() int {
+	return len(h)
+}
+
+func (h deadlineHeap[E]) Less(i, j int) bool {
+	return h[i].due().Before(h[j].due())
+}
+
+func (h deadlineHeap[E]) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].setIndex(i)
+	h[j].setIndex(j)
+}
+
+func (h *deadlineHeap[E]) Push(x any) {
+	item := x.(E)
+	item.setIndex(len(*h))
+	*h = append(*h, item)
+}
+
+func (h *deadlineHeap[E]) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	var empty E
+	old[n-1] = empty
+	item.setIndex(-1)
+	*h = old[:n-1]
+
+	return item
+}
+
+func deadline(from time.Time, ttl time.Duration) time.Time {
+	if ttl == 0 {
+		return neverExpires
+	}
+
+	return from.Add(ttl)
+}
@@ -0,0 +1,71 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// TrxStoreE is the error-returning counterpart of TrxStore for backends
+// that can fail, like Redis. Code written against it handles remote
+// failures explicitly and works the same with the in-memory store, whose
+// methods only fail on a done ctx or an invalid ttl.
+type TrxStoreE interface {
+	LookupE(ctx context.Context, trx uuid.UUID) ([]byte, bool, error)
+	StoreE(ctx context.Context, trx uuid.UUID, result []byte) error
+	StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error
+	DeleteE(ctx context.Context, trx uuid.UUID) (bool, error)
+	Close()
+}
+
+var (
+	_ TrxStoreE = (*BytesTrxStore)(nil)
+	_ TrxStoreE = (*TieredTrxStore)(nil)
+)
+
+// LookupE is like Lookup, but returns ctx.Err() if ctx is already done.
+func (p *BytesTrxStore) LookupE(ctx context.Context, trx uuid.UUID) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	if p.opts.tracer != nil {
+		res, exists := p.lookupTraced(ctx, trx)
+
+		return res, exists, nil
+	}
+	res, exists := p.lookup(trx, p.get)
+
+	return res, exists, nil
+}
+
+// StoreE is like StoreCtx, but always gives trx the full TTL, whatever the
+// deadline of ctx.
+func (p *BytesTrxStore) StoreE(ctx context.Context, trx uuid.UUID, result []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return p.storeWithTTL(ctx, trx, result, p.entryTTL(trx), 0)
+}
+
+// StoreWithTTLE is like StoreWithTTL, but returns ctx.Err() if ctx is
+// already done, ErrInvalidTTL for a negative ttl and ErrValueTooLarge for
+// a value over the limit, storing nothing.
+func (p *BytesTrxStore) StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+	return p.storeWithTTL(ctx, trx, result, ttl, 0)
+}
+
+// DeleteE is like Delete, but returns ctx.Err() if ctx is already done.
+func (p *BytesTrxStore) DeleteE(ctx context.Context, trx uuid.UUID) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	return p.Delete(trx), nil
+}
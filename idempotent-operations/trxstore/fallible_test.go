@@ -0,0 +1,39 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestBytesTrxStoreE(t *testing.T) {
+	trx := uuid.New()
+	ctx := context.Background()
+	var store TrxStoreE = NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if err := store.StoreE(ctx, trx, []byte("test")); err != nil {
+		t.Fatalf("store failed: %v", err)
+	}
+	if res, exists, err := store.LookupE(ctx, trx); err != nil || !exists || !bytes.Equal(res, []byte("test")) {
+		t.Fatalf("unexpected lookup %q, %t, %v", res, exists, err)
+	}
+	if err := store.StoreWithTTLE(ctx, trx, nil, -time.Second); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := store.LookupE(cancelled, trx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+	if _, err := store.DeleteE(cancelled, trx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+	if deleted, err := store.DeleteE(ctx, trx); err != nil || !deleted {
+		t.Fatalf("delete failed: %t, %v", deleted, err)
+	}
+}
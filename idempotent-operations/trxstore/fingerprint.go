@@ -0,0 +1,31 @@
+package trxstore
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+)
+
+// FingerprintNamespace is the UUIDv5 namespace of FingerprintKey.
+var FingerprintNamespace = uuid.MustParse("5c0b4b1e-3f0e-4a8c-9a57-0f6d2c1e7b42")
+
+// FingerprintKey derives a stable idempotency key from request attributes,
+// such as the method, route, tenant and body, for callers without a
+// natural UUID. It is the UUIDv5 in FingerprintNamespace of the parts,
+// each prefixed with its length, so the same parts always give the same
+// key, and moving bytes between parts gives a different one. The order of
+// the parts matters: FingerprintKey(a, b) and FingerprintKey(b, a) are
+// different keys.
+func FingerprintKey(parts ...[]byte) uuid.UUID {
+	var size int
+	for _, part := range parts {
+		size += binary.MaxVarintLen64 + len(part)
+	}
+
+	data := make([]byte, 0, size)
+	for _, part := range parts {
+		data = binary.AppendUvarint(data, uint64(len(part)))
+		data = append(data, part...)
+	}
+
+	return uuid.NewSHA1(FingerprintNamespace, data)
+}
@@ -0,0 +1,31 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"testing"
+)
+
+func TestFingerprintKey(t *testing.T) {
+	key := FingerprintKey([]byte("POST"), []byte("/charges"), []byte(`{"amount":100}`))
+	if key != FingerprintKey([]byte("POST"), []byte("/charges"), []byte(`{"amount":100}`)) {
+		t.Fatalf("key not deterministic")
+	}
+	if key.Version() != 5 {
+		t.Fatalf("got version %d, want 5", key.Version())
+	}
+
+	for _, tc := range []struct {
+		parts [][]byte
+		want  string
+	}{
+		{parts: nil, want: "38bea926-7ddf-5b3e-b00d-d73443baed82"},
+		{parts: [][]byte{[]byte("a"), []byte("b")}, want: "ed34e0f5-abf9-54ad-b620-39b8492e59dd"},
+		{parts: [][]byte{[]byte("b"), []byte("a")}, want: "a28733b5-e0bd-5698-a510-b882d5ba7b5e"},
+		{parts: [][]byte{[]byte("ab")}, want: "7f14aa4b-8a67-51ee-a1e7-550566de9986"},
+		{parts: [][]byte{[]byte("ab"), nil}, want: "faeecb21-ef5b-5f92-92e6-317267a9e1d6"},
+	} {
+		if got := FingerprintKey(tc.parts...); got != uuid.MustParse(tc.want) {
+			t.Errorf("FingerprintKey(%q) = %v, want %v", tc.parts, got, tc.want)
+		}
+	}
+}
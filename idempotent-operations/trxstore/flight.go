@@ -0,0 +1,288 @@
+package trxstore
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"sync/atomic"
+	"time"
+)
+
+// InFlightStorePolicy decides what a Store of a trx does while a Do,
+// DoCtx, Load or Begin of trx is running. See WithInFlightStorePolicy.
+type InFlightStorePolicy int
+
+const (
+	// InFlightStoreOverwrite stores the value and wakes the waiters of Do
+	// with it, and the result of the running fn replaces it later.
+	InFlightStoreOverwrite InFlightStorePolicy = iota
+	// InFlightStoreComplete stores the value and wakes the waiters of Do
+	// with it as the result of the operation. The result of the running
+	// fn is then only returned to its caller and not stored.
+	InFlightStoreComplete
+	// InFlightStoreReject stores nothing: StoreCtx and the E methods
+	// return ErrInFlight, Store logs the rejection.
+	InFlightStoreReject
+)
+
+func (p InFlightStorePolicy) String() string {
+	switch p {
+	case InFlightStoreOverwrite:
+		return "overwrite"
+	case InFlightStoreComplete:
+		return "complete"
+	case InFlightStoreReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+type flight struct {
+	done   chan struct{}
+	result []byte
+	ok     bool
+}
+
+func newFlight() *flight {
+	return &flight{done: make(chan struct{})}
+}
+
+func (f *flight) resolve(result []byte, ok bool) {
+	f.result = result
+	f.ok = ok
+	close(f.done)
+}
+
+// Do returns the live value of trx or runs fn to produce it. While fn is
+// running the key is marked as in flight, and concurrent callers with the
+// same trx wait for its result instead of running fn themselves. If fn
+// returns an error or panics nothing is stored, the marker is cleared and
+// one of the waiters runs its own fn.
+func (p *BytesTrxStore) Do(trx uuid.UUID, fn func() ([]byte, error)) ([]byte, error) {
+	return p.DoCtx(context.Background(), trx, fn)
+}
+
+// DoCtx is like Do, but stops waiting for another caller's fn and returns
+// ctx.Err() once ctx is done. A fn already running is not interrupted, so
+// it should watch ctx itself if it may take long.
+func (p *BytesTrxStore) DoCtx(ctx context.Context, trx uuid.UUID, fn func() ([]byte, error)) ([]byte, error) {
+	res, exists, f, err := p.begin(ctx, trx)
+	if err != nil || exists {
+		return res, err
+	}
+
+	return p.lead(trx, f, fn)
+}
+
+// Completion is the handle of an operation started by Begin. Exactly one
+// of Complete or Fail must be called on it, or the key stays in flight
+// and later callers of Begin and Do for it wait forever. Only the first
+// call has an effect, so a deferred Fail after Complete does nothing.
+type Completion struct {
+	store  *BytesTrxStore
+	trx    uuid.UUID
+	flight *flight
+	done   atomic.Bool
+}
+
+// Begin is Do for handlers that produce the result themselves: it returns
+// the live value of trx with cached true, or marks trx as in flight and
+// returns a Completion through which the caller reports the result. Like
+// in Do, concurrent callers with the same trx wait for the Completion, and
+// one of them gets its own if the operation fails. Deferring Fail right
+// after Begin clears the marker also when the handler panics:
+//
+//	res, cached, done := store.Begin(trx)
+//	if cached {
+//		return res
+//	}
+//	defer done.Fail()
+//	res = handle()
+//	done.Complete(res)
+func (p *BytesTrxStore) Begin(trx uuid.UUID) (result []byte, cached bool, done *Completion) {
+	res, exists, f, _ := p.begin(context.Background(), trx)
+	if exists {
+		return res, true, nil
+	}
+
+	return nil, false, &Completion{store: p, trx: trx, flight: f}
+}
+
+// Complete stores result like Store and wakes the callers waiting for it.
+// If the store rejects result, for example as ErrValueTooLarge, the
+// operation fails as with Fail and the error is returned. A write-through
+// error, see WithWriteThrough, is returned with result stored.
+func (c *Completion) Complete(result []byte) error {
+	if !c.done.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	err := c.store.storeUntil(context.Background(), c.trx, result, c.store.entryTTL(c.trx), 0, time.Time{}, c.flight)
+	if err != nil && !errors.Is(err, ErrWriteThrough) {
+		c.store.abort(c.trx, c.flight)
+	}
+
+	return err
+}
+
+// Fail clears the in-flight marker without storing anything, so that the
+// next caller runs the operation again. It does nothing on a nil
+// Completion, such as the one Begin returns for a cached result.
+func (c *Completion) Fail() {
+	if c == nil || !c.done.CompareAndSwap(false, true) {
+		return
+	}
+
+	c.store.abort(c.trx, c.flight)
+}
+
+// begin returns the live value of trx, waiting for an operation in flight
+// to produce it, or the new flight of trx if the caller is to produce it.
+func (p *BytesTrxStore) begin(ctx context.Context, trx uuid.UUID) (res []byte, exists bool, f *flight, err error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, false, nil, err
+		}
+
+		res, exists, f, leader := p.join(trx)
+		if exists {
+			return res, true, nil, nil
+		}
+		if leader {
+			return nil, false, f, nil
+		}
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			p.stats.waitsCancelled.Add(1)
+
+			return nil, false, nil, ctx.Err()
+		}
+		if f.ok {
+			if res, ok := p.decode(f.result); ok {
+				p.stats.coalesced.Add(1)
+
+				return res, true, nil, nil
+			}
+		}
+	}
+}
+
+// Await returns the value of trx, waiting for it if an operation for trx
+// is in flight. It returns false if trx was never stored or the in-flight
+// operation failed.
+func (p *BytesTrxStore) Await(trx uuid.UUID) ([]byte, bool) {
+	res, exists, _ := p.AwaitCtx(context.Background(), trx)
+
+	return res, exists
+}
+
+// AwaitCtx is like Await, but returns ctx.Err() if ctx is done before the
+// in-flight operation finishes.
+func (p *BytesTrxStore) AwaitCtx(ctx context.Context, trx uuid.UUID) ([]byte, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+
+	s := p.shard(trx)
+	s.cacheLock.RLock()
+	f, inFlight := s.inFlight[trx]
+	s.cacheLock.RUnlock()
+
+	if !inFlight {
+		res, exists := p.Lookup(trx)
+
+		return res, exists, nil
+	}
+
+	select {
+	case <-f.done:
+		if !f.ok {
+			return nil, false, nil
+		}
+		res, ok := p.decode(f.result)
+		if ok {
+			p.stats.coalesced.Add(1)
+		}
+
+		return res, ok, nil
+	case <-ctx.Done():
+		p.stats.waitsCancelled.Add(1)
+
+		return nil, false, ctx.Err()
+	}
+}
+
+func (p *BytesTrxStore) join(trx uuid.UUID) (res []byte, exists bool, f *flight, leader bool) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	now := p.opts.clock.Now()
+	if res, exists := p.get(s, trx, now); exists {
+		p.accessed(s, trx, now)
+		p.countLookup(true)
+
+		return res, true, nil, false
+	}
+
+	p.countLookup(false)
+	if f, inFlight := s.inFlight[trx]; inFlight {
+		return nil, false, f, false
+	}
+
+	f = newFlight()
+	s.inFlight[trx] = f
+
+	return nil, false, f, true
+}
+
+func (p *BytesTrxStore) lead(trx uuid.UUID, f *flight, fn func() ([]byte, error)) (res []byte, err error) {
+	completed := false
+	defer func() {
+		if !completed {
+			p.abort(trx, f)
+		}
+	}()
+
+	res, err = fn()
+	if err != nil {
+		return nil, err
+	}
+
+	// a rejected value leaves the marker to the deferred abort
+	err = p.storeUntil(context.Background(), trx, res, p.entryTTL(trx), 0, time.Time{}, f)
+	p.logRejected(trx, res, err)
+	completed = err == nil || errors.Is(err, ErrWriteThrough)
+
+	return res, nil
+}
+
+// inFlightStore applies the InFlightStorePolicy to a store of trx, leader
+// being the flight storing its own result. It reports whether the store
+// must be skipped and the error to return then. It must be called with the
+// shard write lock held.
+func (p *BytesTrxStore) inFlightStore(s *shard, trx uuid.UUID, leader *flight) (bool, error) {
+	_, inFlight := s.inFlight[trx]
+	switch {
+	case leader != nil:
+		// another store completed the flight before fn returned
+		return s.inFlight[trx] != leader && p.opts.inFlightStorePolicy == InFlightStoreComplete, nil
+	case inFlight && p.opts.inFlightStorePolicy == InFlightStoreReject:
+		return true, ErrInFlight
+	default:
+		return false, nil
+	}
+}
+
+func (p *BytesTrxStore) abort(trx uuid.UUID, f *flight) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	if s.inFlight[trx] == f {
+		delete(s.inFlight, trx)
+		f.resolve(nil, false)
+	}
+	s.cacheLock.Unlock()
+}
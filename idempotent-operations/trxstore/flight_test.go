@@ -0,0 +1,307 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoRunsOnce(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := store.Do(trx, func() ([]byte, error) {
+				calls.Add(1)
+				time.Sleep(50 * time.Millisecond)
+				return []byte("test"), nil
+			})
+			if err != nil || !bytes.Equal(res, []byte("test")) {
+				t.Errorf("unexpected result %q, %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("fn called %d times", calls.Load())
+	}
+}
+
+func TestDoPanicReleasesWaiters(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	started := make(chan struct{})
+	go func() {
+		defer func() { _ = recover() }()
+		_, _ = store.Do(trx, func() ([]byte, error) {
+			close(started)
+			time.Sleep(50 * time.Millisecond)
+			panic("boom")
+		})
+	}()
+	<-started
+
+	awaited := make(chan bool)
+	go func() {
+		_, ok := store.Await(trx)
+		awaited <- ok
+	}()
+
+	res, err := store.Do(trx, func() ([]byte, error) {
+		return []byte("retry"), nil
+	})
+	if err != nil || !bytes.Equal(res, []byte("retry")) {
+		t.Fatalf("unexpected result %q, %v", res, err)
+	}
+
+	select {
+	case <-awaited:
+	case <-time.After(time.Second):
+		t.Fatalf("await blocked after panic")
+	}
+}
+
+func TestBeginComplete(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	_, cached, done := store.Begin(trx)
+	if cached || done == nil || store.State(trx) != StateInFlight {
+		t.Fatalf("miss not marked in flight")
+	}
+
+	waited := make(chan []byte)
+	go func() {
+		res, cached, _ := store.Begin(trx)
+		if !cached {
+			t.Errorf("waiter got its own completion")
+		}
+		waited <- res
+	}()
+	waitFor(t, func() bool { return store.Stats().Misses == 2 })
+
+	if err := done.Complete([]byte("result")); err != nil {
+		t.Fatal(err)
+	}
+	done.Fail()
+	if res := <-waited; !bytes.Equal(res, []byte("result")) {
+		t.Fatalf("waiter got %q", res)
+	}
+	if res, cached, done := store.Begin(trx); !cached || done != nil || !bytes.Equal(res, []byte("result")) {
+		t.Fatalf("completed result not cached, got %q", res)
+	}
+}
+
+func TestBeginFail(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, false))
+	defer store.Close()
+
+	_, _, done := store.Begin(trx)
+	done.Fail()
+	if err := done.Complete([]byte("late")); err != nil || store.State(trx) != StateAbsent {
+		t.Fatalf("failed operation completed")
+	}
+
+	_, cached, done := store.Begin(trx)
+	if cached || done == nil {
+		t.Fatalf("failed operation not retried")
+	}
+	if err := done.Complete([]byte("too large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	if _, cached, done := store.Begin(trx); cached || done == nil {
+		t.Fatalf("rejected result left the key in flight")
+	}
+}
+
+func TestBeginPanicFails(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	handle := func() {
+		_, _, done := store.Begin(trx)
+		defer done.Fail()
+		panic("handler failed")
+	}
+	func() {
+		defer func() { recover() }()
+		handle()
+	}()
+
+	if store.State(trx) != StateAbsent {
+		t.Fatalf("panicking handler left the key %v", store.State(trx))
+	}
+	res, cached, done := store.Begin(trx)
+	if cached || done == nil {
+		t.Fatalf("got %q, want a new completion", res)
+	}
+	// a deferred Fail on a cached result does nothing
+	done.Complete(nil)
+	_, _, done = store.Begin(trx)
+	done.Fail()
+}
+
+func TestDoErrorNotStored(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	expectedErr := errors.New("failed")
+	if _, err := store.Do(trx, func() ([]byte, error) { return nil, expectedErr }); !errors.Is(err, expectedErr) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, exists := store.Await(trx); exists {
+		t.Fatalf("failed result stored")
+	}
+}
+
+func TestAwaitCtxCancelled(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go store.Do(trx, func() ([]byte, error) {
+		close(started)
+		<-release
+		return []byte("test"), nil
+	})
+	<-started
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	begin := time.Now()
+	if _, _, err := store.AwaitCtx(ctx, trx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if _, err := store.DoCtx(ctx, trx, func() ([]byte, error) {
+		t.Fatalf("waiter ran fn")
+		return nil, nil
+	}); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("cancelled wait returned after %v", elapsed)
+	}
+	if _, err := store.CheckCtx(ctx, trx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestDoCoalescingStats(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = store.Do(trx, func() ([]byte, error) {
+				<-release
+				return []byte("test"), nil
+			})
+		}()
+	}
+	// every caller misses once before it leads or waits
+	waitFor(t, func() bool { return store.Stats().Misses == 6 })
+	close(release)
+	wg.Wait()
+
+	pending := uuid.New()
+	started, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = store.Do(pending, func() ([]byte, error) {
+			close(started)
+			<-done
+			return nil, errors.New("failed")
+		})
+	}()
+	<-started
+	defer close(done)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, _, err := store.AwaitCtx(ctx, pending); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+
+	if stats := store.Stats(); stats.Coalesced != 5 || stats.WaitsCancelled != 1 {
+		t.Fatalf("unexpected coalescing stats %+v", stats)
+	}
+}
+
+func TestInFlightStorePolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy InFlightStorePolicy
+		err    error
+		waiter string
+		final  string
+	}{
+		{policy: InFlightStoreOverwrite, waiter: "direct", final: "leader"},
+		{policy: InFlightStoreComplete, waiter: "direct", final: "direct"},
+		{policy: InFlightStoreReject, err: ErrInFlight, waiter: "leader", final: "leader"},
+	} {
+		t.Run(fmt.Sprint(test.policy), func(t *testing.T) {
+			trx := uuid.New()
+			store := NewBytesTRXStore(time.Minute, WithInFlightStorePolicy(test.policy))
+			defer store.Close()
+
+			running, release := make(chan struct{}), make(chan struct{})
+			var leader, waiter []byte
+			var wg sync.WaitGroup
+			wg.Add(2)
+			go func() {
+				defer wg.Done()
+				leader, _ = store.Do(trx, func() ([]byte, error) {
+					close(running)
+					<-release
+
+					return []byte("leader"), nil
+				})
+			}()
+			<-running
+			go func() {
+				defer wg.Done()
+				waiter, _ = store.Do(trx, func() ([]byte, error) { return []byte("waiter"), nil })
+			}()
+			// both Do calls missed once the waiter has joined the flight
+			waitFor(t, func() bool { return store.Stats().Misses == 2 })
+
+			if err := store.StoreE(context.Background(), trx, []byte("direct")); !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			close(release)
+			wg.Wait()
+
+			if !bytes.Equal(leader, []byte("leader")) {
+				t.Fatalf("leader got %q", leader)
+			}
+			if !bytes.Equal(waiter, []byte(test.waiter)) {
+				t.Fatalf("waiter got %q, want %q", waiter, test.waiter)
+			}
+			if res := store.Check(trx); !bytes.Equal(res, []byte(test.final)) {
+				t.Fatalf("stored %q, want %q", res, test.final)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package trxstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// StoreGroup holds named stores that share a single background cleanup,
+// so the number of cleanup goroutines does not grow with the number of
+// scopes.
+type StoreGroup struct {
+	ttl        time.Duration
+	opts       []Option
+	options    options
+	lock       sync.RWMutex
+	namespaces map[string]*BytesTrxStore
+	janitor    *janitor
+}
+
+// NewStoreGroupWithContext creates a group whose stores default to ttl and
+// are configured with opts. Like NewBytesTRXStoreWithContext, it panics if
+// ttl is negative or the options are invalid.
+func NewStoreGroupWithContext(ctx context.Context, ttl time.Duration, opts ...Option) *StoreGroup {
+	if err := validateTTL(ttl); err != nil {
+		panic(err)
+	}
+
+	res := &StoreGroup{
+		ttl:        ttl,
+		opts:       append(opts[:len(opts):len(opts)], withSharedCleanup()),
+		options:    newOptions(opts),
+		namespaces: map[string]*BytesTrxStore{},
+	}
+	res.janitor = newJanitor(res.options.logger)
+	res.janitor.strict = res.options.strictMode
+	interval := jitter(res.options.cleanupInterval, res.options.cleanupJitter)
+	res.janitor.start(ctx, res.options.clock, interval, res.cleanupExpired)
+
+	return res
+}
+
+func NewStoreGroup(ttl time.Duration, opts ...Option) *StoreGroup {
+	return NewStoreGroupWithContext(nil, ttl, opts...)
+}
+
+// Namespace returns the store named name, creating it with the group TTL
+// on first use.
+func (p *StoreGroup) Namespace(name string) *BytesTrxStore {
+	return p.NamespaceWithTTL(name, p.ttl)
+}
+
+// NamespaceWithTTL is like Namespace, but a newly created store uses ttl
+// instead of the group TTL. The TTL of an existing store is left as is;
+// use SetTTL on it to change it.
+func (p *StoreGroup) NamespaceWithTTL(name string, ttl time.Duration) *BytesTrxStore {
+	p.lock.RLock()
+	res, exists := p.namespaces[name]
+	p.lock.RUnlock()
+	if exists {
+		return res
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if res, exists := p.namespaces[name]; exists {
+		return res
+	}
+
+	res = NewBytesTRXStore(ttl, append(p.opts[:len(p.opts):len(p.opts)], WithName(name))...)
+	p.namespaces[name] = res
+
+	return res
+}
+
+// Close stops the shared cleanup and closes every store of the group.
+func (p *StoreGroup) Close() {
+	p.janitor.close()
+
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, store := range p.namespaces {
+		store.Close()
+	}
+}
+
+func (p *StoreGroup) cleanupExpired() {
+	p.lock.RLock()
+	stores := make([]*BytesTrxStore, 0, len(p.namespaces))
+	for _, store := range p.namespaces {
+		stores = append(stores, store)
+	}
+	p.lock.RUnlock()
+
+	for _, store := range stores {
+		store.ForceCleanup()
+	}
+}
+
+// withSharedCleanup keeps a store from starting its own cleanup, since
+// its group runs one for it.
+func withSharedCleanup() Option {
+	return func(o *options) {
+		o.sharedCleanup = true
+	}
+}
@@ -0,0 +1,36 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestStoreGroup(t *testing.T) {
+	clock := manualclock.New()
+	group := NewStoreGroup(time.Second, WithClock(clock))
+	defer group.Close()
+
+	orders := group.Namespace("orders")
+	refunds := group.NamespaceWithTTL("refunds", time.Hour)
+	if group.Namespace("orders") != orders {
+		t.Fatalf("namespace not reused")
+	}
+	if orders.Name() != "orders" {
+		t.Fatalf("namespace store named %q", orders.Name())
+	}
+
+	trx := uuid.New()
+	orders.Store(trx, []byte("order"))
+	refunds.Store(trx, []byte("refund"))
+	if string(orders.Check(trx)) != "order" || string(refunds.Check(trx)) != "refund" {
+		t.Fatalf("namespaces share entries")
+	}
+
+	clock.Advance(2 * time.Second)
+	waitFor(t, func() bool { return orders.Len() == 0 })
+	if refunds.Len() != 1 {
+		t.Fatalf("namespace ttl override ignored")
+	}
+}
@@ -0,0 +1,30 @@
+package trxstore
+
+import "time"
+
+// unhealthyAfterIntervals is how many cleanup intervals may pass without a
+// finished sweep before the store is reported unhealthy.
+const unhealthyAfterIntervals = 3
+
+// LastSweep returns when the last cleanup finished, or when the store was
+// created if none has finished yet.
+func (p *BytesTrxStore) LastSweep() time.Time {
+	return time.Unix(0, p.lastSweep.Load())
+}
+
+// Healthy reports whether the background cleanup is alive. It is false
+// after Close and if no sweep finished within the last three cleanup
+// intervals, for example because every sweep panics. Stores of a
+// StoreGroup are checked against the sweeps of the group, and stores with
+// a zero TTL or WithoutBackgroundCleanup, which run no cleanup, are
+// healthy until closed.
+func (p *BytesTrxStore) Healthy() bool {
+	if p.janitor.closed() {
+		return false
+	}
+	if !p.opts.sharedCleanup && !p.janitor.started.Load() {
+		return true
+	}
+
+	return p.opts.clock.Now().Sub(p.LastSweep()) <= unhealthyAfterIntervals*p.opts.cleanupInterval
+}
@@ -0,0 +1,42 @@
+package trxstore
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestHealthy(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	if !store.Healthy() {
+		t.Fatalf("new store unhealthy")
+	}
+
+	clock.Advance(sleepBetweenExpireCheck)
+	waitFor(t, func() bool { return store.Stats().LastSweep.Equal(clock.Now()) })
+	if !store.Healthy() {
+		t.Fatalf("store unhealthy after sweep")
+	}
+
+	store.Close()
+	if store.Healthy() {
+		t.Fatalf("closed store healthy")
+	}
+}
+
+func TestUnhealthyWithoutSweeps(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1))
+	defer store.Close()
+
+	// holding the shard lock stalls the sweep
+	store.shards[0].cacheLock.Lock()
+	clock.Advance(4 * sleepBetweenExpireCheck)
+	healthy := store.Healthy()
+	store.shards[0].cacheLock.Unlock()
+
+	if healthy {
+		t.Fatalf("stalled store reported healthy")
+	}
+}
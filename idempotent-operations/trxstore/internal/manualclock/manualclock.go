@@ -0,0 +1,87 @@
+// Package manualclock holds the manual clock shared by the trxstore tests
+// and trxstoretest, and the Ticker interface it returns, which trxstore
+// aliases so that the package does not depend on trxstore.
+package manualclock
+
+import (
+	"sync"
+	"time"
+)
+
+// Ticker delivers the ticks of a clock on C until it is stopped.
+// trxstore.Ticker is an alias of it.
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Clock is a clock that only moves when Advance is called, so expiry can
+// be tested without sleeping.
+type Clock struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*ticker
+}
+
+type ticker struct {
+	clock    *Clock
+	c        chan time.Time
+	interval time.Duration
+	next     time.Time
+	stopped  bool
+}
+
+// New returns a clock standing at the start of 2023 UTC.
+func New() *Clock {
+	return NewAt(time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC))
+}
+
+// NewAt returns a clock standing at now.
+func NewAt(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// NewTicker returns a ticker firing as Advance moves past its ticks. Like
+// time.Ticker it drops ticks the receiver is not ready for.
+func (c *Clock) NewTicker(d time.Duration) Ticker {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &ticker{clock: c, c: make(chan time.Time, 1), interval: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, t)
+
+	return t
+}
+
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	for _, t := range c.tickers {
+		for !t.stopped && !t.next.After(c.now) {
+			select {
+			case t.c <- t.next:
+			default:
+			}
+			t.next = t.next.Add(t.interval)
+		}
+	}
+}
+
+func (t *ticker) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *ticker) Stop() {
+	t.clock.lock.Lock()
+	t.stopped = true
+	t.clock.lock.Unlock()
+}
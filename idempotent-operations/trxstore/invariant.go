@@ -0,0 +1,80 @@
+package trxstore
+
+import (
+	"container/heap"
+	"fmt"
+	"github.com/google/uuid"
+)
+
+// invariantError is the panic value of a broken internal invariant in
+// strict mode, see WithStrictMode. Unlike panics of user callbacks, the
+// cleanup does not recover it.
+type invariantError string
+
+func (e invariantError) Error() string {
+	return string(e)
+}
+
+// checkShard verifies that the maps and the expiry heap of s hold the same
+// entries, comparing their sizes so the check stays cheap. A mismatch
+// panics in strict mode and is logged and repaired otherwise.
+func (p *BytesTrxStore) checkShard(s *shard) {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	n := len(s.cache)
+	if len(s.createdAt) == n && len(s.cacheMeta) == n && len(s.expiry) == n {
+		return
+	}
+
+	err := invariantError(fmt.Sprintf("trxstore: shard holds %d values, %d creation times, %d entry metas and %d expiry slots",
+		n, len(s.createdAt), len(s.cacheMeta), len(s.expiry)))
+	if p.opts.strictMode {
+		panic(err)
+	}
+	p.opts.logger.Error("trxstore: repairing inconsistent shard", "error", err)
+	s.repair()
+}
+
+// repair drops every entry missing from one of the shard maps and
+// rebuilds the expiry heap and the counters from what is left. It must be
+// called with the shard write lock held.
+func (s *shard) repair() {
+	drop := func(trx uuid.UUID) {
+		delete(s.cache, trx)
+		delete(s.createdAt, trx)
+		delete(s.cacheMeta, trx)
+		if s.evictor != nil {
+			s.evictor.Removed(trx)
+		}
+		s.bloomStale++
+	}
+	for trx := range s.cache {
+		_, created := s.createdAt[trx]
+		if _, exists := s.cacheMeta[trx]; !exists || !created {
+			drop(trx)
+		}
+	}
+	for trx := range s.cacheMeta {
+		if _, exists := s.cache[trx]; !exists {
+			drop(trx)
+		}
+	}
+	for trx := range s.createdAt {
+		if _, exists := s.cache[trx]; !exists {
+			drop(trx)
+		}
+	}
+
+	clear(s.expiry)
+	s.expiry = s.expiry[:0]
+	var size int64
+	for trx, meta := range s.cacheMeta {
+		meta.index = len(s.expiry)
+		s.expiry = append(s.expiry, meta)
+		size += int64(len(s.cache[trx]))
+	}
+	heap.Init(&s.expiry)
+	s.size.Store(int64(len(s.cache)))
+	s.bytes.Store(size)
+}
@@ -0,0 +1,87 @@
+package trxstore
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// desync breaks the shard invariants the way a bug would: it drops the
+// creation time of one entry and the value of another.
+func desync(store *BytesTrxStore, noCreatedAt, noValue uuid.UUID) {
+	s := store.shard(noCreatedAt)
+	s.cacheLock.Lock()
+	delete(s.createdAt, noCreatedAt)
+	s.cacheLock.Unlock()
+
+	s = store.shard(noValue)
+	s.cacheLock.Lock()
+	delete(s.cache, noValue)
+	s.cacheLock.Unlock()
+}
+
+func TestRepairInconsistentShard(t *testing.T) {
+	noCreatedAt, noValue, kept := uuid.New(), uuid.New(), uuid.New()
+	logs := &lockedBuffer{}
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithLogger(slog.New(slog.NewTextHandler(logs, nil))))
+	store.Close()
+
+	for _, trx := range []uuid.UUID{noCreatedAt, noValue, kept} {
+		store.Store(trx, []byte("value"))
+	}
+	desync(store, noCreatedAt, noValue)
+	store.ForceCleanup()
+
+	if !strings.Contains(logs.String(), "repairing inconsistent shard") {
+		t.Fatalf("repair not logged: %s", logs.String())
+	}
+	if _, exists := store.Lookup(noCreatedAt); exists {
+		t.Fatalf("inconsistent entry kept")
+	}
+	if _, exists := store.Lookup(kept); !exists || store.Len() != 1 || store.Bytes() != int64(len("value")) {
+		t.Fatalf("expected only the consistent entry left, got %d entries of %d bytes", store.Len(), store.Bytes())
+	}
+
+	// the repaired shard passes the next check
+	store.ForceCleanup()
+	if strings.Count(logs.String(), "repairing") != 1 {
+		t.Fatalf("repaired shard reported again: %s", logs.String())
+	}
+}
+
+func TestStrictModePanics(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithStrictMode(true))
+	store.Close()
+
+	noCreatedAt, noValue := uuid.New(), uuid.New()
+	store.Store(noCreatedAt, nil)
+	store.Store(noValue, nil)
+	desync(store, noCreatedAt, noValue)
+
+	defer func() {
+		err, ok := recover().(error)
+		var invariant invariantError
+		if !ok || !errors.As(err, &invariant) {
+			t.Fatalf("expected an invariant panic, got %v", err)
+		}
+	}()
+	store.ForceCleanup()
+}
+
+func TestJanitorRecoversOnlyCallbacksInStrictMode(t *testing.T) {
+	j := newJanitor(slog.New(slog.NewTextHandler(&lockedBuffer{}, nil)))
+	j.strict = true
+
+	// a panicking user callback is recovered
+	j.sweep(func() { panic("callback failed") })
+
+	defer func() {
+		if _, ok := recover().(invariantError); !ok {
+			t.Fatalf("broken invariant recovered in strict mode")
+		}
+	}()
+	j.sweep(func() { panic(invariantError("broken")) })
+}
@@ -0,0 +1,99 @@
+package trxstore
+
+import (
+	"context"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type janitor struct {
+	stop      chan struct{}
+	done      chan struct{}
+	startOnce sync.Once
+	stopOnce  sync.Once
+	started   atomic.Bool
+	logger    *slog.Logger
+	strict    bool
+}
+
+func newJanitor(logger *slog.Logger) *janitor {
+	return &janitor{stop: make(chan struct{}), done: make(chan struct{}), logger: logger}
+}
+
+// start runs sweep every interval until the janitor is closed or ctx is
+// done. Only the first call has an effect.
+func (j *janitor) start(ctx context.Context, clock Clock, interval time.Duration, sweep func()) {
+	j.startOnce.Do(func() {
+		j.started.Store(true)
+		go j.run(ctx, clock.NewTicker(interval), sweep)
+	})
+}
+
+func (j *janitor) run(ctx context.Context, timer Ticker, sweep func()) {
+	defer close(j.done)
+	defer timer.Stop()
+
+	var ctxDone <-chan struct{}
+	if ctx != nil {
+		ctxDone = ctx.Done()
+	}
+
+	for {
+		select {
+		case <-timer.C():
+			j.sweep(sweep)
+		case <-ctxDone:
+			return
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// sweep runs one sweep, logging a panic instead of letting it end the
+// cleanup for good. In strict mode broken invariants are not recovered.
+func (j *janitor) sweep(sweep func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, invariant := r.(invariantError); invariant && j.strict {
+				panic(r)
+			}
+			j.logger.Error("trxstore: cleanup panicked", "panic", r)
+		}
+	}()
+
+	sweep()
+}
+
+func (j *janitor) closed() bool {
+	select {
+	case <-j.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// close stops the janitor and waits until a running sweep has finished.
+// It must not be called from within a sweep.
+func (j *janitor) close() {
+	j.stopOnce.Do(func() {
+		close(j.stop)
+	})
+	if j.started.Load() {
+		<-j.done
+	}
+}
+
+// jitter returns interval moved by a random amount of at most fraction of
+// it in either direction.
+func jitter(interval time.Duration, fraction float64) time.Duration {
+	if fraction <= 0 {
+		return interval
+	}
+
+	return time.Duration(float64(interval) * (1 + fraction*(2*rand.Float64()-1)))
+}
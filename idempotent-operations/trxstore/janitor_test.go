@@ -0,0 +1,177 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestJitter(t *testing.T) {
+	if res := jitter(time.Second, 0); res != time.Second {
+		t.Fatalf("interval changed without jitter: %v", res)
+	}
+
+	seen := map[time.Duration]struct{}{}
+	for i := 0; i < 100; i++ {
+		res := jitter(time.Second, 0.1)
+		if res < 900*time.Millisecond || res > 1100*time.Millisecond {
+			t.Fatalf("interval %v outside of jitter band", res)
+		}
+		seen[res] = struct{}{}
+	}
+	if len(seen) < 2 {
+		t.Fatalf("jitter not randomized")
+	}
+}
+
+func TestCloseWaitsForJanitor(t *testing.T) {
+	clock := manualclock.New()
+	release := make(chan struct{})
+	sweeping := make(chan struct{})
+	store := NewBytesTRXStore(time.Second, WithClock(clock), WithOnExpire(func(uuid.UUID, []byte) {
+		close(sweeping)
+		<-release
+	}))
+
+	store.Store(uuid.New(), nil)
+	clock.Advance(2 * time.Second)
+	<-sweeping
+
+	closed := make(chan struct{})
+	go func() {
+		store.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+		t.Fatalf("close returned during a sweep")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	<-closed
+	select {
+	case <-store.janitor.done:
+	default:
+		t.Fatalf("janitor still running after close")
+	}
+}
+
+func TestInlineExpiry(t *testing.T) {
+	clock := manualclock.New()
+	var expired int
+	// a zero TTL runs no cleanup, so only stores reclaim expired entries
+	store := NewBytesTRXStore(0, WithClock(clock), WithShards(1), WithInlineExpiry(4),
+		WithOnExpire(func(uuid.UUID, []byte) { expired++ }))
+	defer store.Close()
+	if store.janitor.started.Load() {
+		t.Fatalf("cleanup started for a zero ttl")
+	}
+
+	for i := 0; i < 10; i++ {
+		store.StoreWithTTL(uuid.New(), []byte("value"), time.Second)
+	}
+	kept := uuid.New()
+	store.Store(kept, []byte("value"))
+	clock.Advance(2 * time.Second)
+
+	store.Store(uuid.New(), nil)
+	if expired != 4 || store.Len() != 8 {
+		t.Fatalf("expected 4 expired and 8 left after one store, got %d and %d", expired, store.Len())
+	}
+	store.Store(uuid.New(), nil)
+	store.Store(uuid.New(), nil)
+	if expired != 10 || store.Len() != 4 || store.Bytes() != int64(len("value")) {
+		t.Fatalf("expected all 10 expired, got %d with %d entries of %d bytes left", expired, store.Len(), store.Bytes())
+	}
+	if _, exists := store.Lookup(kept); !exists {
+		t.Fatalf("entry without ttl expired")
+	}
+}
+
+func TestInlineExpiryKeepingCreationTime(t *testing.T) {
+	clock := manualclock.New()
+	opts := []Option{WithClock(clock), WithShards(1), WithInlineExpiry(4)}
+
+	// the re-stored entry keeps its creation time, but expiry is judged
+	// at the time of the store
+	anchored := NewBytesTRXStore(time.Minute, append(opts, WithAnchoredExpiry(true))...)
+	anchored.Close()
+	counter := NewCounterTRXStore(time.Minute, opts...)
+	counter.Close()
+
+	trx := uuid.New()
+	anchored.StoreWithTTL(uuid.New(), nil, 10*time.Second)
+	anchored.Store(trx, nil)
+	counter.store.StoreWithTTL(uuid.New(), nil, 10*time.Second)
+	counter.Increment(trx, 1)
+	clock.Advance(30 * time.Second)
+
+	anchored.Store(trx, nil)
+	if anchored.Len() != 1 {
+		t.Fatalf("anchored store left %d entries, want the due one expired", anchored.Len())
+	}
+	counter.Increment(trx, 1)
+	if counter.Len() != 1 {
+		t.Fatalf("increment left %d entries, want the due one expired", counter.Len())
+	}
+}
+
+func TestWithoutBackgroundCleanup(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	before := runtime.NumGoroutine()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithoutBackgroundCleanup())
+	defer store.Close()
+	if runtime.NumGoroutine() > before || store.janitor.started.Load() {
+		t.Fatalf("background cleanup started")
+	}
+
+	store.Store(trx, []byte("value"))
+	clock.Advance(time.Minute + time.Nanosecond)
+	if store.Check(trx) != nil {
+		t.Fatalf("expired trx returned")
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expired trx removed without a cleanup")
+	}
+	store.ForceCleanup()
+	if store.Len() != 0 {
+		t.Fatalf("ForceCleanup did not remove the expired trx")
+	}
+}
+
+func TestTick(t *testing.T) {
+	clock := manualclock.New()
+	expired, release := make(chan uuid.UUID), make(chan struct{})
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithoutBackgroundCleanup(),
+		WithOnExpire(func(trx uuid.UUID, _ []byte) {
+			expired <- trx
+			<-release
+		}))
+	defer store.Close()
+
+	trx := uuid.New()
+	store.Store(trx, nil)
+	store.Tick()
+	if store.Len() != 1 {
+		t.Fatalf("tick removed a live trx")
+	}
+
+	clock.Advance(time.Minute + time.Nanosecond)
+	ticked := make(chan struct{})
+	go func() {
+		defer close(ticked)
+		store.Tick()
+	}()
+	// the first tick is stuck in OnExpire, an overlapping one returns
+	<-expired
+	store.Tick()
+	close(release)
+	<-ticked
+	if store.Len() != 0 || store.Stats().Swept != 1 {
+		t.Fatalf("expected the trx swept once, got %d entries and %+v", store.Len(), store.Stats())
+	}
+}
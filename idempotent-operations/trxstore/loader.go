@@ -0,0 +1,30 @@
+package trxstore
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"time"
+)
+
+var ErrNoLoader = errors.New("trxstore: no loader configured")
+
+// Loader produces the result of trx on a cache miss in Load.
+type Loader func(ctx context.Context, trx uuid.UUID) ([]byte, error)
+
+// Load returns the live value of trx or calls the loader set by WithLoader
+// to produce and store it. Concurrent calls for the same trx call the
+// loader once, as in DoCtx. Loader errors are returned but not stored, so
+// the next Load tries again.
+func (p *BytesTrxStore) Load(ctx context.Context, trx uuid.UUID) ([]byte, error) {
+	if p.opts.loader == nil {
+		return nil, ErrNoLoader
+	}
+	if p.opts.onSlowOp != nil {
+		defer p.timeOp(opLoad, trx, time.Now())
+	}
+
+	return p.DoCtx(ctx, trx, func() ([]byte, error) {
+		return p.opts.loader(ctx, trx)
+	})
+}
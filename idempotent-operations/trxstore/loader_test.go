@@ -0,0 +1,56 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	trx := uuid.New()
+	expectedErr := errors.New("failed")
+	var calls atomic.Int32
+	store := NewBytesTRXStore(time.Minute, WithLoader(func(ctx context.Context, id uuid.UUID) ([]byte, error) {
+		if calls.Add(1) == 1 {
+			return nil, expectedErr
+		}
+		time.Sleep(20 * time.Millisecond)
+		return id[:], nil
+	}))
+	defer store.Close()
+
+	if _, err := store.Load(context.Background(), trx); !errors.Is(err, expectedErr) {
+		t.Fatalf("expected loader error, got %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := store.Load(context.Background(), trx)
+			if err != nil || !bytes.Equal(res, trx[:]) {
+				t.Errorf("unexpected result %v, %v", res, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 2 {
+		t.Fatalf("loader called %d times", calls.Load())
+	}
+}
+
+func TestLoadWithoutLoader(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if _, err := store.Load(context.Background(), uuid.New()); !errors.Is(err, ErrNoLoader) {
+		t.Fatalf("expected ErrNoLoader, got %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package trxstore
+
+import (
+	"container/heap"
+	"github.com/google/uuid"
+	"slices"
+	"sort"
+	"time"
+)
+
+// EntryMeta describes a live entry, see CheckWithMeta and TopExpiring.
+type EntryMeta struct {
+	// Trx is the key of the entry.
+	Trx uuid.UUID
+	// CreatedAt is when the current TTL of the entry started: its last
+	// store, or its first one with WithAnchoredExpiry, moved forward by
+	// Touch and by reads with WithSlidingExpiration.
+	CreatedAt time.Time
+	// ExpiresAt is zero for entries that never expire.
+	ExpiresAt time.Time
+	// Version is the version reported by CheckVersioned.
+	Version uint64
+	// ReadsLeft is the number of reads left to an entry stored with
+	// StoreWithMaxReads, and zero for entries without a read limit.
+	ReadsLeft int
+}
+
+// CheckWithMeta is like CheckVersioned, but returns all metadata of the
+// entry, read together with the value, for example to log how old a
+// replayed result is.
+func (p *BytesTrxStore) CheckWithMeta(trx uuid.UUID) (result []byte, meta EntryMeta, ok bool) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	result, ok = p.get(s, trx, now)
+	if ok {
+		p.accessed(s, trx, now)
+		meta = s.describe(trx)
+	}
+	s.cacheLock.Unlock()
+	p.countLookup(ok)
+
+	return result, meta, ok
+}
+
+// describe returns the metadata of the held entry trx. It must be called
+// with the shard lock held.
+func (s *shard) describe(trx uuid.UUID) EntryMeta {
+	meta := s.cacheMeta[trx]
+	res := EntryMeta{
+		Trx:       trx,
+		CreatedAt: s.createdAt[trx],
+		ExpiresAt: meta.deadline,
+		Version:   meta.version,
+		ReadsLeft: meta.reads,
+	}
+	if meta.deadline.Equal(neverExpires) {
+		res.ExpiresAt = time.Time{}
+	}
+
+	return res
+}
+
+// TopExpiring returns up to n live entries with the earliest deadlines,
+// soonest first, for example for an admin view of what is about to leave
+// the dedup window. Entries that never expire are left out, and so are
+// the values. Each shard is read under its read lock in turn, so the
+// result is consistent per shard but not across shards.
+func (p *BytesTrxStore) TopExpiring(n int) []EntryMeta {
+	if n <= 0 {
+		return nil
+	}
+
+	now := p.opts.clock.Now()
+	var res []EntryMeta
+	for _, s := range p.shards {
+		s.cacheLock.RLock()
+		res = append(res, s.soonest(n, now)...)
+		s.cacheLock.RUnlock()
+	}
+	slices.SortFunc(res, func(a, b EntryMeta) int {
+		return a.ExpiresAt.Compare(b.ExpiresAt)
+	})
+	if len(res) > n {
+		res = res[:n]
+	}
+
+	return res
+}
+
+// soonest returns up to n live entries of the shard by ascending deadline.
+// It walks the expiry heap best first, so it visits O(n) heap slots rather
+// than the whole shard. It must be called with the shard lock held.
+func (s *shard) soonest(n int, now time.Time) []EntryMeta {
+	var res []EntryMeta
+	walk := &heapWalk{entries: s.expiry}
+	if len(s.expiry) > 0 {
+		walk.next = []int{0}
+	}
+	for len(res) < n && walk.Len() > 0 {
+		i := heap.Pop(walk).(int)
+		meta := s.expiry[i]
+		if meta.deadline.Equal(neverExpires) {
+			break
+		}
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(s.expiry) {
+				heap.Push(walk, child)
+			}
+		}
+		if !now.After(meta.deadline) {
+			res = append(res, s.describe(meta.trx))
+		}
+	}
+
+	return res
+}
+
+// heapWalk is a heap of indexes into the heap entries, ordered like the
+// entries they point to, for walking entries best first.
+type heapWalk struct {
+	entries sort.Interface
+	next    []int
+}
+
+func (w *heapWalk) Len() int {
+	return len(w.next)
+}
+
+func (w *heapWalk) Less(i, j int) bool {
+	return w.entries.Less(w.next[i], w.next[j])
+}
+
+func (w *heapWalk) Swap(i, j int) {
+	w.next[i], w.next[j] = w.next[j], w.next[i]
+}
+
+func (w *heapWalk) Push(x any) {
+	w.next = append(w.next, x.(int))
+}
+
+func (w *heapWalk) Pop() any {
+	n := len(w.next)
+	res := w.next[n-1]
+	w.next = w.next[:n-1]
+
+	return res
+}
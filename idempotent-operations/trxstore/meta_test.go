@@ -0,0 +1,81 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestCheckWithMeta(t *testing.T) {
+	trx, forever, limited := uuid.New(), uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(30*time.Second, WithClock(clock))
+	defer store.Close()
+
+	if _, _, ok := store.CheckWithMeta(trx); ok {
+		t.Fatalf("missing trx found")
+	}
+
+	stored := clock.Now()
+	store.Store(trx, []byte("first"))
+	store.Store(trx, []byte("second"))
+	store.StoreWithTTL(forever, nil, 0)
+	store.StoreWithMaxReads(limited, nil, 3)
+	clock.Advance(3 * time.Second)
+
+	res, meta, ok := store.CheckWithMeta(trx)
+	if !ok || !bytes.Equal(res, []byte("second")) {
+		t.Fatalf("got %q, %v", res, ok)
+	}
+	want := EntryMeta{Trx: trx, CreatedAt: stored, ExpiresAt: stored.Add(30 * time.Second), Version: 2}
+	if meta != want {
+		t.Fatalf("got %+v, want %+v", meta, want)
+	}
+	if _, meta, _ := store.CheckWithMeta(forever); !meta.ExpiresAt.IsZero() {
+		t.Fatalf("never expiring entry expires at %v", meta.ExpiresAt)
+	}
+	if _, meta, _ := store.CheckWithMeta(limited); meta.ReadsLeft != 3 {
+		t.Fatalf("got %d reads left, want 3", meta.ReadsLeft)
+	}
+
+	clock.Advance(30 * time.Second)
+	if _, meta, ok := store.CheckWithMeta(trx); ok || meta != (EntryMeta{}) {
+		t.Fatalf("expired trx returned with %+v", meta)
+	}
+}
+
+func TestTopExpiring(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(4))
+	defer store.Close()
+
+	if store.TopExpiring(3) != nil {
+		t.Fatalf("empty store has expiring entries")
+	}
+
+	expired := uuid.New()
+	store.StoreWithTTL(expired, nil, time.Second)
+	store.StoreWithTTL(uuid.New(), nil, 0)
+	var trxs []uuid.UUID
+	for i := 10; i > 0; i-- {
+		trx := uuid.New()
+		trxs = append([]uuid.UUID{trx}, trxs...)
+		store.StoreWithTTL(trx, []byte("value"), time.Duration(i)*time.Minute)
+	}
+	clock.Advance(2 * time.Second)
+
+	top := store.TopExpiring(3)
+	if len(top) != 3 {
+		t.Fatalf("got %d entries, want 3", len(top))
+	}
+	for i, meta := range top {
+		if meta.Trx != trxs[i] {
+			t.Fatalf("entry %d is %v, want %v", i, meta.Trx, trxs[i])
+		}
+	}
+	if all := store.TopExpiring(100); len(all) != len(trxs) {
+		t.Fatalf("got %d entries, want the %d expiring live ones", len(all), len(trxs))
+	}
+}
@@ -0,0 +1,582 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"log/slog"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	ttl                   time.Duration
+	ctx                   context.Context
+	slidingExpiration     bool
+	clock                 Clock
+	onExpire              func(trx uuid.UUID, value []byte)
+	shards                int
+	shardHasher           func(trx uuid.UUID) uint64
+	cleanupInterval       time.Duration
+	maxEntries            int
+	maxBytes              int64
+	onEvict               func(trx uuid.UUID, value []byte)
+	onRemove              func(trx uuid.UUID, value []byte, reason EvictionReason)
+	onExpireBatch         func(entries []ExpireEvent)
+	tracer                Tracer
+	anchoredExpiry        bool
+	expireChannel         int
+	loader                Loader
+	slowOpThreshold       time.Duration
+	onSlowOp              func(op string, trx uuid.UUID, took time.Duration)
+	logger                *slog.Logger
+	name                  string
+	cleanupJitter         float64
+	ttlJitter             float64
+	inFlightStorePolicy   InFlightStorePolicy
+	bloomFilterSize       int
+	inlineExpiry          int
+	valueTTL              time.Duration
+	compressionThreshold  int
+	encryptionKey         []byte
+	sharedCleanup         bool
+	noCleanup             bool
+	strictMode            bool
+	initialCapacity       int
+	cleanupBatchSize      int
+	writeThrough          TrxStore
+	writeThroughAsync     bool
+	writeThroughQueueSize int
+	writeThroughFull      WriteThroughFullPolicy
+	writeThroughMaxWait   time.Duration
+	evictionPolicy        EvictionPolicy
+	onConflict            func(trx uuid.UUID, old, new []byte)
+	equals                func(a, b []byte) bool
+	maxValueSize          int
+	truncateValues        bool
+	expiryWarning         time.Duration
+	codec                 any
+}
+
+func newOptions(opts []Option) options {
+	res := options{
+		ttl:                   defaultTTL,
+		clock:                 realClock{},
+		shards:                defaultShards,
+		shardHasher:           fnvHash,
+		equals:                bytes.Equal,
+		cleanupInterval:       sleepBetweenExpireCheck,
+		logger:                slog.New(slog.DiscardHandler),
+		evictionPolicy:        LRU,
+		writeThroughQueueSize: writeThroughQueueSize,
+	}
+	for _, opt := range opts {
+		opt(&res)
+	}
+	if res.name != "" {
+		res.logger = res.logger.With("store", res.name)
+	}
+
+	return res
+}
+
+// defaultTTL is the TTL of stores created by New without WithTTL, a
+// common retry window for idempotency keys.
+const defaultTTL = 24 * time.Hour
+
+// WithTTL sets the store-wide TTL, 24h by default. Zero means entries never
+// expire, and negative values make New fail with ErrInvalidTTL.
+func WithTTL(ttl time.Duration) Option {
+	return func(o *options) {
+		o.ttl = ttl
+	}
+}
+
+// WithContext stops the background cleanup once ctx is done, as Close
+// would. By default the cleanup runs until Close.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithSlidingExpiration makes every successful Check or Lookup restart the
+// entry's TTL. It is off by default. Reads take the write lock in this
+// mode.
+func WithSlidingExpiration(enabled bool) Option {
+	return func(o *options) {
+		o.slidingExpiration = enabled
+	}
+}
+
+// WithClock replaces the wall clock, mostly so tests can move time
+// forward without sleeping. nil is ignored.
+func WithClock(clock Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// WithOnExpire registers a callback for entries removed by the background
+// cleanup because their TTL passed. It runs on the cleanup goroutine after
+// the lock is released, so it may use the store. Entries expiring in the
+// same sweep are reported in no particular order, and a slow callback
+// only delays the next sweep. There is no callback by default.
+func WithOnExpire(onExpire func(trx uuid.UUID, value []byte)) Option {
+	return func(o *options) {
+		o.onExpire = onExpire
+	}
+}
+
+// WithShards splits the store into n independently locked shards. It
+// defaults to 16; values below 1 are ignored.
+func WithShards(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.shards = n
+		}
+	}
+}
+
+// WithShardHasher sets the hash that picks the shard of a key, as hash
+// modulo the shard count. The default FNV-1a spreads random UUIDs evenly,
+// but its low bits only depend on the low bits of each key byte, so keys
+// that differ only in high bits, as some synthetic or sequential UUIDs
+// do, can pile up in a few shards. A better mixing function for the key
+// distribution balances them again. hasher must be deterministic and safe
+// for concurrent use; nil is ignored.
+func WithShardHasher(hasher func(trx uuid.UUID) uint64) Option {
+	return func(o *options) {
+		if hasher != nil {
+			o.shardHasher = hasher
+		}
+	}
+}
+
+// WithoutBackgroundCleanup starts no cleanup goroutine. Reads still never
+// return expired entries, but expired entries keep using memory and
+// OnExpire does not fire until Tick or ForceCleanup is called, a store
+// with WithInlineExpiry removes them, or they are stored over. Healthy
+// reports such stores as healthy until closed.
+func WithoutBackgroundCleanup() Option {
+	return func(o *options) {
+		o.noCleanup = true
+	}
+}
+
+// WithStrictMode makes broken internal invariants panic, for example a
+// key held without its expiry metadata, to catch bugs in development,
+// also from the background cleanup, which then crashes the process.
+// Without it, which is the default, the cleanup logs such shards at error
+// level and repairs them by dropping the inconsistent entries. Panics of
+// user callbacks are recovered and logged either way.
+func WithStrictMode(strict bool) Option {
+	return func(o *options) {
+		o.strictMode = strict
+	}
+}
+
+// WithCleanupInterval sets how often the background cleanup runs, 100ms by
+// default. Reads never return expired entries, so the interval only bounds
+// how long expired entries keep using memory and how late OnExpire fires.
+// Non-positive values are ignored.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(o *options) {
+		if interval > 0 {
+			o.cleanupInterval = interval
+		}
+	}
+}
+
+// WithMaxEntries caps the number of entries. When a new key would exceed
+// the cap, an expired entry is dropped if there is one, otherwise the least
+// recently stored or read one, unless WithEvictionPolicy picks another
+// order. The cap is split evenly between shards and the policy runs per
+// shard, so use WithShards(1) for an exact global one. Reads take the
+// write lock in this mode. There is no cap by default or for values
+// below 1.
+func WithMaxEntries(n int) Option {
+	return func(o *options) {
+		o.maxEntries = n
+	}
+}
+
+// WithMaxBytes caps the total size of held values. Entries are evicted in
+// the same order as for WithMaxEntries, and the limit is split between
+// shards the same way. The two limits can be combined. There is no limit
+// by default or for values below 1.
+func WithMaxBytes(n int64) Option {
+	return func(o *options) {
+		o.maxBytes = n
+	}
+}
+
+// WithOnExpireBatch registers a callback for the entries WithOnExpire
+// reports, all entries removed by one cleanup sweep in a single slice, so
+// that consumers can act on them in bulk. Entries that expire outside
+// sweeps, such as used up StoreWithMaxReads entries, come in batches of
+// what the call removed. It is called after the per-entry callbacks, at
+// most once per sweep and never with an empty slice, and the slice is the
+// callback's to keep. It can be combined with WithOnExpire. There is no
+// callback by default.
+func WithOnExpireBatch(onExpireBatch func(entries []ExpireEvent)) Option {
+	return func(o *options) {
+		o.onExpireBatch = onExpireBatch
+	}
+}
+
+// WithOnEvict registers a callback for entries dropped to stay within the
+// configured entry or byte limits. TTL expiry is reported through WithOnExpire
+// instead. It runs after the lock is released, so it may use the store.
+// There is no callback by default.
+func WithOnEvict(onEvict func(trx uuid.UUID, value []byte)) Option {
+	return func(o *options) {
+		o.onEvict = onEvict
+	}
+}
+
+// WithOnRemove registers a callback for every entry reported to WithOnExpire
+// or WithOnEvict, with the reason it was removed, so that entries that
+// aged out can be told from those evicted under memory pressure. It runs
+// after those callbacks, under the same rules. There is no callback by
+// default.
+func WithOnRemove(onRemove func(trx uuid.UUID, value []byte, reason EvictionReason)) Option {
+	return func(o *options) {
+		o.onRemove = onRemove
+	}
+}
+
+// WithTracer traces every Check and Store through tracer. See the trxotel
+// package for an OpenTelemetry implementation. Nothing is traced by
+// default.
+func WithTracer(tracer Tracer) Option {
+	return func(o *options) {
+		o.tracer = tracer
+	}
+}
+
+// WithAnchoredExpiry makes storing a live trx again keep the time it was
+// first stored, so the dedup window is anchored to the first occurrence
+// instead of being extended by every retry. It is off by default.
+func WithAnchoredExpiry(enabled bool) Option {
+	return func(o *options) {
+		o.anchoredExpiry = enabled
+	}
+}
+
+// WithExpireChannel makes expired and evicted entries also be reported on
+// ExpireChannel, which buffers up to buffer events, 1024 for values below
+// 1. Events that arrive while the buffer is full are dropped, so a slow
+// consumer never blocks the cleanup or the writers. ExpireChannel is nil
+// without this option.
+func WithExpireChannel(buffer int) Option {
+	return func(o *options) {
+		o.expireChannel = defaultExpireChannelBuffer
+		if buffer > 0 {
+			o.expireChannel = buffer
+		}
+	}
+}
+
+// WithLoader sets the function Load uses to produce missing results.
+// Without it Load fails with ErrNoLoader.
+func WithLoader(loader Loader) Option {
+	return func(o *options) {
+		o.loader = loader
+	}
+}
+
+// WithSlowOpThreshold calls onSlowOp with the operation, trx and duration
+// of every lookup, store or Load taking longer than threshold. op is
+// "check" for the Check and Lookup family, "store" for the Store family and
+// "load" for Load, which includes the loader and any wait for a concurrent
+// Load of trx. onSlowOp runs synchronously on the calling goroutine. Slow
+// operations are not reported by default, and nothing is timed unless
+// threshold is positive and onSlowOp is not nil.
+func WithSlowOpThreshold(threshold time.Duration, onSlowOp func(op string, trx uuid.UUID, took time.Duration)) Option {
+	return func(o *options) {
+		if threshold > 0 && onSlowOp != nil {
+			o.slowOpThreshold = threshold
+			o.onSlowOp = onSlowOp
+		}
+	}
+}
+
+// WithLogger enables logging of background and error events: panics in the
+// cleanup, for example from an OnExpire callback, at error level, and
+// cleanup runs and evictions with their reason at debug level. Check and
+// Store never log otherwise. The cleanup keeps running after a panic, but
+// callbacks for the rest of that sweep are skipped. Nothing is logged by
+// default; nil is ignored.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithName names the store, to tell many stores of a process apart: the
+// name is added to every log record as the store attribute, reported by
+// Name and Stats, shown by Debug, used by promcollector when no name is
+// given and passed to tracers, see StoreName. Stores of a StoreGroup are
+// named after their namespace. Stores are unnamed by default.
+func WithName(name string) Option {
+	return func(o *options) {
+		o.name = name
+	}
+}
+
+// WithCleanupJitter picks the cleanup interval of each store at random
+// within fraction of the configured one, so that many stores created at
+// once do not all sweep at the same moments. This trades a little expiry
+// timing precision for smoother CPU usage. Values outside (0, 1) are
+// ignored.
+func WithCleanupJitter(fraction float64) Option {
+	return func(o *options) {
+		if fraction > 0 && fraction < 1 {
+			o.cleanupJitter = fraction
+		}
+	}
+}
+
+// WithTTLJitter spreads the store-wide TTL of each key at random within
+// fraction of it, so that bursts of entries stored together do not all
+// expire at once. The deduplication window then varies by key: with a TTL
+// of 10 minutes and a fraction of 0.1 some retries are recognized for only
+// 9 minutes. Each key keeps its TTL across stores, and TTLs given to
+// StoreWithTTL or StoreWithDeadline are used as is. TTLs are exact by
+// default, and values outside (0, 1) are ignored.
+func WithTTLJitter(fraction float64) Option {
+	return func(o *options) {
+		if fraction > 0 && fraction < 1 {
+			o.ttlJitter = fraction
+		}
+	}
+}
+
+// WithInFlightStorePolicy sets what a Store of a trx does while a Do, DoCtx
+// or Load of trx runs its fn, InFlightStoreOverwrite by default. The policy
+// applies to the Store family, StoreCtx and the E methods, StoreMany and
+// StoreAll. Swap, GetOrStore, StoreIfAbsent, StoreIf, CompareAndSwap,
+// Merge, StoreVersioned, Restore and the increments of CounterTrxStore
+// bypass it: they always store their value and wake the waiters of Do
+// with it.
+func WithInFlightStorePolicy(policy InFlightStorePolicy) Option {
+	return func(o *options) {
+		o.inFlightStorePolicy = policy
+	}
+}
+
+// WithBloomFilter keeps a bloom filter of the stored keys in every shard,
+// sized for about expectedEntries keys in total, so that the Check and
+// Lookup family can report keys that were never stored without taking a
+// lock. This pays off when most lookups miss. Expired and deleted keys stay
+// in the filter and make it report other keys as possibly present, which
+// only costs a normal lookup; the cleanup rebuilds the filter of a shard
+// once it holds more removed keys than live ones, growing it if there are
+// more live keys than expected. There is no filter by default, and
+// non-positive sizes are ignored.
+func WithBloomFilter(expectedEntries int) Option {
+	return func(o *options) {
+		if expectedEntries > 0 {
+			o.bloomFilterSize = expectedEntries
+		}
+	}
+}
+
+// WithInlineExpiry makes every store remove up to sampleCount due entries
+// of the shard it writes to, soonest deadline first, under the lock it
+// already holds. This reclaims memory without the background cleanup, for
+// example in stores with a zero TTL, which run no cleanup, or after Close
+// where no goroutines may be left running. The removals are reported like
+// those of the cleanup, but add to the time each store holds the lock, and
+// only shards that are written to are cleaned, so a small sample keeps
+// stores fast while writes still outpace expiry. There is no inline expiry
+// by default; non-positive counts are ignored.
+func WithInlineExpiry(sampleCount int) Option {
+	return func(o *options) {
+		if sampleCount > 0 {
+			o.inlineExpiry = sampleCount
+		}
+	}
+}
+
+// WithTombstones drops the value of an entry once it is valueTTL old but
+// keeps its key until the entry expires, so that a large result is not
+// held longer than it can be replayed while retries are still recognized.
+// Such a tombstone is found by Lookup without a value, so a caller knows
+// not to run the operation again; CheckState and State report it as
+// StateTombstone to tell it from a stored empty value. The age counts from
+// the last store of the entry, also with a sliding expiration, and entries
+// whose TTL is not longer than valueTTL expire with their value. Values
+// are freed by the cleanup and then no longer count towards WithMaxBytes;
+// snapshots hold tombstones as empty values. There are no tombstones by
+// default or for non-positive valueTTL.
+func WithTombstones(valueTTL time.Duration) Option {
+	return func(o *options) {
+		o.valueTTL = max(valueTTL, 0)
+	}
+}
+
+// WithCompression deflates values larger than threshold bytes before
+// storing them and inflates them again on read. Byte limits and Bytes count
+// the stored size. Smaller values, and values that do not shrink, are kept
+// as is with one byte of overhead. Values are not compressed by default,
+// and non-positive thresholds are ignored.
+func WithCompression(threshold int) Option {
+	return func(o *options) {
+		if threshold > 0 {
+			o.compressionThreshold = threshold
+		}
+	}
+}
+
+// WithEncryption encrypts values in memory with AES-GCM under key, which
+// must be 16, 24 or 32 bytes long. Values that fail to decrypt are logged
+// and reported as missing. Combined with WithCompression, values are
+// compressed before they are encrypted. Values are kept in plain text by
+// default.
+func WithEncryption(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = bytes.Clone(key)
+	}
+}
+
+// WithInitialCapacity pre-sizes the store for n entries, split between
+// shards, so that filling a large store does not keep growing its maps.
+// By default the maps start empty.
+func WithInitialCapacity(n int) Option {
+	return func(o *options) {
+		o.initialCapacity = n
+	}
+}
+
+// WithCleanupBatchSize caps how many entries the cleanup removes per lock
+// acquisition. The lock is released between batches, so reads and writes
+// are not stalled while a large number of entries expires at once. By
+// default, and for values below 1, each shard is swept in one go.
+func WithCleanupBatchSize(n int) Option {
+	return func(o *options) {
+		o.cleanupBatchSize = n
+	}
+}
+
+// WithWriteThrough mirrors every result the store writes, through Store
+// and its variants, the batch and conditional stores, Merge, Transform and
+// Do, to secondary, so that it can repopulate the store after a restart.
+// Each result is stored in secondary with the time its entry has left,
+// and with no TTL if the entry never expires. If secondary implements
+// TrxStoreE, its errors are returned wrapped in ErrWriteThrough by
+// StoreCtx, the E methods and Complete, after the store itself has kept
+// the result, and logged by methods that return no error. With async
+// false each write reaches secondary before Store returns. With async
+// true writes are queued and applied in the background: they reach
+// secondary eventually and at most once unless dropped from a full queue,
+// see WithWriteThroughQueue and WriteThroughStats, failures are logged,
+// and Close waits for the queue to drain. Nothing is mirrored by default.
+func WithWriteThrough(secondary TrxStore, async bool) Option {
+	return func(o *options) {
+		o.writeThrough = secondary
+		o.writeThroughAsync = async
+	}
+}
+
+// WithWriteThroughQueue sets the size of the asynchronous write-through
+// queue, 1024 by default, and what happens while it is full, by default
+// WriteThroughDropNewest. With WriteThroughBlock a store waits up to
+// maxWait for room, which bounds how long a slow secondary can stall
+// Store; maxWait is ignored by the other policies. Dropped writes are
+// logged and counted in WriteThroughStats. Non-positive sizes are ignored.
+func WithWriteThroughQueue(size int, whenFull WriteThroughFullPolicy, maxWait time.Duration) Option {
+	return func(o *options) {
+		if size > 0 {
+			o.writeThroughQueueSize = size
+		}
+		o.writeThroughFull = whenFull
+		o.writeThroughMaxWait = maxWait
+	}
+}
+
+// WithEvictionPolicy sets the order in which entries are evicted to stay
+// within the WithMaxEntries or WithMaxBytes limits: LRU (the default), LFU,
+// FIFO or a custom policy. Expired entries are dropped before the policy
+// is asked. A nil policy is ignored.
+func WithEvictionPolicy(policy EvictionPolicy) Option {
+	return func(o *options) {
+		if policy != nil {
+			o.evictionPolicy = policy
+		}
+	}
+}
+
+// WithConflictDetection calls onConflict when Store, StoreWithTTL,
+// StoreCtx, StoreWithMaxReads or StoreMany overwrite a live trx with a
+// different value, which usually means the operation behind trx is not
+// deterministic. The store still overwrites the value as before. Swap,
+// CompareAndSwap and the other conditional writes replace values on
+// purpose and are not reported. onConflict runs after the lock is
+// released, so it may use the store. There is no callback by default.
+func WithConflictDetection(onConflict func(trx uuid.UUID, old, new []byte)) Option {
+	return func(o *options) {
+		o.onConflict = onConflict
+	}
+}
+
+// WithEquals sets when two values count as equal for WithConflictDetection
+// and CompareAndSwap, for example to compare JSON semantically rather than
+// byte for byte. Values are compared as passed in and as held, after
+// decoding with WithCompression or WithEncryption. equals runs under the
+// shard write lock, so it must be fast, must not use the store and must
+// have no side effects. The default is bytes.Equal; nil is ignored.
+func WithEquals(equals func(a, b []byte) bool) Option {
+	return func(o *options) {
+		if equals != nil {
+			o.equals = equals
+		}
+	}
+}
+
+// WithMaxValueSize guards the store against oversized values. It applies
+// to every write, from Store and its variants, StoreMany and, through
+// them, Do and Load, to Swap, GetOrStore, Merge, the conditional stores
+// and Restore. Values longer than n bytes are rejected and counted in
+// Stats: StoreCtx and the E methods return ErrValueTooLarge, the
+// conditional stores report false, and the others log a warning and store
+// nothing. With truncate true such values are cut to n bytes and stored
+// instead. There is no limit by default or for values below 1.
+func WithMaxValueSize(n int, truncate bool) Option {
+	return func(o *options) {
+		o.maxValueSize = n
+		o.truncateValues = truncate
+	}
+}
+
+// WithExpiryWarning makes the cleanup report entries that expire within
+// lead on ExpiryWarnings, so that the resources behind them can be renewed
+// in time. Warnings are sent by the sweeps, so lead should be well above
+// the cleanup interval, and are dropped like expire events when the
+// channel buffer of 1024 is full. Each entry is warned about at most once:
+// a sliding expiration or Touch pushing its deadline out after the warning
+// does not re-arm it, only storing trx again does. There are no warnings
+// by default or for a non-positive lead.
+func WithExpiryWarning(lead time.Duration) Option {
+	return func(o *options) {
+		o.expiryWarning = lead
+	}
+}
+
+// WithCodec makes a TypedTrxStore or KeyedTrxStore of T values keep them
+// encoded by codec, so that stored results are copies callers may mutate.
+// Without it typed stores keep values as is. Stores of other value types
+// panic on it, and a BytesTrxStore ignores it.
+func WithCodec[T any](codec Codec[T]) Option {
+	return func(o *options) {
+		if codec != nil {
+			o.codec = codec
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package trxstore
+
+import (
+	"encoding/gob"
+	"fmt"
+	"github.com/google/uuid"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type persistedEntry struct {
+	Trx       uuid.UUID
+	Value     []byte
+	ExpiresAt time.Time
+}
+
+// SaveToFile writes all live entries to path so a restarted process can
+// pick them up with LoadFromFile. The file is written to a temporary file
+// first and renamed into place, with the entries ordered by key, so saving
+// the same contents gives the same file. This is a best-effort warm
+// restart aid, not a durable store: entries written after the last save
+// are lost.
+func (p *BytesTrxStore) SaveToFile(path string) error {
+	return p.SaveToFileFunc(path, func(uuid.UUID, []byte) bool { return true })
+}
+
+// SaveToFileFunc is like SaveToFile, but only writes the entries for which
+// keep returns true, for example to persist only results that are costly
+// to produce again. keep is called on a snapshot, so it may use the store.
+func (p *BytesTrxStore) SaveToFileFunc(path string, keep func(trx uuid.UUID, value []byte) bool) error {
+	now := p.opts.clock.Now()
+	snapshot := p.SortedSnapshot()
+	entries := make([]persistedEntry, 0, len(snapshot))
+	for _, entry := range snapshot {
+		if keep(entry.Trx, entry.Value) {
+			entries = append(entries, persistedEntry{Trx: entry.Trx, Value: entry.Value, ExpiresAt: now.Add(entry.TTL)})
+		}
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("create temp file for %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(entries); err != nil {
+		tmp.Close()
+		return fmt.Errorf("encode trx store to %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("sync %s: %w", tmp.Name(), err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("rename %s to %s: %w", tmp.Name(), path, err)
+	}
+
+	return nil
+}
+
+// LoadFromFile restores entries saved by SaveToFile. Entries that expired
+// while the process was down are skipped, and keys already live in the
+// store are kept. Saved deadlines are wall clock times, so they are only
+// as accurate as the wall clock across the restart; once loaded, entries
+// expire after the time they had left, like other entries.
+func (p *BytesTrxStore) LoadFromFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var entries []persistedEntry
+	if err := gob.NewDecoder(file).Decode(&entries); err != nil {
+		return fmt.Errorf("decode trx store from %s: %w", path, err)
+	}
+
+	now := p.opts.clock.Now()
+	snapshot := make(map[uuid.UUID]SnapshotEntry, len(entries))
+	for _, entry := range entries {
+		snapshot[entry.Trx] = SnapshotEntry{Value: entry.Value, TTL: entry.ExpiresAt.Sub(now)}
+	}
+	p.RestoreWithTTL(snapshot)
+
+	return nil
+}
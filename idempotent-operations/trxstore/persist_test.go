@@ -0,0 +1,107 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSaveLoadFile(t *testing.T) {
+	short, long := uuid.New(), uuid.New()
+	path := filepath.Join(t.TempDir(), "trx.gob")
+	clock := manualclock.New()
+
+	source := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer source.Close()
+	source.StoreWithTTL(short, []byte("short"), 10*time.Second)
+	source.Store(long, []byte("long"))
+	if err := source.SaveToFile(path); err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	clock.Advance(20 * time.Second)
+	target := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer target.Close()
+	if err := target.LoadFromFile(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if _, exists := target.Lookup(short); exists {
+		t.Fatalf("trx expired during downtime was loaded")
+	}
+	if !bytes.Equal(target.Check(long), []byte("long")) {
+		t.Fatalf("live trx not loaded")
+	}
+	if ttl, _ := target.TTLRemaining(long); ttl != 40*time.Second {
+		t.Fatalf("unexpected TTL after load %s", ttl)
+	}
+}
+
+func TestSaveToFileFunc(t *testing.T) {
+	costly, cheap := uuid.New(), uuid.New()
+	path := filepath.Join(t.TempDir(), "trx.gob")
+	clock := manualclock.New()
+
+	source := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer source.Close()
+	source.Store(costly, []byte("costly"))
+	source.Store(cheap, []byte("cheap"))
+	err := source.SaveToFileFunc(path, func(_ uuid.UUID, value []byte) bool {
+		return bytes.Equal(value, []byte("costly"))
+	})
+	if err != nil {
+		t.Fatalf("save: %v", err)
+	}
+
+	clock.Advance(15 * time.Second)
+	target := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer target.Close()
+	if err := target.LoadFromFile(path); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+
+	if _, exists := target.Lookup(cheap); exists || target.Len() != 1 {
+		t.Fatalf("filtered out trx was loaded")
+	}
+	if ttl, _ := target.TTLRemaining(costly); ttl != 45*time.Second {
+		t.Fatalf("unexpected TTL after load %s", ttl)
+	}
+}
+
+func TestSaveToFileReproducible(t *testing.T) {
+	dir := t.TempDir()
+	store := NewBytesTRXStore(time.Minute, WithClock(manualclock.New()))
+	defer store.Close()
+	for i := 0; i < 50; i++ {
+		store.Store(uuid.New(), []byte{byte(i)})
+	}
+
+	var saved [][]byte
+	for _, name := range []string{"first.gob", "second.gob"} {
+		path := filepath.Join(dir, name)
+		if err := store.SaveToFile(path); err != nil {
+			t.Fatalf("save: %v", err)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		saved = append(saved, content)
+	}
+	if !bytes.Equal(saved[0], saved[1]) {
+		t.Fatalf("saving the same contents gave different files")
+	}
+}
+
+func TestLoadMissingFile(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if err := store.LoadFromFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatalf("missing file loaded")
+	}
+}
@@ -0,0 +1,62 @@
+package trxstore
+
+import "github.com/google/uuid"
+
+// Pin keeps a live trx from being evicted to stay within WithMaxEntries
+// or WithMaxBytes, for example the dedup token of a long-running saga. It
+// still expires with its TTL. Pinned entries count towards the limits, and
+// when only pinned entries are left to evict, stores evict nothing and go
+// over the limit instead of failing, as counted by Stats in OverLimit.
+// The pin lasts until Unpin or until the entry is removed, also across
+// stores of trx. It returns false for missing and expired entries.
+func (p *BytesTrxStore) Pin(trx uuid.UUID) bool {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	if _, exists := s.lookup(trx, p.opts.clock.Now()); !exists {
+		return false
+	}
+	s.pin(trx)
+
+	return true
+}
+
+// Unpin makes a pinned trx evictable again, as if it was just stored. It
+// returns false for missing and expired entries.
+func (p *BytesTrxStore) Unpin(trx uuid.UUID) bool {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	if _, exists := s.lookup(trx, p.opts.clock.Now()); !exists {
+		return false
+	}
+	s.unpin(trx)
+
+	return true
+}
+
+// pin hides the held entry trx from the eviction policy. It must be called
+// with the shard write lock held.
+func (s *shard) pin(trx uuid.UUID) {
+	meta := s.cacheMeta[trx]
+	if meta.pinned {
+		return
+	}
+	meta.pinned = true
+	if s.evictor != nil {
+		s.evictor.Removed(trx)
+	}
+}
+
+func (s *shard) unpin(trx uuid.UUID) {
+	meta := s.cacheMeta[trx]
+	if !meta.pinned {
+		return
+	}
+	meta.pinned = false
+	if s.evictor != nil {
+		s.evictor.Stored(trx)
+	}
+}
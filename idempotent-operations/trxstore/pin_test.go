@@ -0,0 +1,54 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestPin(t *testing.T) {
+	for _, policy := range []EvictionPolicy{LRU, LFU, FIFO} {
+		pinned := uuid.New()
+		clock := manualclock.New()
+		store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithMaxEntries(3), WithEvictionPolicy(policy))
+		store.Close()
+
+		if store.Pin(uuid.New()) {
+			t.Fatalf("missing trx pinned")
+		}
+		store.Store(pinned, nil)
+		if !store.Pin(pinned) {
+			t.Fatalf("live trx not pinned")
+		}
+		for i := 0; i < 10; i++ {
+			store.Store(uuid.New(), nil)
+		}
+		if _, exists := store.Lookup(pinned); !exists || store.Len() != 3 {
+			t.Fatalf("pinned trx evicted, %d entries left", store.Len())
+		}
+
+		// with every entry pinned, a new key is stored over the limit
+		store.Clear()
+		others := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+		for _, trx := range others {
+			store.Store(trx, nil)
+			store.Pin(trx)
+		}
+		store.Store(uuid.New(), nil)
+		if store.Len() != 4 {
+			t.Fatalf("expected 4 entries over the limit, got %d", store.Len())
+		}
+		store.Unpin(others[0])
+		store.Store(uuid.New(), nil)
+		if _, exists := store.Lookup(others[0]); exists {
+			t.Fatalf("unpinned trx not evicted")
+		}
+
+		clock.Advance(time.Minute + time.Nanosecond)
+		store.ForceCleanup()
+		if _, exists := store.Lookup(others[1]); exists || store.Len() != 0 {
+			t.Fatalf("pinned trx outlived its ttl")
+		}
+	}
+}
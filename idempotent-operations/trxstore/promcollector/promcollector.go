@@ -0,0 +1,73 @@
+package promcollector
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Source is the part of a store the collector reads from.
+// *trxstore.BytesTrxStore implements it.
+type Source interface {
+	Stats() trxstore.Stats
+	Len() int
+	Bytes() int64
+}
+
+// Collector exposes the counters and size of a store as Prometheus
+// metrics, labeled with the store name.
+type Collector struct {
+	source      Source
+	entries     *prometheus.Desc
+	bytes       *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	stores      *prometheus.Desc
+	expirations *prometheus.Desc
+	evictions   *prometheus.Desc
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a collector of source labeled with name, or with
+// the trxstore.WithName name of the store if name is empty.
+func NewCollector(source Source, name string) *Collector {
+	if name == "" {
+		name = source.Stats().Name
+	}
+	labels := prometheus.Labels{"store": name}
+	desc := func(metric, help string) *prometheus.Desc {
+		return prometheus.NewDesc("trxstore_"+metric, help, nil, labels)
+	}
+
+	return &Collector{
+		source:      source,
+		entries:     desc("entries", "Number of entries held by the store."),
+		bytes:       desc("bytes", "Total size of values held by the store."),
+		hits:        desc("hits_total", "Lookups that found a live entry."),
+		misses:      desc("misses_total", "Lookups that found no live entry."),
+		stores:      desc("stores_total", "Stored results."),
+		expirations: desc("expirations_total", "Entries removed because their TTL passed."),
+		evictions:   desc("evictions_total", "Entries removed to stay within capacity limits."),
+	}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.entries
+	ch <- c.bytes
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.stores
+	ch <- c.expirations
+	ch <- c.evictions
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.source.Stats()
+	ch <- prometheus.MustNewConstMetric(c.entries, prometheus.GaugeValue, float64(c.source.Len()))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(c.source.Bytes()))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.Hits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.Misses))
+	ch <- prometheus.MustNewConstMetric(c.stores, prometheus.CounterValue, float64(stats.Stores))
+	ch <- prometheus.MustNewConstMetric(c.expirations, prometheus.CounterValue, float64(stats.Expirations))
+	ch <- prometheus.MustNewConstMetric(c.evictions, prometheus.CounterValue, float64(stats.Evictions))
+}
@@ -0,0 +1,61 @@
+package promcollector
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	trx := uuid.New()
+	store.Check(trx)
+	store.Store(trx, []byte("test"))
+	store.Check(trx)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(store, "payments"))
+
+	expected := `
+# HELP trxstore_bytes Total size of values held by the store.
+# TYPE trxstore_bytes gauge
+trxstore_bytes{store="payments"} 4
+# HELP trxstore_entries Number of entries held by the store.
+# TYPE trxstore_entries gauge
+trxstore_entries{store="payments"} 1
+# HELP trxstore_hits_total Lookups that found a live entry.
+# TYPE trxstore_hits_total counter
+trxstore_hits_total{store="payments"} 1
+# HELP trxstore_misses_total Lookups that found no live entry.
+# TYPE trxstore_misses_total counter
+trxstore_misses_total{store="payments"} 1
+`
+	err := testutil.GatherAndCompare(registry, strings.NewReader(expected),
+		"trxstore_bytes", "trxstore_entries", "trxstore_hits_total", "trxstore_misses_total")
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCollectorStoreName(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute, trxstore.WithName("refunds"))
+	defer store.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(store, ""))
+
+	expected := `
+# HELP trxstore_entries Number of entries held by the store.
+# TYPE trxstore_entries gauge
+trxstore_entries{store="refunds"} 0
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "trxstore_entries"); err != nil {
+		t.Fatal(err)
+	}
+}
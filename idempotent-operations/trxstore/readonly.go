@@ -0,0 +1,42 @@
+package trxstore
+
+import "github.com/google/uuid"
+
+// TrxReader is the read side of a BytesTrxStore, see ReadOnly.
+type TrxReader interface {
+	Check(trx uuid.UUID) []byte
+	Lookup(trx uuid.UUID) ([]byte, bool)
+	Len() int
+	Stats() Stats
+}
+
+var _ TrxReader = (*BytesTrxStore)(nil)
+
+// readOnly hides the store behind TrxReader, so the handle cannot be
+// asserted back to the store.
+type readOnly struct {
+	store *BytesTrxStore
+}
+
+// ReadOnly returns a handle of the store that can only read, for
+// components such as metrics exporters or debug handlers that must never
+// change dedup state. The handle cannot be converted back into the store.
+func (p *BytesTrxStore) ReadOnly() TrxReader {
+	return readOnly{store: p}
+}
+
+func (r readOnly) Check(trx uuid.UUID) []byte {
+	return r.store.Check(trx)
+}
+
+func (r readOnly) Lookup(trx uuid.UUID) ([]byte, bool) {
+	return r.store.Lookup(trx)
+}
+
+func (r readOnly) Len() int {
+	return r.store.Len()
+}
+
+func (r readOnly) Stats() Stats {
+	return r.store.Stats()
+}
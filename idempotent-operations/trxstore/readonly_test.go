@@ -0,0 +1,30 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	reader := store.ReadOnly()
+	if _, ok := reader.(TrxStore); ok {
+		t.Fatalf("read-only handle can store")
+	}
+	if _, exists := reader.Lookup(trx); exists {
+		t.Fatalf("missing trx found")
+	}
+
+	store.Store(trx, []byte("value"))
+	if !bytes.Equal(reader.Check(trx), []byte("value")) || reader.Len() != 1 {
+		t.Fatalf("read-only handle does not see the store")
+	}
+	if stats := reader.Stats(); stats.Hits != 1 || stats.Misses != 1 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
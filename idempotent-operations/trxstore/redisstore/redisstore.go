@@ -0,0 +1,152 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"time"
+)
+
+var (
+	_ trxstore.TrxStore    = (*RedisTrxStore)(nil)
+	_ trxstore.TrxStoreE   = (*RedisTrxStore)(nil)
+	_ trxstore.TTLReporter = (*RedisTrxStore)(nil)
+)
+
+// RedisTrxStore keeps idempotency results in Redis, so that retries are
+// recognized by every instance of a service. The TrxStore methods treat
+// Redis failures as misses; use the E variants to handle them.
+type RedisTrxStore struct {
+	client redis.UniversalClient
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisTRXStore stores keys as prefix followed by the trx, so several
+// logical stores can share one Redis. The client is owned by the caller.
+func NewRedisTRXStore(client redis.UniversalClient, prefix string, ttl time.Duration) *RedisTrxStore {
+	return &RedisTrxStore{
+		client: client,
+		prefix: prefix,
+		ttl:    ttl,
+	}
+}
+
+func (p *RedisTrxStore) Check(trx uuid.UUID) []byte {
+	res, _ := p.Lookup(trx)
+
+	return res
+}
+
+func (p *RedisTrxStore) Lookup(trx uuid.UUID) ([]byte, bool) {
+	res, exists, err := p.LookupE(context.Background(), trx)
+	if err != nil {
+		return nil, false
+	}
+
+	return res, exists
+}
+
+func (p *RedisTrxStore) Store(trx uuid.UUID, result []byte) {
+	_ = p.StoreE(context.Background(), trx, result)
+}
+
+func (p *RedisTrxStore) StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration) {
+	_ = p.StoreWithTTLE(context.Background(), trx, result, ttl)
+}
+
+func (p *RedisTrxStore) Delete(trx uuid.UUID) bool {
+	res, _ := p.DeleteE(context.Background(), trx)
+
+	return res
+}
+
+// TTLRemaining returns how long trx stays live, or the maximum duration
+// for keys without expiry. Redis failures are reported as missing keys.
+func (p *RedisTrxStore) TTLRemaining(trx uuid.UUID) (time.Duration, bool) {
+	res, exists, err := p.TTLRemainingE(context.Background(), trx)
+	if err != nil {
+		return 0, false
+	}
+
+	return res, exists
+}
+
+// Close does nothing, the Redis client is left to its owner.
+func (p *RedisTrxStore) Close() {}
+
+func (p *RedisTrxStore) LookupE(ctx context.Context, trx uuid.UUID) ([]byte, bool, error) {
+	res, err := p.client.Get(ctx, p.key(trx)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("get trx %s: %w", trx, err)
+	}
+
+	return res, true, nil
+}
+
+func (p *RedisTrxStore) StoreE(ctx context.Context, trx uuid.UUID, result []byte) error {
+	return p.StoreWithTTLE(ctx, trx, result, p.ttl)
+}
+
+// StoreWithTTLE stores result with its own ttl, which Redis keeps with
+// millisecond precision. A zero ttl stores result without expiry.
+func (p *RedisTrxStore) StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("set trx %s: %w", trx, trxstore.ErrInvalidTTL)
+	}
+	if err := p.client.Set(ctx, p.key(trx), result, ttl).Err(); err != nil {
+		return fmt.Errorf("set trx %s: %w", trx, err)
+	}
+
+	return nil
+}
+
+// StoreIfAbsentE stores result only if trx has no live value yet, using a
+// single SET NX PX. It reports whether result was stored.
+func (p *RedisTrxStore) StoreIfAbsentE(ctx context.Context, trx uuid.UUID, result []byte) (bool, error) {
+	res, err := p.client.SetNX(ctx, p.key(trx), result, p.ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("set nx trx %s: %w", trx, err)
+	}
+
+	return res, nil
+}
+
+func (p *RedisTrxStore) DeleteE(ctx context.Context, trx uuid.UUID) (bool, error) {
+	res, err := p.client.Del(ctx, p.key(trx)).Result()
+	if err != nil {
+		return false, fmt.Errorf("del trx %s: %w", trx, err)
+	}
+
+	return res > 0, nil
+}
+
+// TTLRemainingE is TTLRemaining with the Redis failures reported. It reads
+// the remaining time with PTTL, so keys due within the millisecond are
+// reported as missing.
+func (p *RedisTrxStore) TTLRemainingE(ctx context.Context, trx uuid.UUID) (time.Duration, bool, error) {
+	res, err := p.client.PTTL(ctx, p.key(trx)).Result()
+	if err != nil {
+		return 0, false, fmt.Errorf("pttl trx %s: %w", trx, err)
+	}
+	switch {
+	case res == -1:
+		// the key has no expiry
+		return math.MaxInt64, true, nil
+	case res <= 0:
+		return 0, false, nil
+	}
+
+	return res, true, nil
+}
+
+func (p *RedisTrxStore) key(trx uuid.UUID) string {
+	return p.prefix + trx.String()
+}
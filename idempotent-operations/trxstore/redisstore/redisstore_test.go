@@ -0,0 +1,128 @@
+package redisstore
+
+import (
+	"bytes"
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/trxstoretest"
+	"github.com/redis/go-redis/v9"
+	"math"
+	"testing"
+	"time"
+)
+
+func newTestStore(t *testing.T, ttl time.Duration) (*RedisTrxStore, *miniredis.Miniredis) {
+	t.Helper()
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	return NewRedisTRXStore(client, "trx:", ttl), server
+}
+
+func TestRedisTrxStore(t *testing.T) {
+	trx := uuid.New()
+	store, server := newTestStore(t, time.Minute)
+
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("new trx already exists")
+	}
+
+	store.Store(trx, []byte("test"))
+	if !bytes.Equal(store.Check(trx), []byte("test")) {
+		t.Fatalf("trx not exists")
+	}
+	if !server.Exists("trx:" + trx.String()) {
+		t.Fatalf("key prefix not applied")
+	}
+
+	server.FastForward(time.Minute + time.Second)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("trx not expired")
+	}
+}
+
+func TestRedisTrxStoreIfAbsent(t *testing.T) {
+	trx := uuid.New()
+	store, _ := newTestStore(t, time.Minute)
+	ctx := context.Background()
+
+	if stored, err := store.StoreIfAbsentE(ctx, trx, []byte("first")); err != nil || !stored {
+		t.Fatalf("first store failed: %v", err)
+	}
+	if stored, err := store.StoreIfAbsentE(ctx, trx, []byte("second")); err != nil || stored {
+		t.Fatalf("second store overwrote: %v", err)
+	}
+	if !bytes.Equal(store.Check(trx), []byte("first")) {
+		t.Fatalf("unexpected value")
+	}
+	if deleted, err := store.DeleteE(ctx, trx); err != nil || !deleted {
+		t.Fatalf("delete failed: %v", err)
+	}
+}
+
+func TestRedisTrxStoreConnectionError(t *testing.T) {
+	store, server := newTestStore(t, time.Minute)
+	server.Close()
+
+	if _, _, err := store.LookupE(context.Background(), uuid.New()); err == nil {
+		t.Fatalf("connection error not returned")
+	}
+	if err := store.StoreE(context.Background(), uuid.New(), nil); err == nil {
+		t.Fatalf("connection error not returned")
+	}
+}
+
+func TestRedisTrxStoreConformance(t *testing.T) {
+	trxstoretest.RunTrxStoreConformance(t, func(t *testing.T, ttl time.Duration) (trxstore.TrxStore, func(time.Duration)) {
+		store, server := newTestStore(t, ttl)
+
+		return store, server.FastForward
+	})
+}
+
+func TestRedisTrxStoreTTLRemaining(t *testing.T) {
+	trx, forever := uuid.New(), uuid.New()
+	store, server := newTestStore(t, time.Minute)
+
+	if _, exists := store.TTLRemaining(trx); exists {
+		t.Fatalf("missing trx has TTL")
+	}
+	store.Store(trx, nil)
+	store.StoreWithTTL(forever, nil, 0)
+	server.FastForward(20 * time.Second)
+	if ttl, exists := store.TTLRemaining(trx); !exists || ttl != 40*time.Second {
+		t.Fatalf("unexpected TTL %v, %v", ttl, exists)
+	}
+	if ttl, _ := store.TTLRemaining(forever); ttl != math.MaxInt64 {
+		t.Fatalf("key without expiry has TTL %v", ttl)
+	}
+
+	server.Close()
+	if _, _, err := store.TTLRemainingE(context.Background(), trx); err == nil {
+		t.Fatalf("connection error not returned")
+	}
+}
+
+func TestTieredOverRedis(t *testing.T) {
+	short, long := uuid.New(), uuid.New()
+	remote, server := newTestStore(t, time.Hour)
+	local := trxstore.NewBytesTRXStore(time.Hour, trxstore.WithClock(trxstoretest.NewManualClock()))
+	defer local.Close()
+	store := trxstore.NewTieredTRXStore(local, remote)
+
+	remote.StoreWithTTL(short, []byte("short"), time.Minute)
+	remote.StoreWithTTL(long, []byte("long"), 2*time.Hour)
+	server.FastForward(30 * time.Second)
+	if !bytes.Equal(store.Check(short), []byte("short")) || !bytes.Equal(store.Check(long), []byte("long")) {
+		t.Fatalf("remote hits not returned")
+	}
+	if ttl, _ := local.TTLRemaining(short); ttl != 30*time.Second {
+		t.Fatalf("local copy outlives the remote entry: %v left", ttl)
+	}
+	if ttl, _ := local.TTLRemaining(long); ttl != time.Hour {
+		t.Fatalf("local copy not capped at the local TTL: %v left", ttl)
+	}
+}
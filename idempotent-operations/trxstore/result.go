@@ -0,0 +1,57 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+type storedResult[T any] struct {
+	value T
+	err   error
+}
+
+// ResultTrxStore memoizes whole operation outcomes, so an operation that
+// failed deterministically replays the same failure instead of running
+// again. Errors are kept in memory as is, so errors.Is and errors.As work
+// on replayed errors just like on the original ones.
+type ResultTrxStore[T any] struct {
+	store *TypedTrxStore[storedResult[T]]
+}
+
+// NewResultTRXStoreWithContext creates a store whose cleanup stops once
+// ctx is done. It panics with ErrInvalidTTL if ttl is negative, and with
+// a zero ttl outcomes never expire.
+func NewResultTRXStoreWithContext[T any](ctx context.Context, ttl time.Duration) *ResultTrxStore[T] {
+	return &ResultTrxStore[T]{store: NewTypedTRXStoreWithContext[storedResult[T]](ctx, ttl)}
+}
+
+func NewResultTRXStore[T any](ttl time.Duration) *ResultTrxStore[T] {
+	return NewResultTRXStoreWithContext[T](nil, ttl)
+}
+
+// StoreResult keeps the outcome of the operation identified by trx, which
+// is value if err is nil and err otherwise.
+func (p *ResultTrxStore[T]) StoreResult(trx uuid.UUID, value T, err error) {
+	p.store.Store(trx, storedResult[T]{value: value, err: err})
+}
+
+// CheckResult returns the stored outcome of trx. ok reports whether there
+// is one; err is the stored failure, not a failure of the lookup.
+func (p *ResultTrxStore[T]) CheckResult(trx uuid.UUID) (value T, ok bool, err error) {
+	res, ok := p.store.Check(trx)
+
+	return res.value, ok, res.err
+}
+
+func (p *ResultTrxStore[T]) Delete(trx uuid.UUID) bool {
+	return p.store.Delete(trx)
+}
+
+func (p *ResultTrxStore[T]) Len() int {
+	return p.store.Len()
+}
+
+func (p *ResultTrxStore[T]) Close() {
+	p.store.Close()
+}
@@ -0,0 +1,28 @@
+package trxstore
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestResultTrxStore(t *testing.T) {
+	succeeded, failed := uuid.New(), uuid.New()
+	errDeclined := errors.New("card declined")
+	store := NewResultTRXStore[int](time.Minute)
+	defer store.Close()
+
+	store.StoreResult(succeeded, 42, nil)
+	store.StoreResult(failed, 0, errDeclined)
+
+	if value, ok, err := store.CheckResult(succeeded); !ok || err != nil || value != 42 {
+		t.Fatalf("unexpected result %d, %v, %v", value, ok, err)
+	}
+	if _, ok, err := store.CheckResult(failed); !ok || !errors.Is(err, errDeclined) {
+		t.Fatalf("unexpected failure %v, %v", ok, err)
+	}
+	if _, ok, err := store.CheckResult(uuid.New()); ok || err != nil {
+		t.Fatalf("unexpected result for missing trx %v, %v", ok, err)
+	}
+}
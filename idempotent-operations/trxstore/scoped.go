@@ -0,0 +1,160 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"time"
+)
+
+// scopedPruneMin is the number of keys a scope tracks before put first
+// drops the keys of entries that are gone.
+const scopedPruneMin = 64
+
+// scoped is a view of a BytesTrxStore whose keys live in their own
+// namespace and are removed from the store when the scope ends.
+type scoped struct {
+	store *BytesTrxStore
+	id    uuid.UUID
+	stop  func() bool
+	lock  sync.RWMutex
+	keys  map[uuid.UUID]struct{}
+	// pruneAt is the number of keys at which put next drops the keys of
+	// entries that expired or were evicted.
+	pruneAt int
+	done    bool
+}
+
+// Scoped returns a view of the store for request or job scoped dedup. Its
+// entries are kept in the store under keys derived from a namespace of
+// their own, so they never collide with the store's or another scope's,
+// and are all deleted once ctx is done or the view is closed. After that
+// the view finds nothing and drops stores. Closing the view leaves the
+// store open.
+func (p *BytesTrxStore) Scoped(ctx context.Context) TrxStore {
+	res := &scoped{
+		store:   p,
+		id:      uuid.New(),
+		keys:    map[uuid.UUID]struct{}{},
+		pruneAt: scopedPruneMin,
+	}
+	res.stop = context.AfterFunc(ctx, res.clear)
+
+	return res
+}
+
+func (s *scoped) key(trx uuid.UUID) uuid.UUID {
+	return uuid.NewSHA1(s.id, trx[:])
+}
+
+func (s *scoped) Check(trx uuid.UUID) []byte {
+	res, _ := s.Lookup(trx)
+	return res
+}
+
+func (s *scoped) Lookup(trx uuid.UUID) ([]byte, bool) {
+	key := s.key(trx)
+	s.lock.RLock()
+	_, known := s.keys[key]
+	done := s.done
+	s.lock.RUnlock()
+	if done {
+		return nil, false
+	}
+
+	res, exists := s.store.Lookup(key)
+	if !exists && known {
+		s.forget(key)
+	}
+
+	return res, exists
+}
+
+// held reports whether the store still holds the entry of key. It must
+// be called with the lock held, so that put cannot store key meanwhile.
+func (s *scoped) held(key uuid.UUID) bool {
+	switch s.store.State(key) {
+	case StateAbsent, StateExpired:
+		return false
+	default:
+		return true
+	}
+}
+
+// forget drops key, which a lookup missed, unless it was stored again.
+func (s *scoped) forget(key uuid.UUID) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if !s.done && !s.held(key) {
+		delete(s.keys, key)
+	}
+}
+
+// prune drops the keys of entries the store no longer holds, so that the
+// keys of a long-lived scope do not grow with every entry it ever stored.
+// It must be called with the lock held.
+func (s *scoped) prune() {
+	for key := range s.keys {
+		if !s.held(key) {
+			delete(s.keys, key)
+		}
+	}
+	s.pruneAt = max(2*len(s.keys), scopedPruneMin)
+}
+
+func (s *scoped) Store(trx uuid.UUID, result []byte) {
+	s.put(trx, func(key uuid.UUID) { s.store.Store(key, result) })
+}
+
+func (s *scoped) StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration) {
+	s.put(trx, func(key uuid.UUID) { s.store.StoreWithTTL(key, result, ttl) })
+}
+
+// put records the key of trx and stores it with store. The lock is held
+// across the store, so clear cannot miss the key.
+func (s *scoped) put(trx uuid.UUID, store func(key uuid.UUID)) {
+	key := s.key(trx)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done {
+		return
+	}
+	if len(s.keys) >= s.pruneAt {
+		s.prune()
+	}
+	s.keys[key] = struct{}{}
+	store(key)
+}
+
+func (s *scoped) Delete(trx uuid.UUID) bool {
+	key := s.key(trx)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done {
+		return false
+	}
+	delete(s.keys, key)
+
+	return s.store.Delete(key)
+}
+
+// Close ends the scope before its context is done.
+func (s *scoped) Close() {
+	s.stop()
+	s.clear()
+}
+
+func (s *scoped) clear() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.done {
+		return
+	}
+	s.done = true
+	for key := range s.keys {
+		s.store.Delete(key)
+	}
+	s.keys = nil
+}
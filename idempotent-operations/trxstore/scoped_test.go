@@ -0,0 +1,90 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestScoped(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	shared := uuid.New()
+	store.Store(shared, []byte("parent"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	scope := store.Scoped(ctx)
+	scope.Store(shared, []byte("scoped"))
+	scope.StoreWithTTL(uuid.New(), nil, 0)
+	if !bytes.Equal(scope.Check(shared), []byte("scoped")) || !bytes.Equal(store.Check(shared), []byte("parent")) {
+		t.Fatalf("scoped entry collides with the parent one")
+	}
+	if store.Len() != 3 {
+		t.Fatalf("expected scoped entries in the parent store, got %d entries", store.Len())
+	}
+
+	cancel()
+	waitFor(t, func() bool { return store.Len() == 1 })
+	if _, exists := scope.Lookup(shared); exists {
+		t.Fatalf("scoped entry found after the scope ended")
+	}
+	scope.Store(uuid.New(), nil)
+	if store.Len() != 1 || !bytes.Equal(store.Check(shared), []byte("parent")) {
+		t.Fatalf("expected only the parent entry left, got %d entries", store.Len())
+	}
+}
+
+func TestScopedClose(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	scope := store.Scoped(context.Background())
+	trx := uuid.New()
+	scope.Store(trx, nil)
+	if !scope.Delete(trx) || scope.Delete(trx) {
+		t.Fatalf("unexpected delete result")
+	}
+	scope.Store(trx, nil)
+	scope.Close()
+	if store.Len() != 0 {
+		t.Fatalf("closed scope left %d entries", store.Len())
+	}
+	store.Store(trx, nil)
+	if _, exists := store.Lookup(trx); !exists {
+		t.Fatalf("closing the scope closed the store")
+	}
+}
+
+func TestScopedPrunesKeys(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+	scope := store.Scoped(context.Background()).(*scoped)
+	defer scope.Close()
+
+	looked := uuid.New()
+	scope.Store(looked, nil)
+	for i := 0; i < scopedPruneMin-2; i++ {
+		scope.Store(uuid.New(), nil)
+	}
+	clock.Advance(time.Minute + time.Nanosecond)
+	if _, exists := scope.Lookup(looked); exists || len(scope.keys) != scopedPruneMin-2 {
+		t.Fatalf("key of the missed entry kept, %d keys", len(scope.keys))
+	}
+
+	// the last store finds scopedPruneMin keys and prunes them first
+	kept := uuid.New()
+	scope.Store(kept, nil)
+	scope.Store(uuid.New(), nil)
+	scope.Store(uuid.New(), nil)
+	if len(scope.keys) != 3 {
+		t.Fatalf("keys of expired entries kept, %d keys", len(scope.keys))
+	}
+	if _, exists := scope.Lookup(kept); !exists {
+		t.Fatalf("live entry lost by pruning")
+	}
+}
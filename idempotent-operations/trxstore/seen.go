@@ -0,0 +1,61 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"time"
+)
+
+// SeenTrxStore remembers which keys were seen within the TTL, without a
+// result, for pure dedup workloads that never read one back. It is a
+// KeyedTrxStore of empty values, so an entry holds no more than its key
+// and deadline, a fraction of the memory of an empty value in a
+// BytesTrxStore. Like typed stores, it only takes the WithClock,
+// WithCleanupInterval and WithLogger options.
+type SeenTrxStore struct {
+	store *KeyedTrxStore[uuid.UUID, struct{}]
+}
+
+// NewSeenTRXStoreWithContext creates a store whose cleanup stops once ctx
+// is done. It panics with ErrInvalidTTL if ttl is negative, and with a
+// zero ttl marks never expire.
+func NewSeenTRXStoreWithContext(ctx context.Context, ttl time.Duration, opts ...Option) *SeenTrxStore {
+	return &SeenTrxStore{store: NewKeyedTRXStoreWithContext[uuid.UUID, struct{}](ctx, ttl, opts...)}
+}
+
+func NewSeenTRXStore(ttl time.Duration, opts ...Option) *SeenTrxStore {
+	return NewSeenTRXStoreWithContext(nil, ttl, opts...)
+}
+
+// SetSeen marks trx as seen for the TTL and reports whether it already
+// was, so checking and marking a key is a single atomic step.
+func (p *SeenTrxStore) SetSeen(trx uuid.UUID) bool {
+	// empty values have nothing to encode that could fail
+	seen, _ := p.store.set(trx, struct{}{})
+
+	return seen
+}
+
+// Seen reports whether trx was marked within its TTL.
+func (p *SeenTrxStore) Seen(trx uuid.UUID) bool {
+	_, exists := p.store.Check(trx)
+
+	return exists
+}
+
+func (p *SeenTrxStore) Delete(trx uuid.UUID) bool {
+	return p.store.Delete(trx)
+}
+
+func (p *SeenTrxStore) Len() int {
+	return p.store.Len()
+}
+
+// TTL returns the TTL the store was created with.
+func (p *SeenTrxStore) TTL() time.Duration {
+	return p.store.TTL()
+}
+
+func (p *SeenTrxStore) Close() {
+	p.store.Close()
+}
@@ -0,0 +1,68 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestSeenTrxStore(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewSeenTRXStore(time.Minute, WithClock(clock))
+	store.Close()
+
+	if store.Seen(trx) || store.SetSeen(trx) {
+		t.Fatalf("new trx already seen")
+	}
+	if !store.Seen(trx) || !store.SetSeen(trx) {
+		t.Fatalf("marked trx not seen")
+	}
+
+	clock.Advance(time.Minute)
+	if !store.Seen(trx) {
+		t.Fatalf("trx expired at its ttl")
+	}
+	clock.Advance(time.Nanosecond)
+	if store.Seen(trx) {
+		t.Fatalf("trx not expired")
+	}
+	store.store.cleanupExpired()
+	if store.Len() != 0 {
+		t.Fatalf("expired trx not cleaned up")
+	}
+	if store.SetSeen(trx) || !store.Delete(trx) || store.Delete(trx) {
+		t.Fatalf("expired trx reported as seen or delete not reported correctly")
+	}
+}
+
+// BenchmarkSeenMemory compares the memory of value-less markers against
+// empty values in a BytesTrxStore, see B/op.
+func BenchmarkSeenMemory(b *testing.B) {
+	keys := make([]uuid.UUID, 10000)
+	for i := range keys {
+		keys[i] = uuid.New()
+	}
+
+	b.Run("seen", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			store := NewSeenTRXStore(time.Minute)
+			for _, trx := range keys {
+				store.SetSeen(trx)
+			}
+			store.Close()
+		}
+	})
+	b.Run("bytes", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			store := NewBytesTRXStore(time.Minute)
+			for _, trx := range keys {
+				store.Store(trx, nil)
+			}
+			store.Close()
+		}
+	})
+}
@@ -0,0 +1,365 @@
+package trxstore
+
+import (
+	"container/heap"
+	"github.com/google/uuid"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const defaultShards = 16
+
+// approxEntryOverhead is the estimated memory used per entry apart from
+// its value: three map slots keyed by the 16 byte UUID (value slice
+// header, createdAt and meta pointer, about 160 bytes with map overhead),
+// the entryMeta (104), its expiry heap slot (8) and the eviction policy
+// bookkeeping when limits are set (about 56).
+const approxEntryOverhead = 328
+
+type expiredEntry struct {
+	trx    uuid.UUID
+	value  []byte
+	reason EvictionReason
+	// tombstone is set for an entry removed after its value was dropped,
+	// see WithTombstones, which has no value to report.
+	tombstone bool
+}
+
+type shard struct {
+	cache      map[uuid.UUID][]byte
+	cacheLock  sync.RWMutex
+	createdAt  map[uuid.UUID]time.Time
+	cacheMeta  map[uuid.UUID]*entryMeta
+	expiry     expiryHeap
+	evictor    Evictor
+	maxEntries int
+	maxBytes   int64
+	inFlight   map[uuid.UUID]*flight
+	waiters    map[uuid.UUID][]*flight
+	bloom      atomic.Pointer[bloomFilter]
+	bloomSize  int
+	bloomStale int
+	inlineMax  int
+	valueTTL   time.Duration
+	drops      dropHeap
+	overflows  atomic.Uint64
+	size       atomic.Int64
+	bytes      atomic.Int64
+}
+
+func newShard(maxEntries int, maxBytes int64, capacity int, policy EvictionPolicy) *shard {
+	var evictor Evictor
+	if maxEntries > 0 || maxBytes > 0 {
+		evictor = policy()
+	}
+
+	return &shard{
+		cache:      make(map[uuid.UUID][]byte, capacity),
+		cacheLock:  sync.RWMutex{},
+		createdAt:  make(map[uuid.UUID]time.Time, capacity),
+		cacheMeta:  make(map[uuid.UUID]*entryMeta, capacity),
+		expiry:     make(expiryHeap, 0, capacity),
+		evictor:    evictor,
+		maxEntries: maxEntries,
+		maxBytes:   maxBytes,
+		inFlight:   map[uuid.UUID]*flight{},
+		waiters:    map[uuid.UUID][]*flight{},
+	}
+}
+
+// store saves result and returns the entries evicted to make room for it.
+// The deadline counts from createdAt, which is older than now for entries
+// that keep their creation time, while expiry is judged at now.
+func (s *shard) store(trx uuid.UUID, result []byte, createdAt, now time.Time, ttl time.Duration) []expiredEntry {
+	return s.storeUnit(trx, result, createdAt, now, ttl, nil)
+}
+
+// storeUnit is store for a member of a unit stored by StoreAll, which
+// does not evict the other members of unit to make room.
+func (s *shard) storeUnit(trx uuid.UUID, result []byte, createdAt, now time.Time, ttl time.Duration, unit map[uuid.UUID]struct{}) []expiredEntry {
+	evicted := s.makeRoom(trx, int64(len(result)), now, unit)
+	meta, exists := s.cacheMeta[trx]
+	if exists {
+		if s.expired(trx, now) {
+			meta.version = 0
+		}
+		meta.deadline = deadline(createdAt, ttl)
+		meta.ttl = ttl
+		meta.reads = 0
+		meta.warned = false
+		meta.manual = false
+		heap.Fix(&s.expiry, meta.index)
+	} else {
+		meta = &entryMeta{trx: trx, deadline: deadline(createdAt, ttl), ttl: ttl}
+		heap.Push(&s.expiry, meta)
+		s.cacheMeta[trx] = meta
+		s.size.Add(1)
+	}
+	if s.evictor != nil && !meta.pinned {
+		s.evictor.Stored(trx)
+	}
+	if f := s.bloom.Load(); f != nil && !exists {
+		f.add(trx)
+	}
+	meta.version++
+	s.bytes.Add(int64(len(result) - len(s.cache[trx])))
+	s.cache[trx] = result
+	s.createdAt[trx] = createdAt
+	s.scheduleDrop(meta, createdAt)
+
+	if f, exists := s.inFlight[trx]; exists {
+		delete(s.inFlight, trx)
+		f.resolve(result, true)
+	}
+	if waiters, exists := s.waiters[trx]; exists {
+		delete(s.waiters, trx)
+		for _, f := range waiters {
+			f.resolve(result, true)
+		}
+	}
+
+	return s.expireInline(evicted, trx, now)
+}
+
+// expireInline removes up to inlineMax due entries other than the just
+// stored keep and appends them to expired.
+func (s *shard) expireInline(expired []expiredEntry, keep uuid.UUID, now time.Time) []expiredEntry {
+	for i := 0; i < s.inlineMax && len(s.expiry) > 0 && s.expiry[0].trx != keep; i++ {
+		entry, exists := s.popExpired(now)
+		if !exists {
+			break
+		}
+		expired = append(expired, entry)
+	}
+
+	return expired
+}
+
+// makeRoom evicts other entries until storing size bytes under trx fits
+// the shard limits, dropping expired entries first and then the ones
+// chosen by the eviction policy. A value larger than the byte limit is
+// stored alone, and the members of unit, if any, are never evicted.
+func (s *shard) makeRoom(trx uuid.UUID, size int64, now time.Time, unit map[uuid.UUID]struct{}) []expiredEntry {
+	var evicted []expiredEntry
+	for {
+		reason, over := s.overLimit(trx, size)
+		if !over {
+			return evicted
+		}
+
+		victim, expired := s.victim(trx, unit, now)
+		if victim == trx {
+			s.overflows.Add(1)
+
+			return evicted
+		}
+		if expired {
+			reason = s.expiryReason(victim)
+		}
+
+		evicted = append(evicted, s.evict(victim, reason, now))
+	}
+}
+
+func (s *shard) overLimit(trx uuid.UUID, size int64) (EvictionReason, bool) {
+	current, exists := s.cache[trx]
+	if s.maxEntries > 0 && !exists && len(s.cache) >= s.maxEntries {
+		return ReasonCapacity, true
+	}
+	if s.maxBytes > 0 && s.bytes.Load()-int64(len(current))+size > s.maxBytes {
+		return ReasonMaxBytes, true
+	}
+
+	return 0, false
+}
+
+// victim picks the next entry to evict other than exclude and the
+// members of unit. It returns exclude when there is nothing else to evict.
+func (s *shard) victim(exclude uuid.UUID, unit map[uuid.UUID]struct{}, now time.Time) (uuid.UUID, bool) {
+	if len(s.expiry) > 0 && now.After(s.expiry[0].deadline) && s.expiry[0].trx != exclude {
+		if _, member := unit[s.expiry[0].trx]; !member {
+			return s.expiry[0].trx, true
+		}
+	}
+
+	if len(unit) == 0 {
+		if victim, ok := s.evictor.Victim(exclude); ok {
+			return victim, false
+		}
+
+		return exclude, false
+	}
+	if e, ok := s.evictor.(unitEvictor); ok {
+		if victim, ok := e.victimOutside(unit); ok {
+			return victim, false
+		}
+
+		return exclude, false
+	}
+	// a custom policy cannot skip the unit, so a member it picks leaves
+	// the shard over its limit
+	if victim, ok := s.evictor.Victim(exclude); ok {
+		if _, member := unit[victim]; !member {
+			return victim, false
+		}
+	}
+
+	return exclude, false
+}
+
+func (s *shard) remove(trx uuid.UUID) ([]byte, bool) {
+	value, exists := s.cache[trx]
+	if !exists {
+		return nil, false
+	}
+
+	meta := s.cacheMeta[trx]
+	heap.Remove(&s.expiry, meta.index)
+	if s.evictor != nil && !meta.pinned {
+		s.evictor.Removed(trx)
+	}
+	delete(s.cacheMeta, trx)
+	delete(s.cache, trx)
+	delete(s.createdAt, trx)
+	s.bloomStale++
+	s.size.Add(-1)
+	s.bytes.Add(-int64(len(value)))
+
+	return value, true
+}
+
+// clear removes all entries, keeping pending flights and waiters. It must
+// be called with the shard write lock held.
+func (s *shard) clear(policy EvictionPolicy) {
+	clear(s.cache)
+	clear(s.createdAt)
+	clear(s.cacheMeta)
+	clear(s.expiry)
+	s.expiry = s.expiry[:0]
+	if s.evictor != nil {
+		s.evictor = policy()
+	}
+	if s.bloom.Load() != nil {
+		s.enableBloom(s.bloomSize)
+		s.bloomStale = 0
+	}
+	s.size.Store(0)
+	s.bytes.Store(0)
+}
+
+func (s *shard) refresh(trx uuid.UUID, now time.Time) {
+	meta := s.cacheMeta[trx]
+	meta.deadline = deadline(now, meta.ttl)
+	heap.Fix(&s.expiry, meta.index)
+	s.createdAt[trx] = now
+}
+
+// expire makes trx due, so the next sweep removes it as expired.
+func (s *shard) expire(trx uuid.UUID) {
+	s.setDeadline(trx, time.Time{})
+	s.cacheMeta[trx].manual = true
+}
+
+func (s *shard) setDeadline(trx uuid.UUID, deadline time.Time) {
+	meta := s.cacheMeta[trx]
+	meta.deadline = deadline
+	heap.Fix(&s.expiry, meta.index)
+}
+
+// replace swaps the value of the held entry trx, keeping its deadline and
+// read limit. It must be called with the shard write lock held.
+func (s *shard) replace(trx uuid.UUID, value []byte) {
+	s.cacheMeta[trx].version++
+	s.bytes.Add(int64(len(value) - len(s.cache[trx])))
+	s.cache[trx] = value
+}
+
+// limitedReads reports whether trx was stored with a read limit. It must
+// be called with the shard lock held.
+func (s *shard) limitedReads(trx uuid.UUID) bool {
+	meta, exists := s.cacheMeta[trx]
+
+	return exists && meta.reads > 0
+}
+
+// consumeRead counts a read of the live entry trx against its read limit
+// and removes it once the limit is used up. It must be called with the
+// shard write lock held.
+func (s *shard) consumeRead(trx uuid.UUID, now time.Time) (expiredEntry, bool) {
+	meta := s.cacheMeta[trx]
+	if meta.reads == 0 {
+		return expiredEntry{}, false
+	}
+
+	meta.reads--
+	if meta.reads > 0 {
+		return expiredEntry{}, false
+	}
+	return s.evict(trx, ReasonMaxReads, now), true
+}
+
+func (s *shard) touchRecency(trx uuid.UUID) {
+	if !s.cacheMeta[trx].pinned {
+		s.evictor.Accessed(trx)
+	}
+}
+
+func (s *shard) expired(trx uuid.UUID, now time.Time) bool {
+	return now.After(s.cacheMeta[trx].deadline)
+}
+
+// lookup returns the live value of trx. It must be called with the shard
+// lock held.
+func (s *shard) lookup(trx uuid.UUID, now time.Time) ([]byte, bool) {
+	res, exists := s.cache[trx]
+	if !exists || s.expired(trx, now) {
+		return nil, false
+	}
+
+	return res, true
+}
+
+// popExpired removes the entry with the earliest deadline if it is due.
+func (s *shard) popExpired(now time.Time) (expiredEntry, bool) {
+	if len(s.expiry) == 0 || !now.After(s.expiry[0].deadline) {
+		return expiredEntry{}, false
+	}
+
+	trx := s.expiry[0].trx
+
+	return s.evict(trx, s.expiryReason(trx), now), true
+}
+
+// evict removes the held entry trx for reason and returns it to be
+// reported, without a value if it is a tombstone.
+func (s *shard) evict(trx uuid.UUID, reason EvictionReason, now time.Time) expiredEntry {
+	tombstone := s.tombstoned(trx, now)
+	value, _ := s.remove(trx)
+	if tombstone {
+		value = nil
+	}
+
+	return expiredEntry{trx: trx, value: value, reason: reason, tombstone: tombstone}
+}
+
+// expiryReason tells the removal of the due entry trx by Expire from one
+// whose deadline passed.
+func (s *shard) expiryReason(trx uuid.UUID) EvictionReason {
+	if s.cacheMeta[trx].manual {
+		return ReasonManual
+	}
+
+	return ReasonExpired
+}
+
+// fnvHash is the default shard hasher, FNV-1a over the key bytes.
+func fnvHash(trx uuid.UUID) uint64 {
+	hash := uint64(14695981039346656037)
+	for _, b := range trx {
+		hash ^= uint64(b)
+		hash *= 1099511628211
+	}
+
+	return hash
+}
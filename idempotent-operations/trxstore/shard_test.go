@@ -0,0 +1,202 @@
+package trxstore
+
+import (
+	"encoding/binary"
+	"github.com/google/uuid"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShardsAggregateLen(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithShards(4))
+	defer store.Close()
+
+	for i := 0; i < 100; i++ {
+		store.Store(uuid.New(), []byte("test"))
+	}
+	if store.Len() != 100 {
+		t.Fatalf("expected 100 entries, got %d", store.Len())
+	}
+}
+
+// mix64 is the splitmix64 finalizer over both halves of the key.
+func mix64(trx uuid.UUID) uint64 {
+	hash := binary.BigEndian.Uint64(trx[:8]) ^ binary.BigEndian.Uint64(trx[8:])
+	hash ^= hash >> 30
+	hash *= 0xbf58476d1ce4e5b9
+	hash ^= hash >> 27
+	hash *= 0x94d049bb133111eb
+	hash ^= hash >> 31
+
+	return hash
+}
+
+// maxShardLen returns the size of the fullest shard after storing keys.
+func maxShardLen(keys []uuid.UUID, opts ...Option) int {
+	store := NewBytesTRXStore(time.Minute, opts...)
+	defer store.Close()
+
+	for _, trx := range keys {
+		store.Store(trx, nil)
+	}
+	var res int
+	for _, s := range store.shards {
+		res = max(res, int(s.size.Load()))
+	}
+
+	return res
+}
+
+func TestShardHasher(t *testing.T) {
+	// keys differing only in the high nibbles of their bytes all share the
+	// low bits of their FNV-1a hash
+	keys := make([]uuid.UUID, 256)
+	for i := range keys {
+		keys[i][14] = byte(i & 0xf0)
+		keys[i][15] = byte(i << 4)
+	}
+
+	if got := maxShardLen(keys); got != len(keys) {
+		t.Fatalf("expected the default hash to put all skewed keys in one shard, fullest has %d", got)
+	}
+	if got := maxShardLen(keys, WithShardHasher(mix64)); got > 2*len(keys)/defaultShards {
+		t.Fatalf("custom hasher left %d of %d keys in one shard", got, len(keys))
+	}
+}
+
+func TestApproxLen(t *testing.T) {
+	few := NewBytesTRXStore(time.Minute, WithShards(approxLenSample))
+	defer few.Close()
+	many := NewBytesTRXStore(time.Minute, WithShards(64))
+	defer many.Close()
+
+	for i := 0; i < 10000; i++ {
+		trx := uuid.New()
+		few.Store(trx, nil)
+		many.Store(trx, nil)
+	}
+	if few.ApproxLen() != few.Len() {
+		t.Fatalf("expected the exact length with few shards, got %d", few.ApproxLen())
+	}
+	for i := 0; i < 100; i++ {
+		if got := many.ApproxLen(); got < 8000 || got > 12000 {
+			t.Fatalf("estimate %d too far from %d", got, many.Len())
+		}
+	}
+}
+
+// BenchmarkLenUnderContention reads the length while every other
+// goroutine stores, as a dashboard polling a hot store does.
+func BenchmarkLenUnderContention(b *testing.B) {
+	for name, size := range map[string]func(*BytesTrxStore) int{
+		"Len":       (*BytesTrxStore).Len,
+		"ApproxLen": (*BytesTrxStore).ApproxLen,
+	} {
+		b.Run(name, func(b *testing.B) {
+			store := NewBytesTRXStore(time.Minute, WithShards(64))
+			defer store.Close()
+
+			b.RunParallel(func(pb *testing.PB) {
+				trx := uuid.New()
+				i := 0
+				for pb.Next() {
+					if i%2 == 0 {
+						size(store)
+					} else {
+						binary.BigEndian.PutUint64(trx[:], uint64(i%4096))
+						store.Store(trx, nil)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkParallelCheckStore(b *testing.B) {
+	keys := make([]uuid.UUID, 1024)
+	for i := range keys {
+		keys[i] = uuid.New()
+	}
+
+	for _, shards := range []int{1, defaultShards} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			store := NewBytesTRXStore(time.Minute, WithShards(shards))
+			defer store.Close()
+			value := []byte("test")
+
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					trx := keys[i%len(keys)]
+					if i%4 == 0 {
+						store.Store(trx, value)
+					} else {
+						store.Check(trx)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
+
+func BenchmarkCleanupNothingExpired(b *testing.B) {
+	const entries = 100000
+
+	b.Run("heap", func(b *testing.B) {
+		store := NewBytesTRXStore(time.Hour)
+		store.Close()
+		for i := 0; i < entries; i++ {
+			store.Store(uuid.New(), nil)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			store.cleanupExpired()
+		}
+	})
+
+	b.Run("full-scan", func(b *testing.B) {
+		expiresAt := map[uuid.UUID]time.Time{}
+		for i := 0; i < entries; i++ {
+			expiresAt[uuid.New()] = time.Now().Add(time.Hour)
+		}
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			now := time.Now()
+			for _, at := range expiresAt {
+				if now.After(at) {
+					b.Fatalf("unexpected expired entry")
+				}
+			}
+		}
+	})
+}
+
+func BenchmarkFill(b *testing.B) {
+	const entries = 50000
+
+	keys := make([]uuid.UUID, entries)
+	for i := range keys {
+		keys[i] = uuid.New()
+	}
+
+	for name, opts := range map[string][]Option{
+		"default":  nil,
+		"presized": {WithInitialCapacity(entries)},
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				store := NewBytesTRXStore(time.Minute, opts...)
+				for _, trx := range keys {
+					store.Store(trx, nil)
+				}
+				store.Close()
+			}
+		})
+	}
+}
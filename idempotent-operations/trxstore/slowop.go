@@ -0,0 +1,21 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// operations reported to WithSlowOpThreshold
+const (
+	opCheck = "check"
+	opStore = "store"
+	opLoad  = "load"
+)
+
+// timeOp reports op to onSlowOp if it took longer than the threshold. Like
+// sweeps it is timed on the wall clock, as the duration is a cost.
+func (p *BytesTrxStore) timeOp(op string, trx uuid.UUID, start time.Time) {
+	if took := time.Since(start); took > p.opts.slowOpThreshold {
+		p.opts.onSlowOp(op, trx, took)
+	}
+}
@@ -0,0 +1,61 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"testing"
+	"time"
+)
+
+type slowOp struct {
+	op   string
+	trx  uuid.UUID
+	took time.Duration
+}
+
+func TestSlowOpThreshold(t *testing.T) {
+	var lock sync.Mutex
+	var reported []slowOp
+	loader := func(ctx context.Context, trx uuid.UUID) ([]byte, error) {
+		time.Sleep(20 * time.Millisecond)
+
+		return []byte("loaded"), nil
+	}
+	store := NewBytesTRXStore(time.Minute, WithLoader(loader), WithSlowOpThreshold(10*time.Millisecond, func(op string, trx uuid.UUID, took time.Duration) {
+		lock.Lock()
+		reported = append(reported, slowOp{op, trx, took})
+		lock.Unlock()
+	}))
+	defer store.Close()
+
+	trx := uuid.New()
+	if _, err := store.Load(context.Background(), trx); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	store.Store(uuid.New(), []byte("fast"))
+	store.Check(trx)
+
+	lock.Lock()
+	defer lock.Unlock()
+	if len(reported) != 1 {
+		t.Fatalf("got %d slow operations, want only the load: %v", len(reported), reported)
+	}
+	if got := reported[0]; got.op != "load" || got.trx != trx || got.took < 20*time.Millisecond {
+		t.Fatalf("got %+v, want a load of %s taking at least 20ms", got, trx)
+	}
+}
+
+func TestSlowOpThresholdUnset(t *testing.T) {
+	called := false
+	store := NewBytesTRXStore(time.Minute, WithSlowOpThreshold(0, func(string, uuid.UUID, time.Duration) { called = true }))
+	defer store.Close()
+
+	if store.opts.onSlowOp != nil {
+		t.Fatalf("operations timed without a threshold")
+	}
+	store.Store(uuid.New(), []byte("value"))
+	if called {
+		t.Fatalf("callback called without a threshold")
+	}
+}
@@ -0,0 +1,190 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"slices"
+	"time"
+)
+
+type SnapshotEntry struct {
+	Value []byte
+	TTL   time.Duration
+}
+
+// Snapshot returns a copy of all live entries.
+func (p *BytesTrxStore) Snapshot() map[uuid.UUID][]byte {
+	entries := p.SnapshotWithTTL()
+	res := make(map[uuid.UUID][]byte, len(entries))
+	for trx, entry := range entries {
+		res[trx] = entry.Value
+	}
+
+	return res
+}
+
+// SnapshotWithTTL returns a copy of all live entries together with the
+// time each of them has left. All shards are locked at once, so the result
+// reflects a single point in time.
+func (p *BytesTrxStore) SnapshotWithTTL() map[uuid.UUID]SnapshotEntry {
+	p.rlockAll()
+	defer p.runlockAll()
+
+	now := p.opts.clock.Now()
+	res := map[uuid.UUID]SnapshotEntry{}
+	for _, s := range p.shards {
+		for trx := range s.cache {
+			value, exists := p.get(s, trx, now)
+			if !exists {
+				continue
+			}
+
+			res[trx] = SnapshotEntry{
+				Value: value,
+				TTL:   s.cacheMeta[trx].deadline.Sub(now),
+			}
+		}
+	}
+
+	return res
+}
+
+// SortedEntry is an entry of SortedSnapshot.
+type SortedEntry struct {
+	Trx uuid.UUID
+	SnapshotEntry
+}
+
+// SortedSnapshot is like SnapshotWithTTL, but returns the entries ordered
+// by key, so dumps of the same contents are identical, for golden files
+// and for diffing two snapshots.
+func (p *BytesTrxStore) SortedSnapshot() []SortedEntry {
+	entries := p.SnapshotWithTTL()
+	res := make([]SortedEntry, 0, len(entries))
+	for trx, entry := range entries {
+		res = append(res, SortedEntry{Trx: trx, SnapshotEntry: entry})
+	}
+	slices.SortFunc(res, func(a, b SortedEntry) int {
+		return bytes.Compare(a.Trx[:], b.Trx[:])
+	})
+
+	return res
+}
+
+// Range calls fn for each live entry with its value and deadline, stopping
+// early if fn returns false. It works on a copy taken at a single point in
+// time, like SnapshotWithTTL, so fn may use the store freely but does not
+// see changes made after Range started.
+func (p *BytesTrxStore) Range(fn func(trx uuid.UUID, value []byte, expiresAt time.Time) bool) {
+	type rangeEntry struct {
+		trx       uuid.UUID
+		value     []byte
+		expiresAt time.Time
+	}
+
+	p.rlockAll()
+	now := p.opts.clock.Now()
+	var entries []rangeEntry
+	for _, s := range p.shards {
+		for trx := range s.cache {
+			value, exists := p.get(s, trx, now)
+			if !exists {
+				continue
+			}
+
+			entries = append(entries, rangeEntry{
+				trx:       trx,
+				value:     value,
+				expiresAt: s.cacheMeta[trx].deadline,
+			})
+		}
+	}
+	p.runlockAll()
+
+	for _, entry := range entries {
+		if !fn(entry.trx, entry.value, entry.expiresAt) {
+			return
+		}
+	}
+}
+
+// Drain hands every live entry with its remaining TTL to sink, for example
+// to move them to another instance during a rolling deploy before Close.
+// It works on a snapshot like SnapshotWithTTL, leaves the store as is, and
+// stops at the first sink error or once ctx is done.
+func (p *BytesTrxStore) Drain(ctx context.Context, sink func(trx uuid.UUID, value []byte, remainingTTL time.Duration) error) error {
+	for trx, entry := range p.SnapshotWithTTL() {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := sink(trx, entry.Value, entry.TTL); err != nil {
+			return fmt.Errorf("failed to drain %s: %w", trx, err)
+		}
+	}
+
+	return nil
+}
+
+// Restore loads entries exported by Snapshot, giving each of them the full
+// store-wide TTL. Use RestoreWithTTL to keep the original expiry timing.
+func (p *BytesTrxStore) Restore(entries map[uuid.UUID][]byte) int {
+	res := make(map[uuid.UUID]SnapshotEntry, len(entries))
+	for trx, value := range entries {
+		res[trx] = SnapshotEntry{Value: value, TTL: p.entryTTL(trx)}
+	}
+
+	return p.restore(res)
+}
+
+// RestoreWithTTL loads entries exported by SnapshotWithTTL, so that each of
+// them expires when it would have in the original store. Keys that are
+// already live are kept as is, since the running store holds the more
+// recent result, and entries without TTL left are skipped. It returns the
+// number of restored entries.
+func (p *BytesTrxStore) RestoreWithTTL(entries map[uuid.UUID]SnapshotEntry) int {
+	live := make(map[uuid.UUID]SnapshotEntry, len(entries))
+	for trx, entry := range entries {
+		if entry.TTL > 0 {
+			live[trx] = entry
+		}
+	}
+
+	return p.restore(live)
+}
+
+func (p *BytesTrxStore) restore(entries map[uuid.UUID]SnapshotEntry) int {
+	var restored int
+	var evicted []expiredEntry
+	for trx, entry := range entries {
+		value, ok := p.limitLogged(trx, entry.Value)
+		if !ok {
+			continue
+		}
+		s := p.shard(trx)
+		s.cacheLock.Lock()
+		now := p.opts.clock.Now()
+		if _, exists := s.lookup(trx, now); !exists {
+			evicted = append(evicted, s.store(trx, p.encode(value), now, now, entry.TTL)...)
+			restored++
+		}
+		s.cacheLock.Unlock()
+	}
+
+	p.notify(evicted)
+
+	return restored
+}
+
+func (p *BytesTrxStore) rlockAll() {
+	for _, s := range p.shards {
+		s.cacheLock.RLock()
+	}
+}
+
+func (p *BytesTrxStore) runlockAll() {
+	for _, s := range p.shards {
+		s.cacheLock.RUnlock()
+	}
+}
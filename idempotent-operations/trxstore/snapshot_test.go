@@ -0,0 +1,174 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	live, expired := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.StoreWithTTL(expired, []byte("expired"), time.Second)
+	store.Store(live, []byte("live"))
+	clock.Advance(10 * time.Second)
+
+	snapshot := store.SnapshotWithTTL()
+	if len(snapshot) != 1 {
+		t.Fatalf("expected 1 live entry, got %d", len(snapshot))
+	}
+	entry := snapshot[live]
+	if !bytes.Equal(entry.Value, []byte("live")) || entry.TTL != 50*time.Second {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+
+	values := store.Snapshot()
+	values[live][0] = 'x'
+	if !bytes.Equal(store.Check(live), []byte("live")) {
+		t.Fatalf("snapshot shares memory with store")
+	}
+}
+
+func TestRestoreRoundTrip(t *testing.T) {
+	first, second, existing := uuid.New(), uuid.New(), uuid.New()
+	clock := manualclock.New()
+	source := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer source.Close()
+
+	source.Store(first, []byte("first"))
+	source.StoreWithTTL(second, []byte("second"), time.Hour)
+	source.Store(existing, []byte("old"))
+	clock.Advance(30 * time.Second)
+
+	target := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer target.Close()
+	target.Store(existing, []byte("new"))
+
+	if restored := target.RestoreWithTTL(source.SnapshotWithTTL()); restored != 2 {
+		t.Fatalf("expected 2 restored entries, got %d", restored)
+	}
+	if !bytes.Equal(target.Check(existing), []byte("new")) {
+		t.Fatalf("restore overwrote live trx")
+	}
+	if ttl, _ := target.TTLRemaining(first); ttl != 30*time.Second {
+		t.Fatalf("restored TTL not preserved: %s", ttl)
+	}
+
+	clock.Advance(31 * time.Second)
+	if target.Check(first) != nil {
+		t.Fatalf("restored trx outlived original deadline")
+	}
+	if !bytes.Equal(target.Check(second), []byte("second")) {
+		t.Fatalf("restored trx with long TTL missing")
+	}
+}
+
+func TestSortedSnapshot(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		store.StoreWithTTL(uuid.New(), []byte{byte(i)}, time.Duration(i+1)*time.Second)
+	}
+
+	first := store.SortedSnapshot()
+	if len(first) != 50 {
+		t.Fatalf("got %d entries, want 50", len(first))
+	}
+	for i := 1; i < len(first); i++ {
+		if bytes.Compare(first[i-1].Trx[:], first[i].Trx[:]) >= 0 {
+			t.Fatalf("entries %d and %d out of key order", i-1, i)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if again := store.SortedSnapshot(); !reflect.DeepEqual(again, first) {
+			t.Fatalf("snapshot %d differs from the first", i)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.StoreWithTTL(uuid.New(), []byte("expired"), time.Second)
+	for i := 0; i < 10; i++ {
+		store.Store(uuid.New(), []byte("live"))
+	}
+	start := clock.Now()
+	clock.Advance(10 * time.Second)
+
+	var seen int
+	store.Range(func(trx uuid.UUID, value []byte, expiresAt time.Time) bool {
+		if !bytes.Equal(value, []byte("live")) || !expiresAt.Equal(start.Add(time.Minute)) {
+			t.Fatalf("unexpected entry %q expiring at %v", value, expiresAt)
+		}
+		store.Delete(trx)
+		seen++
+		return true
+	})
+	if seen != 10 || store.Len() != 1 {
+		t.Fatalf("expected 10 visited and 1 left, got %d and %d", seen, store.Len())
+	}
+
+	store.Store(uuid.New(), nil)
+	store.Store(uuid.New(), nil)
+	seen = 0
+	store.Range(func(uuid.UUID, []byte, time.Time) bool {
+		seen++
+		return false
+	})
+	if seen != 1 {
+		t.Fatalf("range did not stop early, visited %d", seen)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+	target := NewBytesTRXStore(time.Minute)
+	defer target.Close()
+
+	for i := 0; i < 10; i++ {
+		store.Store(uuid.New(), []byte("test"))
+	}
+	clock.Advance(20 * time.Second)
+
+	err := store.Drain(context.Background(), func(trx uuid.UUID, value []byte, remainingTTL time.Duration) error {
+		if remainingTTL != 40*time.Second {
+			t.Fatalf("unexpected remaining ttl %v", remainingTTL)
+		}
+		target.StoreWithTTL(trx, value, remainingTTL)
+		return nil
+	})
+	if err != nil || target.Len() != 10 {
+		t.Fatalf("drained %d entries, %v", target.Len(), err)
+	}
+
+	expectedErr := errors.New("sink failed")
+	var calls int
+	err = store.Drain(context.Background(), func(uuid.UUID, []byte, time.Duration) error {
+		calls++
+		return expectedErr
+	})
+	if !errors.Is(err, expectedErr) || calls != 1 {
+		t.Fatalf("expected first sink error after 1 call, got %v after %d", err, calls)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := store.Drain(ctx, func(uuid.UUID, []byte, time.Duration) error { return nil }); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context error, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package sqlstore
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Dialect holds the SQL differences between the supported databases.
+type Dialect struct {
+	name        string
+	placeholder func(n int) string
+	valueType   string
+	timeType    string
+	timeValue   func(t time.Time) any
+	parseTime   func(v any) (time.Time, error)
+}
+
+// sqliteTime is fixed width, so that SQLite, which keeps timestamps as
+// text, compares them in time order.
+const sqliteTime = "2006-01-02 15:04:05.000000000"
+
+var (
+	// Postgres uses $n placeholders and native UUID, BYTEA and TIMESTAMPTZ
+	// columns.
+	Postgres = Dialect{
+		name:        "postgres",
+		placeholder: func(n int) string { return fmt.Sprintf("$%d", n) },
+		valueType:   "BYTEA",
+		timeType:    "TIMESTAMPTZ",
+		timeValue:   func(t time.Time) any { return t.UTC() },
+		parseTime:   parseNativeTime,
+	}
+	// SQLite uses ? placeholders and keeps expiry times as UTC text.
+	SQLite = Dialect{
+		name:        "sqlite",
+		placeholder: func(int) string { return "?" },
+		valueType:   "BLOB",
+		timeType:    "TIMESTAMP",
+		timeValue:   func(t time.Time) any { return t.UTC().Format(sqliteTime) },
+		parseTime:   parseSQLiteTime,
+	}
+)
+
+func (d Dialect) String() string {
+	return d.name
+}
+
+func parseNativeTime(v any) (time.Time, error) {
+	res, ok := v.(time.Time)
+	if !ok {
+		return time.Time{}, fmt.Errorf("unexpected time value %T", v)
+	}
+
+	return res, nil
+}
+
+// parseSQLiteTime accepts the text written by timeValue as well as the
+// time.Time drivers may already have parsed it into.
+func parseSQLiteTime(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		return time.ParseInLocation(sqliteTime, v, time.UTC)
+	case []byte:
+		return time.ParseInLocation(sqliteTime, string(v), time.UTC)
+	}
+
+	return parseNativeTime(v)
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+type queries struct {
+	create        string
+	lookup        string
+	ttl           string
+	store         string
+	delete        string
+	deleteExpired string
+}
+
+func newQueries(d Dialect, opts options) queries {
+	table, key, value, expiresAt := quote(opts.table), quote(opts.keyColumn), quote(opts.valueColumn), quote(opts.expiresAtColumn)
+	live := fmt.Sprintf("(%s IS NULL OR %s > %s)", expiresAt, expiresAt, d.placeholder(2))
+
+	return queries{
+		create: fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s UUID PRIMARY KEY, %s %s NOT NULL, %s %s)",
+			table, key, value, d.valueType, expiresAt, d.timeType),
+		lookup: fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s",
+			value, table, key, d.placeholder(1), live),
+		ttl: fmt.Sprintf("SELECT %s FROM %s WHERE %s = %s AND %s",
+			expiresAt, table, key, d.placeholder(1), live),
+		store: fmt.Sprintf("INSERT INTO %s (%s, %s, %s) VALUES (%s, %s, %s) ON CONFLICT (%s) DO UPDATE SET %s = excluded.%s, %s = excluded.%s",
+			table, key, value, expiresAt, d.placeholder(1), d.placeholder(2), d.placeholder(3), key, value, value, expiresAt, expiresAt),
+		delete: fmt.Sprintf("DELETE FROM %s WHERE %s = %s",
+			table, key, d.placeholder(1)),
+		deleteExpired: fmt.Sprintf("DELETE FROM %s WHERE %s IS NOT NULL AND %s <= %s",
+			table, expiresAt, expiresAt, d.placeholder(1)),
+	}
+}
@@ -0,0 +1,42 @@
+package sqlstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQueries(t *testing.T) {
+	queries := newQueries(Postgres, newOptions([]Option{WithTable("idem"), WithColumns("id", "body", "until")}))
+
+	expected := `SELECT "body" FROM "idem" WHERE "id" = $1 AND ("until" IS NULL OR "until" > $2)`
+	if queries.lookup != expected {
+		t.Fatalf("unexpected lookup query %s", queries.lookup)
+	}
+	expected = `INSERT INTO "idem" ("id", "body", "until") VALUES ($1, $2, $3) ON CONFLICT ("id") DO UPDATE SET "body" = excluded."body", "until" = excluded."until"`
+	if queries.store != expected {
+		t.Fatalf("unexpected store query %s", queries.store)
+	}
+
+	queries = newQueries(SQLite, newOptions(nil))
+	expected = `DELETE FROM "trx_store" WHERE "expires_at" IS NOT NULL AND "expires_at" <= ?`
+	if queries.deleteExpired != expected {
+		t.Fatalf("unexpected cleanup query %s", queries.deleteExpired)
+	}
+}
+
+func TestSQLiteTimeOrder(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	earlier := SQLite.timeValue(base).(string)
+	later := SQLite.timeValue(base.Add(time.Millisecond)).(string)
+	local := SQLite.timeValue(base.Add(time.Second).In(time.FixedZone("x", 3600))).(string)
+
+	if earlier >= later || later >= local {
+		t.Fatalf("sqlite times out of order: %s, %s, %s", earlier, later, local)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if quoted := quote(`odd"name`); quoted != `"odd""name"` {
+		t.Fatalf("unexpected quoting %s", quoted)
+	}
+}
@@ -0,0 +1,70 @@
+package sqlstore
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"time"
+)
+
+type Option func(*options)
+
+type options struct {
+	table           string
+	keyColumn       string
+	valueColumn     string
+	expiresAtColumn string
+	cleanupInterval time.Duration
+	clock           trxstore.Clock
+}
+
+func newOptions(opts []Option) options {
+	res := options{
+		table:           "trx_store",
+		keyColumn:       "key",
+		valueColumn:     "value",
+		expiresAtColumn: "expires_at",
+		cleanupInterval: time.Minute,
+		clock:           trxstore.SystemClock(),
+	}
+	for _, opt := range opts {
+		opt(&res)
+	}
+
+	return res
+}
+
+// WithTable sets the table name, trx_store by default.
+func WithTable(name string) Option {
+	return func(o *options) {
+		o.table = name
+	}
+}
+
+// WithColumns sets the column names, key, value and expires_at by default.
+func WithColumns(key, value, expiresAt string) Option {
+	return func(o *options) {
+		o.keyColumn = key
+		o.valueColumn = value
+		o.expiresAtColumn = expiresAt
+	}
+}
+
+// WithCleanupInterval sets how often expired rows are deleted, every
+// minute by default. Reads never return expired rows, so the interval only
+// bounds how long they keep using space. Zero disables the cleanup, for
+// example when DeleteExpired is run by a scheduled job instead.
+func WithCleanupInterval(interval time.Duration) Option {
+	return func(o *options) {
+		o.cleanupInterval = interval
+	}
+}
+
+// WithClock replaces the wall clock used to compute and check expiry times
+// and to schedule the cleanup, mostly so tests can move time by hand. A nil
+// clock is ignored.
+func WithClock(clock trxstore.Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Package sqlstore keeps idempotency results in a SQL database through
+// database/sql, for services that have a database but no Redis.
+package sqlstore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"math"
+	"time"
+)
+
+var (
+	_ trxstore.TrxStore    = (*SQLTrxStore)(nil)
+	_ trxstore.TrxStoreE   = (*SQLTrxStore)(nil)
+	_ trxstore.TTLReporter = (*SQLTrxStore)(nil)
+)
+
+// SQLTrxStore keeps results in one table with a key, value and expires_at
+// column, a NULL expires_at meaning the row never expires. Expiry times are
+// computed by the store, so the clocks of the database and the services
+// need not agree. The TrxStore methods treat database failures as misses;
+// use the E variants to handle them.
+type SQLTrxStore struct {
+	db      *sql.DB
+	dialect Dialect
+	ttl     time.Duration
+	queries queries
+	clock   trxstore.Clock
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewSQLTRXStore creates a store over an existing table, see CreateTable,
+// and starts deleting expired rows in the background. The db is owned by
+// the caller and not closed by Close.
+func NewSQLTRXStore(db *sql.DB, dialect Dialect, ttl time.Duration, opts ...Option) *SQLTrxStore {
+	options := newOptions(opts)
+	ctx, cancel := context.WithCancel(context.Background())
+	res := &SQLTrxStore{
+		db:      db,
+		dialect: dialect,
+		ttl:     ttl,
+		queries: newQueries(dialect, options),
+		clock:   options.clock,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	if options.cleanupInterval > 0 {
+		go res.cleanup(ctx, options.cleanupInterval)
+	} else {
+		close(res.done)
+	}
+
+	return res
+}
+
+// CreateTable creates the table if it does not exist yet.
+func (p *SQLTrxStore) CreateTable(ctx context.Context) error {
+	if _, err := p.db.ExecContext(ctx, p.queries.create); err != nil {
+		return fmt.Errorf("create table: %w", err)
+	}
+
+	return nil
+}
+
+func (p *SQLTrxStore) Check(trx uuid.UUID) []byte {
+	res, _ := p.Lookup(trx)
+
+	return res
+}
+
+func (p *SQLTrxStore) Lookup(trx uuid.UUID) ([]byte, bool) {
+	res, exists, err := p.LookupE(context.Background(), trx)
+	if err != nil {
+		return nil, false
+	}
+
+	return res, exists
+}
+
+func (p *SQLTrxStore) Store(trx uuid.UUID, result []byte) {
+	_ = p.StoreE(context.Background(), trx, result)
+}
+
+func (p *SQLTrxStore) StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration) {
+	_ = p.StoreWithTTLE(context.Background(), trx, result, ttl)
+}
+
+func (p *SQLTrxStore) Delete(trx uuid.UUID) bool {
+	res, _ := p.DeleteE(context.Background(), trx)
+
+	return res
+}
+
+// TTLRemaining returns how long trx stays live, or the maximum duration
+// for rows that never expire. Database failures are reported as missing
+// rows.
+func (p *SQLTrxStore) TTLRemaining(trx uuid.UUID) (time.Duration, bool) {
+	res, exists, err := p.TTLRemainingE(context.Background(), trx)
+	if err != nil {
+		return 0, false
+	}
+
+	return res, exists
+}
+
+// Close stops the background cleanup, cancelling a running delete, and
+// waits for it to exit. It is safe to call more than once.
+func (p *SQLTrxStore) Close() {
+	p.cancel()
+	<-p.done
+}
+
+func (p *SQLTrxStore) LookupE(ctx context.Context, trx uuid.UUID) ([]byte, bool, error) {
+	var res []byte
+	err := p.db.QueryRowContext(ctx, p.queries.lookup, trx.String(), p.dialect.timeValue(p.clock.Now())).Scan(&res)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("select trx %s: %w", trx, err)
+	}
+
+	return res, true, nil
+}
+
+// TTLRemainingE is TTLRemaining with the database failures reported.
+func (p *SQLTrxStore) TTLRemainingE(ctx context.Context, trx uuid.UUID) (time.Duration, bool, error) {
+	var expiresAt any
+	now := p.clock.Now()
+	err := p.db.QueryRowContext(ctx, p.queries.ttl, trx.String(), p.dialect.timeValue(now)).Scan(&expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("select ttl of trx %s: %w", trx, err)
+	}
+	if expiresAt == nil {
+		return math.MaxInt64, true, nil
+	}
+	res, err := p.dialect.parseTime(expiresAt)
+	if err != nil {
+		return 0, false, fmt.Errorf("select ttl of trx %s: %w", trx, err)
+	}
+
+	return res.Sub(now), true, nil
+}
+
+func (p *SQLTrxStore) StoreE(ctx context.Context, trx uuid.UUID, result []byte) error {
+	return p.StoreWithTTLE(ctx, trx, result, p.ttl)
+}
+
+// StoreWithTTLE upserts result with its own ttl. A zero ttl stores result
+// without expiry.
+func (p *SQLTrxStore) StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error {
+	if ttl < 0 {
+		return fmt.Errorf("upsert trx %s: %w", trx, trxstore.ErrInvalidTTL)
+	}
+	var expiresAt any
+	if ttl > 0 {
+		expiresAt = p.dialect.timeValue(p.clock.Now().Add(ttl))
+	}
+	if result == nil {
+		result = []byte{}
+	}
+
+	if _, err := p.db.ExecContext(ctx, p.queries.store, trx.String(), result, expiresAt); err != nil {
+		return fmt.Errorf("upsert trx %s: %w", trx, err)
+	}
+
+	return nil
+}
+
+func (p *SQLTrxStore) DeleteE(ctx context.Context, trx uuid.UUID) (bool, error) {
+	res, err := p.db.ExecContext(ctx, p.queries.delete, trx.String())
+	if err != nil {
+		return false, fmt.Errorf("delete trx %s: %w", trx, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("delete trx %s: %w", trx, err)
+	}
+
+	return deleted > 0, nil
+}
+
+// DeleteExpired deletes the expired rows and returns how many there were.
+// The background cleanup calls it periodically.
+func (p *SQLTrxStore) DeleteExpired(ctx context.Context) (int64, error) {
+	res, err := p.db.ExecContext(ctx, p.queries.deleteExpired, p.dialect.timeValue(p.clock.Now()))
+	if err != nil {
+		return 0, fmt.Errorf("delete expired: %w", err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("delete expired: %w", err)
+	}
+
+	return deleted, nil
+}
+
+func (p *SQLTrxStore) cleanup(ctx context.Context, interval time.Duration) {
+	defer close(p.done)
+
+	ticker := p.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C():
+			// failures are retried on the next tick
+			_, _ = p.DeleteExpired(ctx)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
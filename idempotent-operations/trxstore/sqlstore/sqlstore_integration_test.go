@@ -0,0 +1,39 @@
+//go:build integration
+
+package sqlstore
+
+import (
+	"database/sql"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"os"
+	"testing"
+)
+
+// openPostgres opens the database at TRXSTORE_POSTGRES_DSN and skips the
+// test when it is not set.
+func openPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TRXSTORE_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TRXSTORE_POSTGRES_DSN not set")
+	}
+	res, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("open postgres: %v", err)
+	}
+	t.Cleanup(func() { _ = res.Close() })
+
+	return res
+}
+
+func TestPostgresTrxStore(t *testing.T) {
+	testSQLTrxStore(t, openPostgres(t), Postgres)
+}
+
+func TestPostgresTrxStoreConformance(t *testing.T) {
+	testConformance(t, openPostgres(t), Postgres)
+}
+
+func TestPostgresTrxStoreTTLRemaining(t *testing.T) {
+	testTTLRemaining(t, openPostgres(t), Postgres)
+}
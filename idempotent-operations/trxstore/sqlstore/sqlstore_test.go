@@ -0,0 +1,141 @@
+package sqlstore
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/trxstoretest"
+	"math"
+	_ "modernc.org/sqlite"
+	"testing"
+	"time"
+)
+
+// The tests run against an in-memory SQLite database, and against
+// Postgres in the integration build, see sqlstore_integration_test.go.
+
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	res, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	// every connection to :memory: is a separate database
+	res.SetMaxOpenConns(1)
+	t.Cleanup(func() { _ = res.Close() })
+
+	return res
+}
+
+func newTestStore(t *testing.T, db *sql.DB, dialect Dialect, ttl time.Duration) (*SQLTrxStore, *trxstoretest.ManualClock) {
+	t.Helper()
+	table := "trx_" + uuid.NewString()[:8]
+	now := trxstoretest.NewManualClock()
+	store := NewSQLTRXStore(db, dialect, ttl, WithTable(table), WithCleanupInterval(0), WithClock(now))
+	if err := store.CreateTable(context.Background()); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	t.Cleanup(func() { _, _ = db.Exec(`DROP TABLE ` + quote(table)) })
+
+	return store, now
+}
+
+func TestSQLTrxStore(t *testing.T) {
+	testSQLTrxStore(t, openSQLite(t), SQLite)
+}
+
+func TestSQLTrxStoreConformance(t *testing.T) {
+	testConformance(t, openSQLite(t), SQLite)
+}
+
+func TestSQLTrxStoreTTLRemaining(t *testing.T) {
+	testTTLRemaining(t, openSQLite(t), SQLite)
+}
+
+func TestTieredOverSQL(t *testing.T) {
+	short, long := uuid.New(), uuid.New()
+	remote, now := newTestStore(t, openSQLite(t), SQLite, time.Hour)
+	defer remote.Close()
+	local := trxstore.NewBytesTRXStore(time.Hour, trxstore.WithClock(trxstoretest.NewManualClock()))
+	defer local.Close()
+	store := trxstore.NewTieredTRXStore(local, remote)
+
+	remote.StoreWithTTL(short, []byte("short"), time.Minute)
+	remote.StoreWithTTL(long, []byte("long"), 2*time.Hour)
+	now.Advance(30 * time.Second)
+	if !bytes.Equal(store.Check(short), []byte("short")) || !bytes.Equal(store.Check(long), []byte("long")) {
+		t.Fatalf("remote hits not returned")
+	}
+	if ttl, _ := local.TTLRemaining(short); ttl != 30*time.Second {
+		t.Fatalf("local copy outlives the remote entry: %v left", ttl)
+	}
+	if ttl, _ := local.TTLRemaining(long); ttl != time.Hour {
+		t.Fatalf("local copy not capped at the local TTL: %v left", ttl)
+	}
+}
+
+func testSQLTrxStore(t *testing.T, db *sql.DB, dialect Dialect) {
+	trx, empty := uuid.New(), uuid.New()
+	store, now := newTestStore(t, db, dialect, time.Minute)
+	defer store.Close()
+
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("new trx already exists")
+	}
+	store.Store(trx, []byte("first"))
+	store.Store(trx, []byte("second"))
+	store.Store(empty, nil)
+	if !bytes.Equal(store.Check(trx), []byte("second")) {
+		t.Fatalf("upsert not applied")
+	}
+	if res, exists := store.Lookup(empty); !exists || len(res) != 0 {
+		t.Fatalf("empty result not stored")
+	}
+
+	now.Advance(2 * time.Minute)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("trx not expired")
+	}
+	if deleted, err := store.DeleteExpired(context.Background()); err != nil || deleted != 2 {
+		t.Fatalf("expected 2 expired rows deleted, got %d, %v", deleted, err)
+	}
+
+	store.Store(trx, []byte("again"))
+	if !store.Delete(trx) || store.Delete(trx) {
+		t.Fatalf("delete not reported correctly")
+	}
+}
+
+func testConformance(t *testing.T, db *sql.DB, dialect Dialect) {
+	trxstoretest.RunTrxStoreConformance(t, func(t *testing.T, ttl time.Duration) (trxstore.TrxStore, func(time.Duration)) {
+		store, now := newTestStore(t, db, dialect, ttl)
+
+		return store, now.Advance
+	})
+}
+
+func testTTLRemaining(t *testing.T, db *sql.DB, dialect Dialect) {
+	trx, forever := uuid.New(), uuid.New()
+	store, now := newTestStore(t, db, dialect, time.Minute)
+	defer store.Close()
+
+	if _, exists := store.TTLRemaining(trx); exists {
+		t.Fatalf("missing trx has TTL")
+	}
+	store.Store(trx, nil)
+	store.StoreWithTTL(forever, nil, 0)
+	now.Advance(20 * time.Second)
+	if ttl, exists := store.TTLRemaining(trx); !exists || ttl != 40*time.Second {
+		t.Fatalf("unexpected TTL %v, %v", ttl, exists)
+	}
+	if ttl, _ := store.TTLRemaining(forever); ttl != math.MaxInt64 {
+		t.Fatalf("row without expiry has TTL %v", ttl)
+	}
+
+	now.Advance(time.Minute)
+	if _, exists := store.TTLRemaining(trx); exists {
+		t.Fatalf("expired trx has TTL")
+	}
+}
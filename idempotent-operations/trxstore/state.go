@@ -0,0 +1,65 @@
+package trxstore
+
+import "github.com/google/uuid"
+
+// EntryState is the state of a key reported by State.
+type EntryState int
+
+const (
+	// StateAbsent means trx was never stored, or was deleted or removed
+	// by the cleanup.
+	StateAbsent EntryState = iota
+	// StateInFlight means a Do, DoCtx or Load for trx is running, or a
+	// Begin for it is not completed yet.
+	StateInFlight
+	// StateCompleted means trx holds a live value.
+	StateCompleted
+	// StateExpired means trx is past its TTL but not yet removed by the
+	// cleanup.
+	StateExpired
+	// StateTombstone means trx is live but its value was dropped, see
+	// WithTombstones.
+	StateTombstone
+)
+
+func (s EntryState) String() string {
+	switch s {
+	case StateAbsent:
+		return "absent"
+	case StateInFlight:
+		return "in_flight"
+	case StateCompleted:
+		return "completed"
+	case StateExpired:
+		return "expired"
+	case StateTombstone:
+		return "tombstone"
+	default:
+		return "unknown"
+	}
+}
+
+// State reports the state of trx without waiting for an operation in
+// flight. Like Peek it is not a read: it does not restart a sliding TTL,
+// count as use for eviction or show in Stats. An expired trx being
+// produced again is reported in flight.
+func (p *BytesTrxStore) State(trx uuid.UUID) EntryState {
+	s := p.shard(trx)
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
+
+	now := p.opts.clock.Now()
+	_, held := s.cache[trx]
+	switch {
+	case held && !s.expired(trx, now) && s.tombstoned(trx, now):
+		return StateTombstone
+	case held && !s.expired(trx, now):
+		return StateCompleted
+	case s.inFlight[trx] != nil:
+		return StateInFlight
+	case held:
+		return StateExpired
+	default:
+		return StateAbsent
+	}
+}
@@ -0,0 +1,58 @@
+package trxstore
+
+import (
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestState(t *testing.T) {
+	trx, failed := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	if state := store.State(trx); state != StateAbsent {
+		t.Fatalf("got %v before the first store", state)
+	}
+
+	running, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = store.Do(trx, func() ([]byte, error) {
+			close(running)
+			<-release
+
+			return []byte("result"), nil
+		})
+	}()
+	<-running
+	if state := store.State(trx); state != StateInFlight {
+		t.Fatalf("got %v while fn runs", state)
+	}
+	close(release)
+	<-done
+	if state := store.State(trx); state != StateCompleted {
+		t.Fatalf("got %v after fn returned", state)
+	}
+
+	clock.Advance(time.Minute + time.Second)
+	if state := store.State(trx); state != StateExpired {
+		t.Fatalf("got %v past the ttl", state)
+	}
+	store.ForceCleanup()
+	if state := store.State(trx); state != StateAbsent {
+		t.Fatalf("got %v after the cleanup", state)
+	}
+
+	_, _ = store.Do(failed, func() ([]byte, error) { return nil, errors.New("failed") })
+	if state := store.State(failed); state != StateAbsent {
+		t.Fatalf("got %v after fn failed", state)
+	}
+	if StateInFlight.String() != "in_flight" {
+		t.Fatalf("unexpected name %q", StateInFlight)
+	}
+}
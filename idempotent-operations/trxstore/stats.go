@@ -0,0 +1,179 @@
+package trxstore
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds the store counters and the WithName name. The sweep fields
+// describe the expiry sweeps run by the cleanup or ForceCleanup: Swept
+// counts the entries they removed, and a MaxSweepDuration close to the
+// cleanup interval means the sweeps fall behind. Coalesced counts Do,
+// DoCtx and Await callers that waited for another caller's operation on
+// the same trx and got its result, and WaitsCancelled those whose context
+// ended the wait. HitRatio is Hits over all lookups, zero before the first
+// one, and Entries and Bytes are Len and Bytes at the time of the call.
+// Rejected counts stores that stored nothing, and OverLimit stores kept
+// over WithMaxEntries or WithMaxBytes because only pinned entries were
+// left to evict or the value alone exceeds the byte limit; either growing
+// means the limits are too tight. Stats is a plain value, safe to log or
+// marshal to JSON as is.
+type Stats struct {
+	Name              string
+	Hits              uint64
+	Misses            uint64
+	HitRatio          float64
+	Stores            uint64
+	Expirations       uint64
+	Evictions         uint64
+	ByReason          ReasonCounts
+	Rejected          RejectCounts
+	OverLimit         uint64
+	Entries           int
+	Bytes             int64
+	LastSweep         time.Time
+	LastSweepDuration time.Duration
+	MaxSweepDuration  time.Duration
+	Swept             uint64
+	Coalesced         uint64
+	WaitsCancelled    uint64
+}
+
+// RejectCounts splits rejected stores by the error they failed with:
+// ErrValueTooLarge and ErrInFlight. Stores failing on a done context or an
+// invalid TTL are not counted.
+type RejectCounts struct {
+	ValueTooLarge uint64
+	InFlight      uint64
+}
+
+// ReasonCounts splits Expirations and Evictions by EvictionReason.
+type ReasonCounts struct {
+	Expired  uint64
+	Capacity uint64
+	MaxBytes uint64
+	MaxReads uint64
+	Manual   uint64
+}
+
+type stats struct {
+	hits              atomic.Uint64
+	misses            atomic.Uint64
+	stores            atomic.Uint64
+	expirations       atomic.Uint64
+	evictions         atomic.Uint64
+	lastSweepDuration atomic.Int64
+	maxSweepDuration  atomic.Int64
+	swept             atomic.Uint64
+	coalesced         atomic.Uint64
+	waitsCancelled    atomic.Uint64
+	byReason          [ReasonManual + 1]atomic.Uint64
+	tooLarge          atomic.Uint64
+	inFlight          atomic.Uint64
+}
+
+// Stats returns the counters collected since the store was created or
+// since the last ResetStats.
+func (p *BytesTrxStore) Stats() Stats {
+	hits, misses := p.stats.hits.Load(), p.stats.misses.Load()
+
+	return Stats{
+		Name:        p.opts.name,
+		Hits:        hits,
+		Misses:      misses,
+		HitRatio:    hitRatio(hits, misses),
+		Stores:      p.stats.stores.Load(),
+		Expirations: p.stats.expirations.Load(),
+		Evictions:   p.stats.evictions.Load(),
+		ByReason: ReasonCounts{
+			Expired:  p.stats.byReason[ReasonExpired].Load(),
+			Capacity: p.stats.byReason[ReasonCapacity].Load(),
+			MaxBytes: p.stats.byReason[ReasonMaxBytes].Load(),
+			MaxReads: p.stats.byReason[ReasonMaxReads].Load(),
+			Manual:   p.stats.byReason[ReasonManual].Load(),
+		},
+		Rejected: RejectCounts{
+			ValueTooLarge: p.stats.tooLarge.Load(),
+			InFlight:      p.stats.inFlight.Load(),
+		},
+		OverLimit:         p.overLimit(),
+		Entries:           p.Len(),
+		Bytes:             p.Bytes(),
+		LastSweep:         p.LastSweep(),
+		LastSweepDuration: time.Duration(p.stats.lastSweepDuration.Load()),
+		MaxSweepDuration:  time.Duration(p.stats.maxSweepDuration.Load()),
+		Swept:             p.stats.swept.Load(),
+		Coalesced:         p.stats.coalesced.Load(),
+		WaitsCancelled:    p.stats.waitsCancelled.Load(),
+	}
+}
+
+// ResetStats zeroes the counters, including the sweep, wait and per
+// reason counters. LastSweep, LastSweepDuration, Entries and Bytes are
+// not counters and are kept.
+func (p *BytesTrxStore) ResetStats() {
+	p.stats.hits.Store(0)
+	p.stats.misses.Store(0)
+	p.stats.stores.Store(0)
+	p.stats.expirations.Store(0)
+	p.stats.evictions.Store(0)
+	p.stats.maxSweepDuration.Store(0)
+	p.stats.swept.Store(0)
+	p.stats.coalesced.Store(0)
+	p.stats.waitsCancelled.Store(0)
+	for i := range p.stats.byReason {
+		p.stats.byReason[i].Store(0)
+	}
+	p.stats.tooLarge.Store(0)
+	p.stats.inFlight.Store(0)
+	for _, s := range p.shards {
+		s.overflows.Store(0)
+	}
+}
+
+func hitRatio(hits, misses uint64) float64 {
+	if hits+misses == 0 {
+		return 0
+	}
+
+	return float64(hits) / float64(hits+misses)
+}
+
+func (p *BytesTrxStore) countSweep(duration time.Duration, swept int) {
+	p.stats.lastSweepDuration.Store(int64(duration))
+	p.stats.swept.Add(uint64(swept))
+	for {
+		current := p.stats.maxSweepDuration.Load()
+		if int64(duration) <= current || p.stats.maxSweepDuration.CompareAndSwap(current, int64(duration)) {
+			return
+		}
+	}
+}
+
+// countRejected counts a store that failed with err, if it was rejected.
+func (p *BytesTrxStore) countRejected(err error) {
+	switch {
+	case errors.Is(err, ErrValueTooLarge):
+		p.stats.tooLarge.Add(1)
+	case errors.Is(err, ErrInFlight):
+		p.stats.inFlight.Add(1)
+	}
+}
+
+func (p *BytesTrxStore) overLimit() uint64 {
+	var res uint64
+	for _, s := range p.shards {
+		res += s.overflows.Load()
+	}
+
+	return res
+}
+
+func (p *BytesTrxStore) countLookup(exists bool) {
+	if exists {
+		p.stats.hits.Add(1)
+	} else {
+		p.stats.misses.Add(1)
+	}
+}
@@ -0,0 +1,160 @@
+package trxstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestStats(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithMaxEntries(2))
+	store.Close()
+
+	first, second := uuid.New(), uuid.New()
+	store.Check(first)
+	store.Store(first, nil)
+	store.Check(first)
+	store.Store(second, nil)
+	store.Store(uuid.New(), nil)
+	clock.Advance(2 * time.Minute)
+	store.cleanupExpired()
+
+	expected := Stats{Hits: 1, Misses: 1, HitRatio: 0.5, Stores: 3, Expirations: 2, Evictions: 1,
+		ByReason: ReasonCounts{Expired: 2, Capacity: 1}}
+	stats := store.Stats()
+	if !stats.LastSweep.Equal(clock.Now()) {
+		t.Fatalf("unexpected last sweep %v", stats.LastSweep)
+	}
+	if stats.LastSweepDuration <= 0 || stats.MaxSweepDuration < stats.LastSweepDuration || stats.Swept != 2 {
+		t.Fatalf("unexpected sweep stats %+v", stats)
+	}
+	stats.LastSweep, stats.LastSweepDuration, stats.MaxSweepDuration, stats.Swept = time.Time{}, 0, 0, 0
+	if stats != expected {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	store.ResetStats()
+	if stats := store.Stats(); stats.Hits+stats.Misses+stats.Stores+stats.Expirations+stats.Evictions+stats.Swept != 0 || stats.MaxSweepDuration != 0 || stats.ByReason != (ReasonCounts{}) {
+		t.Fatalf("stats not reset %+v", stats)
+	}
+}
+
+func TestStatsHitRatio(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if stats := store.Stats(); stats.HitRatio != 0 {
+		t.Fatalf("got hit ratio %v without lookups, want 0", stats.HitRatio)
+	}
+
+	trx := uuid.New()
+	store.Store(trx, []byte("value"))
+	for i := 0; i < 3; i++ {
+		store.Check(trx)
+	}
+	store.Check(uuid.New())
+	stats := store.Stats()
+	if stats.HitRatio != 0.75 || stats.Entries != 1 || stats.Bytes != int64(len("value")) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if _, err := json.Marshal(stats); err != nil {
+		t.Fatalf("marshal stats: %v", err)
+	}
+}
+
+func TestApproxMemoryBytes(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if store.ApproxMemoryBytes() != 0 {
+		t.Fatalf("empty store reports %d bytes", store.ApproxMemoryBytes())
+	}
+	store.Store(uuid.New(), make([]byte, 1000))
+	store.Store(uuid.New(), nil)
+	if expected := int64(1000 + 2*approxEntryOverhead); store.ApproxMemoryBytes() != expected {
+		t.Fatalf("expected %d bytes, got %d", expected, store.ApproxMemoryBytes())
+	}
+}
+
+func TestUsageConsistent(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// storing and deleting across shards keeps bytes at 10 per entry
+		for i := 0; i < 2000; i++ {
+			first, second := uuid.New(), uuid.New()
+			store.StoreMany(map[uuid.UUID][]byte{first: make([]byte, 10), second: make([]byte, 10)})
+			store.Delete(first)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if entries, bytes := store.Usage(); entries != 2000 || bytes != 20000 {
+				t.Fatalf("unexpected final usage %d, %d", entries, bytes)
+			}
+			return
+		default:
+		}
+		if entries, bytes := store.Usage(); bytes != int64(entries)*10 {
+			t.Fatalf("inconsistent usage: %d entries with %d bytes", entries, bytes)
+		}
+	}
+}
+
+func TestStatsRejected(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithMaxEntries(1), WithMaxValueSize(4, false),
+		WithInFlightStorePolicy(InFlightStoreReject))
+	defer store.Close()
+
+	large := []byte("too large")
+	if err := store.StoreE(context.Background(), uuid.New(), large); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.Store(uuid.New(), large)
+	store.StoreMany(map[uuid.UUID][]byte{uuid.New(): large, uuid.New(): nil})
+
+	trx := uuid.New()
+	running, release := make(chan struct{}), make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		store.Do(trx, func() ([]byte, error) {
+			close(running)
+			<-release
+
+			return nil, nil
+		})
+	}()
+	<-running
+	if err := store.StoreE(context.Background(), trx, nil); !errors.Is(err, ErrInFlight) {
+		t.Fatalf("expected ErrInFlight, got %v", err)
+	}
+	close(release)
+	<-done
+
+	// with the only entry pinned the next store goes over the limit
+	store.Pin(trx)
+	store.Store(uuid.New(), nil)
+	if store.Len() != 2 {
+		t.Fatalf("expected the store over the limit, got %d entries", store.Len())
+	}
+
+	stats := store.Stats()
+	if stats.Rejected != (RejectCounts{ValueTooLarge: 3, InFlight: 1}) || stats.OverLimit != 1 {
+		t.Fatalf("unexpected rejection stats %+v", stats)
+	}
+	store.ResetStats()
+	if stats := store.Stats(); stats.Rejected != (RejectCounts{}) || stats.OverLimit != 0 {
+		t.Fatalf("rejection stats not reset %+v", stats)
+	}
+}
@@ -0,0 +1,41 @@
+package trxstore
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+)
+
+// StoreFrom is like Store with the value read from r to its end, for large
+// payloads that come as a stream, but returns ErrValueTooLarge instead of
+// logging a rejected value. With WithMaxValueSize at most one byte past
+// the limit is read before the limit applies. If reading fails
+// nothing is stored, so a partial value never replaces a complete one.
+func (p *BytesTrxStore) StoreFrom(trx uuid.UUID, r io.Reader) error {
+	if p.opts.maxValueSize > 0 {
+		r = io.LimitReader(r, int64(p.opts.maxValueSize)+1)
+	}
+	result, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("read trx %s: %w", trx, err)
+	}
+
+	return p.storeWithTTL(context.Background(), trx, result, p.entryTTL(trx), 0)
+}
+
+// CheckTo writes the live value of trx to w and reports whether there was
+// one. The value is written as held, without a copy, which the io.Writer
+// contract allows, and after the shard lock is released, so a slow w
+// blocks no other caller. A write error is returned along with true.
+func (p *BytesTrxStore) CheckTo(trx uuid.UUID, w io.Writer) (bool, error) {
+	res, exists := p.CheckRef(trx)
+	if !exists {
+		return false, nil
+	}
+	if _, err := w.Write(res); err != nil {
+		return true, fmt.Errorf("write trx %s: %w", trx, err)
+	}
+
+	return true, nil
+}
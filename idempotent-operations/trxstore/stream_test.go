@@ -0,0 +1,72 @@
+package trxstore
+
+import (
+	"bytes"
+	"errors"
+	"github.com/google/uuid"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func TestStoreFromCheckTo(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	var out bytes.Buffer
+	if found, err := store.CheckTo(trx, &out); found || err != nil || out.Len() != 0 {
+		t.Fatalf("miss reported as %v, %v with %q written", found, err, out.String())
+	}
+
+	payload := strings.Repeat("payload ", 10000)
+	if err := store.StoreFrom(trx, iotest.OneByteReader(strings.NewReader(payload))); err != nil {
+		t.Fatalf("store: %v", err)
+	}
+	if found, err := store.CheckTo(trx, &out); !found || err != nil || out.String() != payload {
+		t.Fatalf("hit reported as %v, %v with %d of %d bytes written", found, err, out.Len(), len(payload))
+	}
+
+	failing := errors.New("write failed")
+	if found, err := store.CheckTo(trx, failingWriter{failing}); !found || !errors.Is(err, failing) {
+		t.Fatalf("got %v, %v, want the write error", found, err)
+	}
+}
+
+func TestStoreFromReadError(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	store.Store(trx, []byte("complete"))
+	broken := io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if err := store.StoreFrom(trx, broken); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("got %v, want the read error", err)
+	}
+	if !bytes.Equal(store.Check(trx), []byte("complete")) {
+		t.Fatalf("partial value stored")
+	}
+}
+
+func TestStoreFromMaxValueSize(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, false))
+	defer store.Close()
+
+	if err := store.StoreFrom(trx, strings.NewReader("too large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("got %v, want ErrValueTooLarge", err)
+	}
+	if err := store.StoreFrom(trx, strings.NewReader("fits")); err != nil || !bytes.Equal(store.Check(trx), []byte("fits")) {
+		t.Fatalf("value within the limit not stored: %v", err)
+	}
+}
+
+type failingWriter struct {
+	err error
+}
+
+func (w failingWriter) Write([]byte) (int, error) {
+	return 0, w.err
+}
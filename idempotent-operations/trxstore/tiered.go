@@ -0,0 +1,167 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"math"
+	"time"
+)
+
+var _ TrxStore = (*TieredTrxStore)(nil)
+
+// TTLReporter is implemented by stores that tell how long an entry has
+// left, like BytesTrxStore and the Redis and SQL stores.
+type TTLReporter interface {
+	TTLRemaining(trx uuid.UUID) (time.Duration, bool)
+}
+
+// TieredTrxStore fronts a shared remote store with a local in-memory one.
+// Reads are served locally when possible and remote hits are copied into
+// the local store, writes go to both. Local entries live for the local
+// TTL, which bounds how long a delete made through another instance may
+// go unnoticed. A copied remote hit lives no longer than the remote entry
+// if the remote implements TTLReporter, at the cost of a second remote
+// read; otherwise it may outlive the remote entry by up to the local TTL,
+// which should then not exceed the remote one. TrxStore has no
+// error results, so a failing remote looks like a miss on reads and is
+// ignored on writes; the local store still keeps the result. The E methods
+// report the failures of a remote implementing TrxStoreE instead.
+type TieredTrxStore struct {
+	local  *BytesTrxStore
+	remote TrxStore
+}
+
+func NewTieredTRXStore(local *BytesTrxStore, remote TrxStore) *TieredTrxStore {
+	return &TieredTrxStore{local: local, remote: remote}
+}
+
+func (p *TieredTrxStore) Check(trx uuid.UUID) []byte {
+	res, _ := p.Lookup(trx)
+
+	return res
+}
+
+func (p *TieredTrxStore) Lookup(trx uuid.UUID) ([]byte, bool) {
+	if res, exists := p.local.Lookup(trx); exists {
+		return res, true
+	}
+
+	res, exists := p.remote.Lookup(trx)
+	if exists {
+		p.backfill(trx, res)
+	}
+
+	return res, exists
+}
+
+// backfill copies the remote hit res into the local tier, for the time
+// the remote entry has left if the remote reports it.
+func (p *TieredTrxStore) backfill(trx uuid.UUID, res []byte) {
+	ttl := p.local.entryTTL(trx)
+	if remote, ok := p.remote.(TTLReporter); ok {
+		left, exists := remote.TTLRemaining(trx)
+		if !exists || left <= 0 {
+			// the remote entry expired since it was read
+			return
+		}
+		if left < ttl || ttl == 0 && left < math.MaxInt64 {
+			ttl = left
+		}
+	}
+	p.local.StoreWithTTL(trx, res, ttl)
+}
+
+func (p *TieredTrxStore) Store(trx uuid.UUID, result []byte) {
+	p.remote.Store(trx, result)
+	p.local.Store(trx, result)
+}
+
+// StoreWithTTL gives trx the same ttl in both tiers.
+func (p *TieredTrxStore) StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration) {
+	p.remote.StoreWithTTL(trx, result, ttl)
+	p.local.StoreWithTTL(trx, result, ttl)
+}
+
+func (p *TieredTrxStore) Delete(trx uuid.UUID) bool {
+	remote := p.remote.Delete(trx)
+	local := p.local.Delete(trx)
+
+	return remote || local
+}
+
+// LookupE is like Lookup, but returns the error of a failing remote.
+func (p *TieredTrxStore) LookupE(ctx context.Context, trx uuid.UUID) ([]byte, bool, error) {
+	if res, exists, err := p.local.LookupE(ctx, trx); err != nil || exists {
+		return res, exists, err
+	}
+
+	remote, ok := p.remote.(TrxStoreE)
+	if !ok {
+		res, exists := p.remote.Lookup(trx)
+		if exists {
+			p.backfill(trx, res)
+		}
+
+		return res, exists, nil
+	}
+	res, exists, err := remote.LookupE(ctx, trx)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists {
+		p.backfill(trx, res)
+	}
+
+	return res, exists, nil
+}
+
+// StoreE stores result in the remote tier first and leaves the local tier
+// untouched if that fails, so a reported failure means trx is stored
+// nowhere.
+func (p *TieredTrxStore) StoreE(ctx context.Context, trx uuid.UUID, result []byte) error {
+	if remote, ok := p.remote.(TrxStoreE); ok {
+		if err := remote.StoreE(ctx, trx, result); err != nil {
+			return err
+		}
+	} else {
+		p.remote.Store(trx, result)
+	}
+
+	return p.local.StoreE(ctx, trx, result)
+}
+
+// StoreWithTTLE is like StoreE, but gives trx the same ttl in both tiers.
+func (p *TieredTrxStore) StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error {
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+	if remote, ok := p.remote.(TrxStoreE); ok {
+		if err := remote.StoreWithTTLE(ctx, trx, result, ttl); err != nil {
+			return err
+		}
+	} else {
+		p.remote.StoreWithTTL(trx, result, ttl)
+	}
+
+	return p.local.StoreWithTTLE(ctx, trx, result, ttl)
+}
+
+func (p *TieredTrxStore) DeleteE(ctx context.Context, trx uuid.UUID) (bool, error) {
+	remote, ok := p.remote.(TrxStoreE)
+	if !ok {
+		return p.Delete(trx), nil
+	}
+	deleted, err := remote.DeleteE(ctx, trx)
+	if err != nil {
+		return false, err
+	}
+	local, err := p.local.DeleteE(ctx, trx)
+
+	return deleted || local, err
+}
+
+// Close closes both tiers.
+func (p *TieredTrxStore) Close() {
+	p.local.Close()
+	p.remote.Close()
+}
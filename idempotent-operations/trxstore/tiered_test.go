@@ -0,0 +1,113 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestTieredTrxStore(t *testing.T) {
+	remote := NewBytesTRXStore(time.Minute)
+	local := NewBytesTRXStore(time.Minute)
+	store := NewTieredTRXStore(local, remote)
+	defer store.Close()
+
+	stored, shared := uuid.New(), uuid.New()
+	store.Store(stored, []byte("stored"))
+	if !bytes.Equal(remote.Check(stored), []byte("stored")) || !bytes.Equal(local.Check(stored), []byte("stored")) {
+		t.Fatalf("store not written to both tiers")
+	}
+
+	remote.Store(shared, []byte("shared"))
+	if !bytes.Equal(store.Check(shared), []byte("shared")) {
+		t.Fatalf("remote hit not returned")
+	}
+	if !bytes.Equal(local.Check(shared), []byte("shared")) {
+		t.Fatalf("remote hit not copied to local tier")
+	}
+
+	if !store.Delete(shared) || local.Len() != 1 || remote.Len() != 1 {
+		t.Fatalf("delete not applied to both tiers")
+	}
+	if _, exists := store.Lookup(uuid.New()); exists {
+		t.Fatalf("missing trx reported")
+	}
+}
+
+func TestTieredTrxStoreBackfillTTL(t *testing.T) {
+	clock := manualclock.New()
+	remote := NewBytesTRXStore(time.Hour, WithClock(clock))
+	local := NewBytesTRXStore(time.Hour, WithClock(clock))
+	store := NewTieredTRXStore(local, remote)
+	defer store.Close()
+
+	short, long := uuid.New(), uuid.New()
+	remote.StoreWithTTL(short, []byte("short"), time.Minute)
+	remote.StoreWithTTL(long, []byte("long"), 2*time.Hour)
+	clock.Advance(30 * time.Second)
+	store.Check(short)
+	if _, _, err := store.LookupE(context.Background(), long); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	if ttl, _ := local.TTLRemaining(short); ttl != 30*time.Second {
+		t.Fatalf("local copy outlives the remote entry: %v left", ttl)
+	}
+	if ttl, _ := local.TTLRemaining(long); ttl != time.Hour {
+		t.Fatalf("local copy exceeds the local ttl: %v left", ttl)
+	}
+	clock.Advance(30*time.Second + time.Nanosecond)
+	if local.Check(short) != nil || store.Check(short) != nil {
+		t.Fatalf("expired remote entry still served")
+	}
+}
+
+var errRemote = errors.New("remote unavailable")
+
+// failingStore is a remote tier whose E methods always fail.
+type failingStore struct {
+	TrxStore
+}
+
+func (failingStore) LookupE(context.Context, uuid.UUID) ([]byte, bool, error) {
+	return nil, false, errRemote
+}
+
+func (failingStore) StoreE(context.Context, uuid.UUID, []byte) error {
+	return errRemote
+}
+
+func (failingStore) StoreWithTTLE(context.Context, uuid.UUID, []byte, time.Duration) error {
+	return errRemote
+}
+
+func (failingStore) DeleteE(context.Context, uuid.UUID) (bool, error) {
+	return false, errRemote
+}
+
+func TestTieredTrxStoreRemoteErrors(t *testing.T) {
+	trx := uuid.New()
+	ctx := context.Background()
+	local := NewBytesTRXStore(time.Minute)
+	store := NewTieredTRXStore(local, failingStore{NewBytesTRXStore(time.Minute)})
+	defer store.Close()
+
+	if err := store.StoreE(ctx, trx, []byte("test")); !errors.Is(err, errRemote) || local.Len() != 0 {
+		t.Fatalf("expected remote error without local store, got %v", err)
+	}
+	if _, _, err := store.LookupE(ctx, trx); !errors.Is(err, errRemote) {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+
+	local.Store(trx, []byte("local"))
+	if res, exists, err := store.LookupE(ctx, trx); err != nil || !exists || !bytes.Equal(res, []byte("local")) {
+		t.Fatalf("local hit not served: %q, %v", res, err)
+	}
+	if _, err := store.DeleteE(ctx, trx); !errors.Is(err, errRemote) {
+		t.Fatalf("expected remote error, got %v", err)
+	}
+}
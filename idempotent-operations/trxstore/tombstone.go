@@ -0,0 +1,101 @@
+package trxstore
+
+import (
+	"container/heap"
+	"github.com/google/uuid"
+	"time"
+)
+
+// pendingDrop is a value due to be dropped at the given time, see
+// WithTombstones.
+type pendingDrop struct {
+	trx uuid.UUID
+	at  time.Time
+}
+
+// dropHeap orders the values to drop by time. Entries are not removed from
+// it when their key is deleted or stored again; dropValues skips those
+// that are stale.
+type dropHeap []pendingDrop
+
+func (h dropHeap) Len() int {
+	return len(h)
+}
+
+func (h dropHeap) Less(i, j int) bool {
+	return h[i].at.Before(h[j].at)
+}
+
+func (h dropHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+}
+
+func (h *dropHeap) Push(x any) {
+	*h = append(*h, x.(pendingDrop))
+}
+
+func (h *dropHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	*h = old[:n-1]
+
+	return item
+}
+
+// tombstoned reports whether the value of the held entry trx is past the
+// WithTombstones age. It must be called with the shard lock held.
+func (s *shard) tombstoned(trx uuid.UUID, now time.Time) bool {
+	at := s.cacheMeta[trx].dropAt
+	return !at.IsZero() && now.After(at)
+}
+
+// scheduleDrop queues the value of a just stored entry to be dropped once
+// it reaches the WithTombstones age, unless the entry expires before that.
+// Unlike the deadline, the time is not moved by a sliding expiration.
+func (s *shard) scheduleDrop(meta *entryMeta, createdAt time.Time) {
+	meta.dropAt = time.Time{}
+	if s.valueTTL <= 0 {
+		return
+	}
+	if at := createdAt.Add(s.valueTTL); meta.deadline.After(at) {
+		meta.dropAt = at
+		heap.Push(&s.drops, pendingDrop{trx: meta.trx, at: at})
+	}
+}
+
+// dropValues frees the values of live entries that became tombstones. It
+// must be called with the shard write lock held.
+func (s *shard) dropValues(now time.Time) {
+	for len(s.drops) > 0 && now.After(s.drops[0].at) {
+		drop := heap.Pop(&s.drops).(pendingDrop)
+		trx := drop.trx
+		// the entry may have been removed or stored again since
+		if _, exists := s.lookup(trx, now); exists && s.cacheMeta[trx].dropAt.Equal(drop.at) {
+			s.bytes.Add(-int64(len(s.cache[trx])))
+			s.cache[trx] = nil
+		}
+	}
+}
+
+// CheckState is a read of trx like Lookup that also tells a tombstone,
+// see WithTombstones, from a value: it returns the value and
+// StateCompleted for a live value, StateTombstone for a key whose value
+// was dropped, and StateAbsent otherwise.
+func (p *BytesTrxStore) CheckState(trx uuid.UUID) ([]byte, EntryState) {
+	var tombstone bool
+	res, exists := p.lookup(trx, func(s *shard, trx uuid.UUID, now time.Time) ([]byte, bool) {
+		res, exists := p.get(s, trx, now)
+		tombstone = exists && s.tombstoned(trx, now)
+
+		return res, exists
+	})
+	switch {
+	case tombstone:
+		return nil, StateTombstone
+	case exists:
+		return res, StateCompleted
+	default:
+		return nil, StateAbsent
+	}
+}
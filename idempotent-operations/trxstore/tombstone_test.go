@@ -0,0 +1,109 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTombstones(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithTombstones(10*time.Minute))
+	store.Close()
+
+	value := bytes.Repeat([]byte("x"), 1024)
+	store.Store(trx, value)
+	if res, state := store.CheckState(trx); state != StateCompleted || !bytes.Equal(res, value) {
+		t.Fatalf("got %v with %d bytes, want the value", state, len(res))
+	}
+
+	clock.Advance(10*time.Minute + time.Nanosecond)
+	if res, state := store.CheckState(trx); state != StateTombstone || res != nil {
+		t.Fatalf("got %v with %d bytes, want a tombstone", state, len(res))
+	}
+	if res, exists := store.Lookup(trx); !exists || res != nil || store.State(trx) != StateTombstone {
+		t.Fatalf("tombstone not reported as seen without a value")
+	}
+	store.ForceCleanup()
+	if store.Len() != 1 || store.Bytes() != 0 {
+		t.Fatalf("expected the key kept without its value, got %d entries of %d bytes", store.Len(), store.Bytes())
+	}
+
+	clock.Advance(50 * time.Minute)
+	if _, state := store.CheckState(trx); state != StateAbsent {
+		t.Fatalf("got %v after the ttl, want absent", state)
+	}
+	store.ForceCleanup()
+	if store.Len() != 0 {
+		t.Fatalf("expired tombstone kept")
+	}
+}
+
+func TestTombstonesStoredAgain(t *testing.T) {
+	trx, short := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithTombstones(10*time.Minute), WithSlidingExpiration(true))
+	store.Close()
+
+	store.Store(trx, []byte("first"))
+	store.StoreWithTTL(short, []byte("short"), 10*time.Minute)
+	for i := 0; i < 3; i++ {
+		clock.Advance(4 * time.Minute)
+		// reads slide the ttl but not the age of the value
+		store.Check(trx)
+	}
+	store.ForceCleanup()
+	if _, state := store.CheckState(trx); state != StateTombstone {
+		t.Fatalf("got %v, want a tombstone", state)
+	}
+	if _, state := store.CheckState(short); state != StateAbsent {
+		t.Fatalf("entry expiring before its value age got %v, want absent", state)
+	}
+
+	store.Store(trx, []byte("second"))
+	clock.Advance(5 * time.Minute)
+	store.ForceCleanup()
+	if res, state := store.CheckState(trx); state != StateCompleted || !bytes.Equal(res, []byte("second")) {
+		t.Fatalf("got %v %q after storing again", state, res)
+	}
+}
+
+func TestTombstonesWithCodec(t *testing.T) {
+	trx, kept := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	var logs lockedBuffer
+	var expired [][]byte
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithTombstones(10*time.Minute),
+		WithEncryption(bytes.Repeat([]byte("k"), 32)), WithLogger(slog.New(slog.NewTextHandler(&logs, nil))),
+		WithOnExpire(func(_ uuid.UUID, value []byte) { expired = append(expired, value) }))
+	store.Close()
+
+	store.Store(trx, []byte("value"))
+	store.Store(kept, []byte("value"))
+	clock.Advance(10*time.Minute + time.Nanosecond)
+	var transformed int
+	store.Transform(func(uuid.UUID, []byte) ([]byte, bool) {
+		transformed++
+		return []byte("new"), true
+	})
+	if transformed != 0 {
+		t.Fatalf("transform called on %d tombstones", transformed)
+	}
+	if _, state := store.CheckState(kept); state != StateTombstone {
+		t.Fatalf("got %v, want the tombstone kept", state)
+	}
+
+	store.Expire(trx)
+	store.ForceCleanup()
+	if len(expired) != 1 || expired[0] != nil {
+		t.Fatalf("expected the tombstone reported without a value, got %q", expired)
+	}
+	if strings.Contains(logs.String(), "failed to decode") {
+		t.Fatalf("tombstone decoded: %s", logs.String())
+	}
+}
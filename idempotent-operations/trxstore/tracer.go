@@ -0,0 +1,34 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+)
+
+// storeNameKey is the context key of the store name passed to tracers.
+type storeNameKey struct{}
+
+// Tracer is notified when a lookup or store starts and calls the returned
+// function when it finishes. It keeps tracing libraries out of this
+// package; nothing is traced unless WithTracer is set.
+type Tracer interface {
+	TraceLookup(ctx context.Context, trx uuid.UUID) func(hit bool, size int)
+	TraceStore(ctx context.Context, trx uuid.UUID, size int) func()
+}
+
+// StoreName returns the WithName name of the store tracing an operation
+// under ctx, for Tracer implementations to label spans with, or "" if the
+// store has none.
+func StoreName(ctx context.Context) string {
+	name, _ := ctx.Value(storeNameKey{}).(string)
+	return name
+}
+
+// traceContext returns ctx carrying the store name for the tracer.
+func (p *BytesTrxStore) traceContext(ctx context.Context) context.Context {
+	if p.opts.name == "" {
+		return ctx
+	}
+
+	return context.WithValue(ctx, storeNameKey{}, p.opts.name)
+}
@@ -0,0 +1,107 @@
+package trxgrpc
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"strings"
+)
+
+const defaultMetadataKey = "idempotency-key"
+
+// Store is the part of a trx store the interceptor needs.
+// *trxstore.BytesTrxStore implements it.
+type Store interface {
+	Do(trx uuid.UUID, fn func() ([]byte, error)) ([]byte, error)
+}
+
+type Option func(*options)
+
+type options struct {
+	metadataKey string
+}
+
+// WithMetadataKey sets the incoming metadata key carrying the idempotency
+// token, "idempotency-key" by default.
+func WithMetadataKey(key string) Option {
+	return func(o *options) {
+		if key != "" {
+			o.metadataKey = strings.ToLower(key)
+		}
+	}
+}
+
+// UnaryServerInterceptor returns the stored response for calls carrying an
+// already seen idempotency token instead of invoking the handler again.
+// Concurrent calls with the same token invoke the handler only once.
+// Responses are stored as marshaled anypb.Any, so they must be proto
+// messages whose types are linked into the binary. Handler errors are not
+// stored. Calls without the token are passed through, and tokens that are
+// not UUIDs are rejected with InvalidArgument.
+func UnaryServerInterceptor(store Store, opts ...Option) grpc.UnaryServerInterceptor {
+	o := options{metadataKey: defaultMetadataKey}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		values := md.Get(o.metadataKey)
+		if len(values) == 0 || values[0] == "" {
+			return handler(ctx, req)
+		}
+
+		trx, err := uuid.Parse(values[0])
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s metadata", o.metadataKey)
+		}
+
+		encoded, err := store.Do(trx, func() ([]byte, error) {
+			resp, err := handler(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+
+			msg, ok := resp.(proto.Message)
+			if !ok {
+				return nil, status.Errorf(codes.Internal, "response %T is not a proto message", resp)
+			}
+			packed, err := anypb.New(msg)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to pack response: %v", err)
+			}
+
+			return proto.Marshal(packed)
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := unmarshal(encoded)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to restore response: %v", err)
+		}
+
+		return resp, nil
+	}
+}
+
+func unmarshal(encoded []byte) (proto.Message, error) {
+	var packed anypb.Any
+	if err := proto.Unmarshal(encoded, &packed); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal any: %w", err)
+	}
+
+	msg, err := packed.UnmarshalNew()
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal %s: %w", packed.TypeUrl, err)
+	}
+
+	return msg, nil
+}
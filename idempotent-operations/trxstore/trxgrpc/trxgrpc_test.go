@@ -0,0 +1,93 @@
+package trxgrpc
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestInterceptorReplays(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	interceptor := UnaryServerInterceptor(store, WithMetadataKey("X-Request-Id"))
+	var calls atomic.Int32
+	handler := func(ctx context.Context, req any) (any, error) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		return wrapperspb.String("charged " + req.(*wrapperspb.StringValue).GetValue()), nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("x-request-id", uuid.New().String()))
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := interceptor(ctx, wrapperspb.String("42"), &grpc.UnaryServerInfo{}, handler)
+			if err != nil {
+				t.Errorf("unexpected error %v", err)
+				return
+			}
+			if !proto.Equal(resp.(proto.Message), wrapperspb.String("charged 42")) {
+				t.Errorf("unexpected response %v", resp)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times", calls.Load())
+	}
+}
+
+func TestInterceptorErrorNotCached(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	interceptor := UnaryServerInterceptor(store)
+	var calls atomic.Int32
+	handler := func(ctx context.Context, req any) (any, error) {
+		if calls.Add(1) == 1 {
+			return nil, status.Error(codes.Unavailable, "try later")
+		}
+		return wrapperspb.Bool(true), nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(defaultMetadataKey, uuid.New().String()))
+	if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); status.Code(err) != codes.Unavailable {
+		t.Fatalf("expected Unavailable, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler); err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("handler called %d times", calls.Load())
+	}
+}
+
+func TestInterceptorInvalidKey(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(defaultMetadataKey, "not-a-uuid"))
+	_, err := UnaryServerInterceptor(store)(ctx, nil, &grpc.UnaryServerInfo{}, func(ctx context.Context, req any) (any, error) {
+		t.Fatalf("handler called with invalid key")
+		return nil, nil
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("expected InvalidArgument, got %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+package trxhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"net/http"
+)
+
+const HeaderName = "Idempotency-Key"
+
+// Store is the part of a trx store the middleware needs.
+// *trxstore.BytesTrxStore implements it.
+type Store interface {
+	Do(trx uuid.UUID, fn func() ([]byte, error)) ([]byte, error)
+}
+
+// CapturedResponse is a handler response as kept in the store.
+type CapturedResponse struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header"`
+	Body   []byte      `json:"body"`
+}
+
+// uncachedResponse carries a response that must reach the client but not
+// the store.
+type uncachedResponse struct {
+	response *CapturedResponse
+}
+
+func (e *uncachedResponse) Error() string {
+	return fmt.Sprintf("response with status %d is not cached", e.response.Status)
+}
+
+// Middleware replays the stored response for requests carrying an already
+// seen Idempotency-Key header instead of calling next again. Concurrent
+// requests with the same key run next only once and all get its response.
+// Responses are buffered, only headers listed in replayHeaders are kept,
+// and 5xx responses are not stored so that retries run again. Requests
+// without the header are passed through, and keys that are not UUIDs are
+// rejected with 400.
+func Middleware(store Store, replayHeaders ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(HeaderName)
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			trx, err := uuid.Parse(key)
+			if err != nil {
+				http.Error(w, "invalid "+HeaderName+" header", http.StatusBadRequest)
+				return
+			}
+
+			var uncached *uncachedResponse
+			encoded, err := store.Do(trx, func() ([]byte, error) {
+				response := capture(next, r, replayHeaders)
+				if response.Status >= http.StatusInternalServerError {
+					return nil, &uncachedResponse{response: response}
+				}
+
+				return json.Marshal(response)
+			})
+			if errors.As(err, &uncached) {
+				replay(w, uncached.response)
+				return
+			}
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+
+			var response CapturedResponse
+			if err := json.Unmarshal(encoded, &response); err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			replay(w, &response)
+		})
+	}
+}
+
+func capture(next http.Handler, r *http.Request, replayHeaders []string) *CapturedResponse {
+	recorder := &responseRecorder{header: http.Header{}}
+	next.ServeHTTP(recorder, r)
+
+	response := &CapturedResponse{
+		Status: recorder.status,
+		Header: http.Header{},
+		Body:   recorder.body.Bytes(),
+	}
+	if response.Status == 0 {
+		response.Status = http.StatusOK
+	}
+	for _, name := range replayHeaders {
+		if values := recorder.header.Values(name); len(values) > 0 {
+			response.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	return response
+}
+
+func replay(w http.ResponseWriter, response *CapturedResponse) {
+	for name, values := range response.Header {
+		w.Header()[name] = values
+	}
+	w.WriteHeader(response.Status)
+	_, _ = w.Write(response.Body)
+}
+
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+
+	return r.body.Write(b)
+}
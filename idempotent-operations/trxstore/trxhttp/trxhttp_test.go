@@ -0,0 +1,97 @@
+package trxhttp
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMiddlewareReplays(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	var calls atomic.Int32
+	handler := Middleware(store, "Content-Type")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Request-Time", time.Now().String())
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(`{"id":1}`))
+	}))
+
+	key := uuid.New().String()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			request := httptest.NewRequest(http.MethodPost, "/payments", nil)
+			request.Header.Set(HeaderName, key)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			if response.Code != http.StatusCreated || response.Body.String() != `{"id":1}` {
+				t.Errorf("unexpected response %d %q", response.Code, response.Body.String())
+			}
+			if response.Header().Get("Content-Type") != "application/json" || response.Header().Get("X-Request-Time") != "" {
+				t.Errorf("unexpected headers %v", response.Header())
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("handler called %d times", calls.Load())
+	}
+}
+
+func TestMiddlewareServerErrorNotCached(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	var calls atomic.Int32
+	handler := Middleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	key := uuid.New().String()
+	for _, expected := range []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK} {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.Header.Set(HeaderName, key)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != expected {
+			t.Fatalf("expected %d, got %d", expected, response.Code)
+		}
+	}
+	if calls.Load() != 2 {
+		t.Fatalf("handler called %d times", calls.Load())
+	}
+}
+
+func TestMiddlewareInvalidKey(t *testing.T) {
+	store := trxstore.NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	handler := Middleware(store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatalf("handler called with invalid key")
+	}))
+
+	request := httptest.NewRequest(http.MethodPost, "/", nil)
+	request.Header.Set(HeaderName, "not-a-uuid")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400, got %d", response.Code)
+	}
+}
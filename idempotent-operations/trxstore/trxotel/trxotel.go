@@ -0,0 +1,56 @@
+package trxotel
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	keyAttribute  = attribute.Key("trxstore.key")
+	hitAttribute  = attribute.Key("trxstore.hit")
+	sizeAttribute = attribute.Key("trxstore.value_size")
+	nameAttribute = attribute.Key("trxstore.name")
+)
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+// NewTracer returns a trxstore.Tracer that records a span for every
+// lookup and store, to be passed to trxstore.WithTracer. Spans of named
+// stores carry the name.
+func NewTracer(t trace.Tracer) trxstore.Tracer {
+	return tracer{tracer: t}
+}
+
+func (t tracer) TraceLookup(ctx context.Context, trx uuid.UUID) func(hit bool, size int) {
+	_, span := t.tracer.Start(ctx, "trxstore.Check", trace.WithAttributes(attributes(ctx, keyAttribute.String(trx.String()))...))
+
+	return func(hit bool, size int) {
+		span.SetAttributes(hitAttribute.Bool(hit), sizeAttribute.Int(size))
+		span.End()
+	}
+}
+
+func (t tracer) TraceStore(ctx context.Context, trx uuid.UUID, size int) func() {
+	_, span := t.tracer.Start(ctx, "trxstore.Store", trace.WithAttributes(attributes(ctx,
+		keyAttribute.String(trx.String()),
+		sizeAttribute.Int(size),
+	)...))
+
+	return func() {
+		span.End()
+	}
+}
+
+// attributes adds the store name, if there is one, to attrs.
+func attributes(ctx context.Context, attrs ...attribute.KeyValue) []attribute.KeyValue {
+	if name := trxstore.StoreName(ctx); name != "" {
+		attrs = append(attrs, nameAttribute.String(name))
+	}
+
+	return attrs
+}
@@ -0,0 +1,60 @@
+package trxotel
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"testing"
+	"time"
+)
+
+func TestTracer(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := trxstore.NewBytesTRXStore(time.Minute, trxstore.WithTracer(NewTracer(provider.Tracer("test"))))
+	defer store.Close()
+
+	trx := uuid.New()
+	store.Store(trx, []byte("test"))
+	store.Check(trx)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	if spans[0].Name() != "trxstore.Store" || spans[1].Name() != "trxstore.Check" {
+		t.Fatalf("unexpected spans %s, %s", spans[0].Name(), spans[1].Name())
+	}
+
+	attributes := map[string]string{}
+	for _, attr := range spans[1].Attributes() {
+		attributes[string(attr.Key)] = attr.Value.Emit()
+	}
+	if attributes["trxstore.hit"] != "true" || attributes["trxstore.key"] != trx.String() || attributes["trxstore.value_size"] != "4" {
+		t.Fatalf("unexpected attributes %v", attributes)
+	}
+}
+
+func TestTracerStoreName(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	store := trxstore.NewBytesTRXStore(time.Minute, trxstore.WithName("payments"),
+		trxstore.WithTracer(NewTracer(provider.Tracer("test"))))
+	defer store.Close()
+
+	store.Store(uuid.New(), nil)
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("expected 1 span, got %d", len(spans))
+	}
+	for _, span := range spans {
+		var named bool
+		for _, attr := range span.Attributes() {
+			named = named || attr.Key == nameAttribute && attr.Value.AsString() == "payments"
+		}
+		if !named {
+			t.Fatalf("span %s without the store name: %v", span.Name(), span.Attributes())
+		}
+	}
+}
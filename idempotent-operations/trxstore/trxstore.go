@@ -1,89 +1,1212 @@
 package trxstore
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"github.com/google/uuid"
-	"sync"
+	"hash/maphash"
+	"log/slog"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
 	"time"
 )
 
 const sleepBetweenExpireCheck = 100 * time.Millisecond
 
+var ErrInvalidTTL = errors.New("trxstore: ttl must not be negative")
+
+// ErrValueTooLarge is returned for values over the WithMaxValueSize limit.
+var ErrValueTooLarge = errors.New("trxstore: value exceeds the maximum size")
+
+// ErrNotFound is returned by Get for missing and expired entries.
+var ErrNotFound = errors.New("trxstore: trx not found")
+
+// ErrInFlight is returned for stores rejected by InFlightStoreReject.
+var ErrInFlight = errors.New("trxstore: trx is in flight")
+
+// ErrWriteThrough wraps the error of a WithWriteThrough secondary that
+// failed to store a result the store itself holds.
+var ErrWriteThrough = errors.New("trxstore: write-through failed")
+
+// TrxStore is implemented by every store of idempotency results, so
+// callers can depend on it instead of a concrete backend. StoreWithTTL
+// overrides the store-wide TTL for one entry, a zero ttl meaning it never
+// expires; trxstoretest checks that backends agree on this.
+type TrxStore interface {
+	Check(trx uuid.UUID) []byte
+	Lookup(trx uuid.UUID) ([]byte, bool)
+	Store(trx uuid.UUID, result []byte)
+	StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration)
+	Delete(trx uuid.UUID) bool
+	Close()
+}
+
+var _ TrxStore = (*BytesTrxStore)(nil)
+
 type BytesTrxStore struct {
-	cache       map[uuid.UUID][]byte
-	cacheLock   sync.RWMutex
-	cacheExpire map[uuid.UUID]time.Time
-	ttl         time.Duration
+	shards   []*shard
+	ttl      atomic.Int64
+	opts     options
+	stats    stats
+	ctx      context.Context
+	janitor  *janitor
+	events   *eventChannel[ExpireEvent]
+	warnings *eventChannel[ExpiryWarning]
+	codecs   []valueCodec
+	mirrored *writeThrough
+	ttlSeed  maphash.Seed
+	// lastSweep is the UnixNano time the last cleanup finished.
+	lastSweep atomic.Int64
+	// ticking is set while a cleanup run by the janitor or Tick is going.
+	ticking atomic.Bool
 }
 
-func NewBytesTRXStoreWithContext(ctx context.Context, ttl time.Duration) *BytesTrxStore {
+// New creates a store configured by opts, see the With functions for the
+// defaults. It panics with ErrInvalidTTL if the TTL is negative, since
+// such a store would drop every result right away, and if other options
+// are invalid, such as an encryption key of a wrong size. A zero TTL means
+// entries never expire and no background cleanup is started, so entries
+// stored with their own TTL then expire only lazily.
+func New(opts ...Option) *BytesTrxStore {
+	res, err := NewE(opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return res
+}
+
+// NewE is like New, but returns an error instead of panicking.
+func NewE(opts ...Option) (*BytesTrxStore, error) {
+	return newStore(newOptions(opts))
+}
+
+func newStore(opts options) (*BytesTrxStore, error) {
 	res := &BytesTrxStore{
-		cache:       map[uuid.UUID][]byte{},
-		cacheLock:   sync.RWMutex{},
-		cacheExpire: map[uuid.UUID]time.Time{},
-		ttl:         ttl,
+		opts: opts,
+	}
+	if err := validateTTL(res.opts.ttl); err != nil {
+		return nil, err
+	}
+	res.ctx = res.opts.ctx
+	codecs, err := newCodecs(res.opts)
+	if err != nil {
+		return nil, err
+	}
+	res.codecs = codecs
+	res.janitor = newJanitor(res.opts.logger)
+	res.janitor.strict = res.opts.strictMode
+	if res.opts.writeThrough != nil {
+		res.mirrored = newWriteThrough(res.opts)
+	}
+	res.lastSweep.Store(res.opts.clock.Now().UnixNano())
+	res.ttl.Store(int64(res.opts.ttl))
+	if res.opts.ttlJitter > 0 {
+		res.ttlSeed = maphash.MakeSeed()
+	}
+	if res.opts.expireChannel > 0 {
+		res.events = newEventChannel[ExpireEvent](res.opts.expireChannel)
+	}
+	if res.opts.expiryWarning > 0 {
+		res.warnings = newEventChannel[ExpiryWarning](defaultExpireChannelBuffer)
+	}
+	res.shards = make([]*shard, res.opts.shards)
+	for i := range res.shards {
+		res.shards[i] = newShard(
+			int(shardLimit(int64(res.opts.maxEntries), res.opts.shards)),
+			shardLimit(res.opts.maxBytes, res.opts.shards),
+			int(shardLimit(int64(res.opts.initialCapacity), res.opts.shards)),
+			res.opts.evictionPolicy,
+		)
+		if res.opts.bloomFilterSize > 0 {
+			res.shards[i].enableBloom(int(shardLimit(int64(res.opts.bloomFilterSize), res.opts.shards)))
+		}
+		res.shards[i].inlineMax = res.opts.inlineExpiry
+		res.shards[i].valueTTL = res.opts.valueTTL
 	}
 
-	go res.watchExpire(ctx)
+	if res.opts.ttl > 0 {
+		res.startJanitor()
+	}
 
-	return res
+	return res, nil
+}
+
+// NewBytesTRXStoreWithContext is New with WithContext(ctx) and
+// WithTTL(ttl), which take precedence over opts.
+func NewBytesTRXStoreWithContext(ctx context.Context, ttl time.Duration, opts ...Option) *BytesTrxStore {
+	return New(slices.Concat(opts, []Option{WithContext(ctx), WithTTL(ttl)})...)
+}
+
+func NewBytesTRXStore(ttl time.Duration, opts ...Option) *BytesTrxStore {
+	return NewBytesTRXStoreWithContext(nil, ttl, opts...)
+}
+
+// NewBytesTRXStoreE is like NewBytesTRXStore, but returns an error
+// instead of panicking.
+func NewBytesTRXStoreE(ttl time.Duration, opts ...Option) (*BytesTrxStore, error) {
+	return NewE(slices.Concat(opts, []Option{WithTTL(ttl)})...)
 }
 
-func NewBytesTRXStore(ttl time.Duration) *BytesTrxStore {
-	return NewBytesTRXStoreWithContext(nil, ttl)
+// Fork creates an empty store configured like p, with the current TTL of
+// p, for example to isolate tenants without repeating the options. The
+// fork has its own entries, locks and cleanup, which stops with the
+// context of p; only the configured callbacks, loader, tracer and
+// write-through secondary are shared. A fork of a StoreGroup store is not
+// part of the group.
+func (p *BytesTrxStore) Fork() *BytesTrxStore {
+	opts := p.opts
+	opts.ttl = p.TTL()
+	opts.sharedCleanup = false
+	res, err := newStore(opts)
+	if err != nil {
+		// the options were accepted for p
+		panic(err)
+	}
+
+	return res
 }
 
+// Check returns a copy of the live value of trx, or nil if there is none.
+// It cannot tell a stored empty result from a missing one; use Lookup or
+// Get where that matters.
 func (p *BytesTrxStore) Check(trx uuid.UUID) []byte {
-	p.cacheLock.RLock()
-	res := p.cache[trx]
-	p.cacheLock.RUnlock()
+	res, _ := p.Lookup(trx)
 
 	return res
 }
 
-func (p *BytesTrxStore) Store(trx uuid.UUID, result []byte) {
-	p.cacheLock.Lock()
-	p.cache[trx] = result
-	p.cacheExpire[trx] = time.Now()
-	p.cacheLock.Unlock()
+// Get is like Lookup, but reports a missing trx as ErrNotFound, for call
+// sites that already handle errors. The three ways to read a value are
+// equivalent otherwise: Check suits results that are never empty, Lookup
+// is the general form, and Get composes with errors.Is.
+func (p *BytesTrxStore) Get(trx uuid.UUID) ([]byte, error) {
+	res, exists := p.Lookup(trx)
+	if !exists {
+		return nil, ErrNotFound
+	}
+
+	return res, nil
+}
+
+// Lookup reports whether trx is present, so a stored empty result can be
+// told apart from a missing one. The returned slice is a copy and may be
+// modified by the caller. Entries past their TTL are reported as missing
+// even if the background cleanup has not removed them yet.
+func (p *BytesTrxStore) Lookup(trx uuid.UUID) ([]byte, bool) {
+	if p.opts.tracer != nil {
+		return p.lookupTraced(context.Background(), trx)
+	}
+
+	return p.lookup(trx, p.get)
 }
 
-func (p *BytesTrxStore) watchExpire(ctx context.Context) {
-	timer := time.NewTicker(sleepBetweenExpireCheck)
+// Peek is an observational Lookup for debugging: it neither restarts a
+// sliding TTL nor counts as recent use for eviction, and it is not counted
+// in Stats or traced.
+func (p *BytesTrxStore) Peek(trx uuid.UUID) ([]byte, bool) {
+	s := p.shard(trx)
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
 
-	if ctx == nil {
-		for range timer.C {
-			p.cleanupExpired()
+	return p.get(s, trx, p.opts.clock.Now())
+}
+
+// CheckCtx is like Check, but returns ctx.Err() if ctx is already done.
+// ctx is also passed to the tracer, if one is set.
+func (p *BytesTrxStore) CheckCtx(ctx context.Context, trx uuid.UUID) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if p.opts.tracer != nil {
+		res, _ := p.lookupTraced(ctx, trx)
+
+		return res, nil
+	}
+	res, _ := p.lookup(trx, p.get)
+
+	return res, nil
+}
+
+// CheckRef is like Lookup, but returns the slice held by the store instead
+// of a copy, saving an allocation on hot read paths. The caller must not
+// modify it: it is shared with every other reader of trx. Stores of trx
+// replace the slice rather than writing to it, so a returned slice keeps
+// the value it had, also after trx expires. With WithCompression or
+// WithEncryption values are held in stored form, and CheckRef returns a
+// decoded copy like Lookup.
+func (p *BytesTrxStore) CheckRef(trx uuid.UUID) ([]byte, bool) {
+	if p.opts.tracer != nil {
+		end := p.opts.tracer.TraceLookup(p.traceContext(context.Background()), trx)
+		res, exists := p.lookup(trx, p.getRef)
+		end(exists, len(res))
+
+		return res, exists
+	}
+
+	return p.lookup(trx, p.getRef)
+}
+
+func (p *BytesTrxStore) lookupTraced(ctx context.Context, trx uuid.UUID) ([]byte, bool) {
+	end := p.opts.tracer.TraceLookup(p.traceContext(ctx), trx)
+	res, exists := p.lookup(trx, p.get)
+	end(exists, len(res))
+
+	return res, exists
+}
+
+// lookup reads trx with get, which returns either a copy or the held
+// slice.
+func (p *BytesTrxStore) lookup(trx uuid.UUID, get func(s *shard, trx uuid.UUID, now time.Time) ([]byte, bool)) ([]byte, bool) {
+	if p.opts.onSlowOp != nil {
+		defer p.timeOp(opCheck, trx, time.Now())
+	}
+	if !p.mayContain(trx) {
+		p.countLookup(false)
+
+		return nil, false
+	}
+	if p.exclusiveReads() {
+		return p.lookupExclusive(trx, get)
+	}
+
+	s := p.shard(trx)
+	s.cacheLock.RLock()
+	if s.limitedReads(trx) {
+		s.cacheLock.RUnlock()
+
+		return p.lookupExclusive(trx, get)
+	}
+	res, exists := get(s, trx, p.opts.clock.Now())
+	s.cacheLock.RUnlock()
+	p.countLookup(exists)
+
+	return res, exists
+}
+
+func (p *BytesTrxStore) lookupExclusive(trx uuid.UUID, get func(s *shard, trx uuid.UUID, now time.Time) ([]byte, bool)) ([]byte, bool) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	res, exists := get(s, trx, now)
+	var evicted []expiredEntry
+	if exists {
+		p.accessed(s, trx, now)
+		if entry, consumed := s.consumeRead(trx, now); consumed {
+			evicted = append(evicted, entry)
 		}
+	}
+	s.cacheLock.Unlock()
+	p.countLookup(exists)
+	p.notify(evicted)
+
+	return res, exists
+}
+
+// Store keeps a copy of result, so the caller is free to reuse its buffer.
+func (p *BytesTrxStore) Store(trx uuid.UUID, result []byte) {
+	p.StoreWithTTL(trx, result, p.entryTTL(trx))
+}
+
+// StoreWithTTL is like Store, but trx expires after ttl instead of the
+// store-wide TTL. A zero ttl means trx never expires.
+func (p *BytesTrxStore) StoreWithTTL(trx uuid.UUID, result []byte, ttl time.Duration) {
+	p.logRejected(trx, result, p.storeWithTTL(context.Background(), trx, result, ttl, 0))
+}
+
+// StoreWithDeadline is like Store, but trx expires at the absolute time
+// deadline instead of after a TTL, for example the exp claim of a token.
+// The deadline takes precedence over WithAnchoredExpiry, and a sliding
+// expiration extends it by the time left when it was stored. A deadline
+// that is not after the current time stores nothing and leaves any entry
+// of trx as it is. The deadline is turned into the time left when it is
+// stored, so with SystemClock the entry then expires on the monotonic
+// clock, and later adjustments of the wall clock neither extend its life
+// nor cut it short.
+func (p *BytesTrxStore) StoreWithDeadline(trx uuid.UUID, result []byte, deadline time.Time) {
+	if !p.opts.clock.Now().Before(deadline) {
+		return
+	}
+
+	p.logRejected(trx, result, p.storeUntil(context.Background(), trx, result, 0, 0, deadline, nil))
+}
+
+// StoreWithMaxReads is like Store, but trx is removed after maxReads
+// successful lookups through Check, Lookup, CheckCtx or CheckMany, or when
+// its TTL runs out, whichever comes first. Peek, Snapshot and Range do not
+// count as reads. The removal is reported to OnExpire and the expire
+// channel like an expiry. A later store of trx drops the limit, and a non
+// positive maxReads stores trx without one.
+func (p *BytesTrxStore) StoreWithMaxReads(trx uuid.UUID, result []byte, maxReads int) {
+	p.logRejected(trx, result, p.storeWithTTL(context.Background(), trx, result, p.entryTTL(trx), max(maxReads, 0)))
+}
+
+// StoreCtx is like Store, but returns ctx.Err() without storing if ctx is
+// already done, and ErrValueTooLarge instead of logging a rejected value.
+// If ctx has a deadline before the end of the TTL, trx expires at the
+// deadline instead, as with StoreWithDeadline, so the result is kept no
+// longer than the operation that produced it. Retries arriving after the
+// deadline are then not recognized; use StoreE to keep the full TTL. ctx
+// is also passed to the tracer, if one is set.
+func (p *BytesTrxStore) StoreCtx(ctx context.Context, trx uuid.UUID, result []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ttl := p.entryTTL(trx)
+	if deadline, ok := ctx.Deadline(); ok && (ttl == 0 || deadline.Before(p.opts.clock.Now().Add(ttl))) {
+		return p.storeUntil(ctx, trx, result, 0, 0, deadline, nil)
+	}
+
+	return p.storeWithTTL(ctx, trx, result, ttl, 0)
+}
+
+func (p *BytesTrxStore) storeWithTTL(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration, maxReads int) error {
+	return p.storeUntil(ctx, trx, result, ttl, maxReads, time.Time{}, nil)
+}
+
+// storeUntil stores result with ttl, or with the fixed deadline until if
+// it is not zero. leader is the flight of the Do storing its result, nil
+// for other stores.
+func (p *BytesTrxStore) storeUntil(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration, maxReads int, until time.Time, leader *flight) error {
+	if p.opts.onSlowOp != nil {
+		defer p.timeOp(opStore, trx, time.Now())
+	}
+	result, err := p.limitSize(result)
+	if err != nil {
+		p.countRejected(err)
+
+		return err
+	}
+	if p.opts.tracer != nil {
+		defer p.opts.tracer.TraceStore(p.traceContext(ctx), trx, len(result))()
+	}
+	stored := p.encode(result)
+
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	if skip, err := p.inFlightStore(s, trx, leader); skip {
+		s.cacheLock.Unlock()
+		p.countRejected(err)
+
+		return err
+	}
+	now := p.opts.clock.Now()
+	old, conflict := p.conflict(s, trx, now, result)
+	var evicted []expiredEntry
+	if until.IsZero() {
+		evicted = s.store(trx, stored, p.createdAt(s, trx, now), now, ttl)
 	} else {
-		for {
-			select {
-			case <-timer.C:
-				p.cleanupExpired()
-			case <-ctx.Done():
-				timer.Stop()
-				return
+		// the time left keeps the monotonic reading of now, which until,
+		// often a wall clock time, may lack
+		left := until.Sub(now)
+		evicted = s.store(trx, stored, now, now, max(left, time.Nanosecond))
+		if left <= 0 {
+			// a deadline passed since the caller checked it expires right away
+			s.setDeadline(trx, until)
+		}
+	}
+	s.cacheMeta[trx].reads = maxReads
+	write, mirror := p.mirrorEntry(s, trx, result, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	if conflict {
+		p.opts.onConflict(trx, old, result)
+	}
+	p.notify(evicted)
+	if mirror {
+		return p.mirror(ctx, write)
+	}
+
+	return nil
+}
+
+// limitSize applies the WithMaxValueSize limit to value.
+func (p *BytesTrxStore) limitSize(value []byte) ([]byte, error) {
+	switch {
+	case p.opts.maxValueSize <= 0 || len(value) <= p.opts.maxValueSize:
+		return value, nil
+	case p.opts.truncateValues:
+		return value[:p.opts.maxValueSize], nil
+	default:
+		return nil, ErrValueTooLarge
+	}
+}
+
+// limitLogged is limitSize for methods without an error result, which
+// count and log rejected values instead.
+func (p *BytesTrxStore) limitLogged(trx uuid.UUID, value []byte) ([]byte, bool) {
+	res, err := p.limitSize(value)
+	if err != nil {
+		p.reject(trx, value, err)
+
+		return nil, false
+	}
+
+	return res, true
+}
+
+// reject counts and logs a value a method without an error result did not
+// store.
+func (p *BytesTrxStore) reject(trx uuid.UUID, value []byte, err error) {
+	p.countRejected(err)
+	p.logRejected(trx, value, err)
+}
+
+// logRejected logs values the methods without an error result did not
+// store.
+func (p *BytesTrxStore) logRejected(trx uuid.UUID, value []byte, err error) {
+	switch {
+	case errors.Is(err, ErrWriteThrough):
+		p.opts.logger.Warn("trxstore: write-through failed", "trx", trx, "error", err)
+	case err != nil:
+		p.opts.logger.Warn("trxstore: value not stored", "trx", trx, "size", len(value), "error", err)
+	}
+}
+
+// Swap stores result like Store and returns a copy of the live value it
+// replaced, if there was one. A result over the WithMaxValueSize limit is
+// not stored, and Swap then returns the live value it kept.
+func (p *BytesTrxStore) Swap(trx uuid.UUID, result []byte) (previous []byte, existed bool) {
+	result, ok := p.limitLogged(trx, result)
+	if !ok {
+		return p.Peek(trx)
+	}
+	stored := p.encode(result)
+
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	previous, existed = p.get(s, trx, now)
+	evicted := s.store(trx, stored, p.createdAt(s, trx, now), now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, result, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return previous, existed
+}
+
+// GetOrStore returns the live value of trx, or calls compute and stores
+// its result if there is none. compute runs under the write lock of the
+// shard holding trx, so it is called once per key but must not use the
+// store itself. A result over the WithMaxValueSize limit is returned but
+// not stored.
+func (p *BytesTrxStore) GetOrStore(trx uuid.UUID, compute func() []byte) []byte {
+	res, _ := p.getOrStore(trx, compute)
+
+	return res
+}
+
+// getOrStore is GetOrStore, also reporting whether the result of compute
+// was stored.
+func (p *BytesTrxStore) getOrStore(trx uuid.UUID, compute func() []byte) ([]byte, bool) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+
+	now := p.opts.clock.Now()
+	if res, exists := p.get(s, trx, now); exists {
+		p.accessed(s, trx, now)
+		s.cacheLock.Unlock()
+		p.countLookup(true)
+
+		return res, false
+	}
+
+	computed := compute()
+	res, err := p.limitSize(computed)
+	if err != nil {
+		s.cacheLock.Unlock()
+		p.countLookup(false)
+		p.reject(trx, computed, err)
+
+		return computed, false
+	}
+	evicted := s.store(trx, p.encode(res), now, now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, res, now)
+	s.cacheLock.Unlock()
+	p.countLookup(false)
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return res, true
+}
+
+// StoreIfAbsent stores result only if trx has no live value, and returns
+// the value trx ends up with along with whether it was stored by this call.
+// Of concurrent callers on the same trx exactly one gets wasNew. A result
+// over the WithMaxValueSize limit is not stored, and StoreIfAbsent then
+// returns nil and false if trx has no live value.
+func (p *BytesTrxStore) StoreIfAbsent(trx uuid.UUID, result []byte) (stored []byte, wasNew bool) {
+	var called bool
+	stored, wasNew = p.getOrStore(trx, func() []byte {
+		called = true
+
+		return result
+	})
+	if called && !wasNew {
+		return nil, false
+	}
+
+	return bytes.Clone(stored), wasNew
+}
+
+// StoreIf stores result like Store only if cond, called with the live value
+// of trx if there is one, returns true, and tells whether it did. cond
+// runs under the write lock of the shard holding trx, so it must not use
+// the store itself, and existing must not be kept after it returns. A
+// result over the WithMaxValueSize limit is not stored, without calling
+// cond.
+func (p *BytesTrxStore) StoreIf(trx uuid.UUID, result []byte, cond func(existing []byte, existed bool) bool) bool {
+	result, ok := p.limitLogged(trx, result)
+	if !ok {
+		return false
+	}
+	stored := p.encode(result)
+
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	if !cond(p.get(s, trx, now)) {
+		s.cacheLock.Unlock()
+
+		return false
+	}
+	evicted := s.store(trx, stored, p.createdAt(s, trx, now), now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, result, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return true
+}
+
+// CompareAndSwap stores new for trx only if its live value equals old, as
+// reported by bytes.Equal or the WithEquals comparison, and tells whether
+// it did. A nil old also matches a missing or expired trx, so nil can be
+// swapped for a first value. The swapped entry gets a fresh store-wide
+// TTL, like in Store. A new value over the WithMaxValueSize limit is never
+// swapped in.
+func (p *BytesTrxStore) CompareAndSwap(trx uuid.UUID, old, new []byte) bool {
+	new, ok := p.limitLogged(trx, new)
+	if !ok {
+		return false
+	}
+	stored := p.encode(new)
+
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	res, exists := p.get(s, trx, now)
+	if (!exists && old != nil) || (exists && !p.opts.equals(res, old)) {
+		s.cacheLock.Unlock()
+
+		return false
+	}
+	evicted := s.store(trx, stored, p.createdAt(s, trx, now), now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, new, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return true
+}
+
+// Merge combines value with the live value of trx using merge and stores
+// the result with a fresh store-wide TTL, so concurrent partial results
+// for one trx add up instead of the last one winning. A missing or expired
+// trx stores value as is, without calling merge. merge is called with the
+// shard write lock held, so it must be quick and must not use the store.
+// Merge returns the stored value, which the caller may modify. A merged
+// value over the WithMaxValueSize limit is not stored, and Merge then
+// returns the live value it kept, nil if there is none.
+func (p *BytesTrxStore) Merge(trx uuid.UUID, value []byte, merge func(existing, incoming []byte) []byte) []byte {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	existing, exists := p.get(s, trx, now)
+	merged := value
+	if exists {
+		merged = merge(existing, value)
+	}
+	res, err := p.limitSize(merged)
+	if err != nil {
+		s.cacheLock.Unlock()
+		p.reject(trx, merged, err)
+
+		return existing
+	}
+	evicted := s.store(trx, p.encode(res), p.createdAt(s, trx, now), now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, res, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return res
+}
+
+// TTLRemaining returns how long trx stays live, or the maximum duration for
+// entries that never expire. Entries already past their deadline are
+// reported as missing, like in Lookup.
+func (p *BytesTrxStore) TTLRemaining(trx uuid.UUID) (time.Duration, bool) {
+	s := p.shard(trx)
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
+
+	now := p.opts.clock.Now()
+	if _, exists := s.lookup(trx, now); !exists {
+		return 0, false
+	}
+
+	return s.cacheMeta[trx].deadline.Sub(now), true
+}
+
+// Touch gives a live trx a fresh full TTL, as if it was stored right now.
+// It returns false for missing and expired entries.
+func (p *BytesTrxStore) Touch(trx uuid.UUID) bool {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	now := p.opts.clock.Now()
+	if _, exists := s.lookup(trx, now); !exists {
+		return false
+	}
+	s.refresh(trx, now)
+
+	return true
+}
+
+// Expire makes a live trx expired right away. Unlike Delete, the entry is
+// then removed by the next cleanup like any other expired entry, so
+// WithOnExpire and ExpireChannel report it. Reads miss it immediately. It
+// returns false for missing and already expired entries.
+func (p *BytesTrxStore) Expire(trx uuid.UUID) bool {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	if _, exists := s.lookup(trx, p.opts.clock.Now()); !exists {
+		return false
+	}
+	s.expire(trx)
+
+	return true
+}
+
+func (p *BytesTrxStore) Delete(trx uuid.UUID) bool {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	_, exists := s.remove(trx)
+	s.cacheLock.Unlock()
+
+	return exists
+}
+
+// DeleteOlderThan removes all entries stored more than age ago, whatever
+// their TTL, and returns how many were removed. With sliding expiration an
+// entry counts from its last read. Like Delete, it does not report the
+// removed entries to callbacks. Each shard is locked once.
+func (p *BytesTrxStore) DeleteOlderThan(age time.Duration) int {
+	var removed int
+	cutoff := p.opts.clock.Now().Add(-age)
+	for _, s := range p.shards {
+		s.cacheLock.Lock()
+		for trx, createdAt := range s.createdAt {
+			if createdAt.Before(cutoff) {
+				s.remove(trx)
+				removed++
+			}
+		}
+		s.cacheLock.Unlock()
+	}
+
+	return removed
+}
+
+// Clear removes all entries, leaving the background cleanup running, for
+// example to flush the store between tests. Like Delete, it does not report
+// the removed entries to callbacks. Operations in flight through Do and
+// waiters of WaitFor are kept. Each shard is locked in turn, so entries
+// stored concurrently may survive the call.
+func (p *BytesTrxStore) Clear() {
+	for _, s := range p.shards {
+		s.cacheLock.Lock()
+		s.clear(p.opts.evictionPolicy)
+		s.cacheLock.Unlock()
+	}
+}
+
+// DeleteFunc removes all live entries for which pred returns true and
+// returns how many were removed. Like Delete, it does not report the
+// removed entries to callbacks. Each shard is locked once, and pred is
+// called with the shard write lock held, so it must be quick and must not
+// use the store. value must not be modified or retained after pred
+// returns.
+func (p *BytesTrxStore) DeleteFunc(pred func(trx uuid.UUID, value []byte) bool) int {
+	var removed int
+	var matched []uuid.UUID
+	for _, s := range p.shards {
+		s.cacheLock.Lock()
+		now := p.opts.clock.Now()
+		matched = matched[:0]
+		for trx := range s.cache {
+			if value, exists := p.getRef(s, trx, now); exists && pred(trx, value) {
+				matched = append(matched, trx)
+			}
+		}
+		for _, trx := range matched {
+			s.remove(trx)
+		}
+		s.cacheLock.Unlock()
+		removed += len(matched)
+	}
+
+	return removed
+}
+
+// Transform replaces the value of every live entry with the new value
+// returned by fn, or removes the entry when keep is false, for example to
+// re-encode values restored from a snapshot in an older format. Entries
+// keep their deadlines and read limits, and their versions move as on a
+// store. Tombstones, see WithTombstones, are skipped, and a new value over
+// the WithMaxValueSize limit leaves its entry as is. Removals are not
+// reported to callbacks, and byte limits are only enforced again by later
+// stores. Like DeleteFunc, it locks each shard
+// once and calls fn with the shard write lock held, blocking all access to
+// that shard for about Len()/Shards() calls to fn, so on large stores fn
+// should be cheap and more shards keep each pause shorter. fn must not use
+// the store, and old must not be modified or retained after fn returns.
+func (p *BytesTrxStore) Transform(fn func(trx uuid.UUID, old []byte) (new []byte, keep bool)) {
+	for _, s := range p.shards {
+		var writes []writeThroughEntry
+		s.cacheLock.Lock()
+		now := p.opts.clock.Now()
+		for trx := range s.cache {
+			old, exists := p.getRef(s, trx, now)
+			if !exists || s.tombstoned(trx, now) {
+				continue
+			}
+			if res, keep := fn(trx, old); keep {
+				limited, err := p.limitSize(res)
+				if err != nil {
+					p.reject(trx, res, err)
+					continue
+				}
+				s.replace(trx, p.encode(limited))
+				if write, mirror := p.mirrorEntry(s, trx, limited, now); mirror {
+					writes = append(writes, write)
+				}
+			} else {
+				s.remove(trx)
 			}
 		}
+		s.cacheLock.Unlock()
+		p.mirrorLogged(writes...)
+	}
+}
+
+// Len returns the number of held entries without taking the cache locks.
+func (p *BytesTrxStore) Len() int {
+	var res int64
+	for _, s := range p.shards {
+		res += s.size.Load()
+	}
+
+	return int(res)
+}
+
+// approxLenSample is the number of shards ApproxLen reads.
+const approxLenSample = 4
+
+// ApproxLen estimates Len for dashboards on very hot stores. Each shard
+// counter it reads is a cache line contended by the writers of the shard,
+// so instead of all of them it reads those of approxLenSample shards, from
+// a random one on, and scales their sum by the number of shards. Since the
+// shard hash spreads keys evenly this is close to Len, but it is an
+// estimate: uneven shards skew it, and concurrent stores and removals may
+// or may not be counted. With up to approxLenSample shards it is Len.
+func (p *BytesTrxStore) ApproxLen() int {
+	n := len(p.shards)
+	if n <= approxLenSample {
+		return p.Len()
+	}
+
+	var res int64
+	first := rand.IntN(n)
+	for i := range approxLenSample {
+		res += p.shards[(first+i)%n].size.Load()
+	}
+
+	return int(res * int64(n) / approxLenSample)
+}
+
+// Bytes returns the total size of held values.
+func (p *BytesTrxStore) Bytes() int64 {
+	var res int64
+	for _, s := range p.shards {
+		res += s.bytes.Load()
+	}
+
+	return res
+}
+
+// Name returns the WithName name of the store, "" if it has none.
+func (p *BytesTrxStore) Name() string {
+	return p.opts.name
+}
+
+// TTL returns the store-wide TTL.
+func (p *BytesTrxStore) TTL() time.Duration {
+	return time.Duration(p.ttl.Load())
+}
+
+// entryTTL returns the store-wide TTL spread by WithTTLJitter. The spread
+// is derived from trx, so every store of trx gets the same TTL and
+// WithAnchoredExpiry and updates keep its deadline.
+func (p *BytesTrxStore) entryTTL(trx uuid.UUID) time.Duration {
+	ttl := p.TTL()
+	if p.opts.ttlJitter <= 0 || ttl == 0 {
+		return ttl
+	}
+
+	spread := float64(maphash.Bytes(p.ttlSeed, trx[:])>>11) / (1 << 53)
+
+	return max(time.Duration(float64(ttl)*(1+p.opts.ttlJitter*(2*spread-1))), time.Nanosecond)
+}
+
+// SetTTL changes the store-wide TTL for entries stored from now on.
+// Entries already held keep the deadline they were stored with. Like at
+// construction, a negative ttl is rejected with ErrInvalidTTL and a zero
+// ttl means new entries never expire.
+func (p *BytesTrxStore) SetTTL(ttl time.Duration) error {
+	if err := validateTTL(ttl); err != nil {
+		return err
+	}
+
+	p.ttl.Store(int64(ttl))
+	if ttl > 0 {
+		p.startJanitor()
+	}
+
+	return nil
+}
+
+// CleanupInterval returns the interval set by WithCleanupInterval, before
+// any WithCleanupJitter is applied. Stores of a StoreGroup share the
+// cleanup of the group, which runs at the same interval.
+func (p *BytesTrxStore) CleanupInterval() time.Duration {
+	return p.opts.cleanupInterval
+}
+
+// MaxEntries returns the WithMaxEntries cap, zero if there is none.
+func (p *BytesTrxStore) MaxEntries() int {
+	return p.opts.maxEntries
+}
+
+// MaxBytes returns the WithMaxBytes cap, zero if there is none.
+func (p *BytesTrxStore) MaxBytes() int64 {
+	return p.opts.maxBytes
+}
+
+// Shards returns the number of shards the entries are spread over.
+func (p *BytesTrxStore) Shards() int {
+	return len(p.shards)
+}
+
+// Usage returns Len and Bytes as a consistent pair. It read-locks all
+// shards at once, so unlike separate calls it observes no write between
+// the two numbers, at the cost of briefly blocking writers.
+func (p *BytesTrxStore) Usage() (entries int, bytes int64) {
+	for _, s := range p.shards {
+		s.cacheLock.RLock()
+	}
+	for _, s := range p.shards {
+		entries += int(s.size.Load())
+		bytes += s.bytes.Load()
+	}
+	for _, s := range p.shards {
+		s.cacheLock.RUnlock()
+	}
+
+	return entries, bytes
+}
+
+// ApproxMemoryBytes estimates the memory held by the store: the size of
+// held values plus a fixed per-entry overhead for keys, map slots, expiry
+// and eviction bookkeeping. It is meant for capacity planning, for example
+// to pick a WithMaxBytes limit, and ignores allocator and map growth slack.
+func (p *BytesTrxStore) ApproxMemoryBytes() int64 {
+	return p.Bytes() + int64(p.Len())*approxEntryOverhead
+}
+
+// Close stops the background cleanup and waits for it to exit, closes
+// ExpireChannel and ExpiryWarnings and waits for queued write-through
+// stores. It is safe to call more than once, but not from WithOnExpire or
+// WithOnEvict callbacks run by the cleanup, since it would wait for
+// itself. The store stays usable after Close, but expired entries are no
+// longer removed.
+func (p *BytesTrxStore) Close() {
+	p.janitor.close()
+	if p.mirrored != nil {
+		p.mirrored.close()
+	}
+	if p.events != nil {
+		p.events.close()
+	}
+	if p.warnings != nil {
+		p.warnings.close()
+	}
+}
+
+func (p *BytesTrxStore) startJanitor() {
+	if p.opts.sharedCleanup || p.opts.noCleanup {
+		return
+	}
+
+	interval := jitter(p.opts.cleanupInterval, p.opts.cleanupJitter)
+	p.janitor.start(p.ctx, p.opts.clock, interval, p.cleanupExpired)
+}
+
+func (p *BytesTrxStore) shard(trx uuid.UUID) *shard {
+	return p.shards[p.shardIndex(trx)]
+}
+
+func (p *BytesTrxStore) shardIndex(trx uuid.UUID) int {
+	return int(p.opts.shardHasher(trx) % uint64(len(p.shards)))
+}
+
+// exclusiveReads reports whether reads modify entry state and so need the
+// write lock.
+func (p *BytesTrxStore) exclusiveReads() bool {
+	return p.opts.slidingExpiration || p.opts.maxEntries > 0 || p.opts.maxBytes > 0
+}
+
+// accessed records a read of a live entry. It must be called with the
+// shard write lock held whenever exclusiveReads is true.
+func (p *BytesTrxStore) accessed(s *shard, trx uuid.UUID, now time.Time) {
+	if p.opts.slidingExpiration {
+		s.refresh(trx, now)
+	}
+	if p.opts.maxEntries > 0 || p.opts.maxBytes > 0 {
+		s.touchRecency(trx)
+	}
+}
+
+// update replaces the value of trx with the result of fn, called with the
+// live value under the shard write lock. A live entry keeps its creation
+// time, so its deadline does not move. A result over the WithMaxValueSize
+// limit is not stored.
+func (p *BytesTrxStore) update(trx uuid.UUID, fn func(existing []byte, existed bool) []byte) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	existing, existed := p.get(s, trx, now)
+	createdAt := now
+	if existed {
+		createdAt = s.createdAt[trx]
+	}
+	updated := fn(existing, existed)
+	res, err := p.limitSize(updated)
+	if err != nil {
+		s.cacheLock.Unlock()
+		p.reject(trx, updated, err)
+
+		return
+	}
+	evicted := s.store(trx, p.encode(res), createdAt, now, p.entryTTL(trx))
+	write, mirror := p.mirrorEntry(s, trx, res, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+}
+
+// conflict returns the live value of trx if storing result over it should
+// be reported to the conflict callback. It must be called with the shard
+// lock held.
+func (p *BytesTrxStore) conflict(s *shard, trx uuid.UUID, now time.Time, result []byte) ([]byte, bool) {
+	if p.opts.onConflict == nil {
+		return nil, false
+	}
+	old, exists := p.get(s, trx, now)
+
+	return old, exists && !s.tombstoned(trx, now) && !p.opts.equals(old, result)
+}
+
+// createdAt returns the time a store of trx at now counts from. It must be
+// called with the shard lock held.
+func (p *BytesTrxStore) createdAt(s *shard, trx uuid.UUID, now time.Time) time.Time {
+	if p.opts.anchoredExpiry {
+		if _, exists := s.lookup(trx, now); exists {
+			return s.createdAt[trx]
+		}
 	}
+
+	return now
+}
+
+func (p *BytesTrxStore) notify(entries []expiredEntry) {
+	debug := len(entries) > 0 && p.opts.logger.Enabled(context.Background(), slog.LevelDebug)
+	var batch []ExpireEvent
+	for _, entry := range entries {
+		if debug && !entry.reason.expiry() {
+			p.opts.logger.Debug("trxstore: entry evicted", "trx", entry.trx, "reason", entry.reason)
+		}
+		if entry.reason.expiry() {
+			p.stats.expirations.Add(1)
+		} else {
+			p.stats.evictions.Add(1)
+		}
+		p.stats.byReason[entry.reason].Add(1)
+		if len(p.codecs) > 0 && !entry.tombstone && (p.opts.onExpire != nil || p.opts.onEvict != nil || p.opts.onRemove != nil || p.opts.onExpireBatch != nil || p.events != nil) {
+			entry.value, _ = p.decode(entry.value)
+		}
+
+		switch {
+		case entry.reason.expiry() && p.opts.onExpire != nil:
+			p.opts.onExpire(entry.trx, entry.value)
+		case !entry.reason.expiry() && p.opts.onEvict != nil:
+			p.opts.onEvict(entry.trx, entry.value)
+		}
+		if p.opts.onRemove != nil {
+			p.opts.onRemove(entry.trx, entry.value, entry.reason)
+		}
+		if p.events != nil {
+			p.events.send(ExpireEvent{Trx: entry.trx, Value: entry.value, Evicted: !entry.reason.expiry(), Reason: entry.reason})
+		}
+		if p.opts.onExpireBatch != nil && entry.reason.expiry() {
+			batch = append(batch, ExpireEvent{Trx: entry.trx, Value: entry.value, Reason: entry.reason})
+		}
+	}
+	if len(batch) > 0 {
+		p.opts.onExpireBatch(batch)
+	}
+}
+
+// ForceCleanup removes expired entries right away instead of waiting for
+// the background cleanup, and returns how many were removed. It is safe to
+// call concurrently with the background cleanup.
+func (p *BytesTrxStore) ForceCleanup() int {
+	// the duration is a cost, so it is measured on the wall clock
+	start := time.Now()
+	var expired []expiredEntry
+	now := p.opts.clock.Now()
+	for _, s := range p.shards {
+		p.checkShard(s)
+		expired = p.cleanupShard(s, now, expired)
+		if s.valueTTL > 0 {
+			s.cacheLock.Lock()
+			s.dropValues(now)
+			s.cacheLock.Unlock()
+		}
+		if p.opts.bloomFilterSize > 0 {
+			s.cacheLock.Lock()
+			s.rebuildBloom()
+			s.cacheLock.Unlock()
+		}
+		if p.warnings != nil {
+			p.warnExpiring(s, now)
+		}
+	}
+
+	if len(expired) > 0 {
+		p.opts.logger.Debug("trxstore: cleanup removed expired entries", "count", len(expired))
+	}
+	p.notify(expired)
+	p.lastSweep.Store(p.opts.clock.Now().UnixNano())
+	p.countSweep(time.Since(start), len(expired))
+
+	return len(expired)
+}
+
+// Tick runs one sweep of the background cleanup, for stores created with
+// WithoutBackgroundCleanup whose cleanup is driven by an external
+// scheduler. Like the background cleanup it logs a panic of a callback
+// instead of passing it on. It is safe to call from any goroutine; a Tick
+// made while another sweep is still going returns right away, as that
+// sweep already removes what is due.
+func (p *BytesTrxStore) Tick() {
+	p.janitor.sweep(p.cleanupExpired)
 }
 
 func (p *BytesTrxStore) cleanupExpired() {
-	var entriesForRemove = map[uuid.UUID]struct{}{}
-	p.cacheLock.RLock()
-	for id, createdAt := range p.cacheExpire {
-		if time.Since(createdAt) > p.ttl {
-			entriesForRemove[id] = struct{}{}
+	if !p.ticking.CompareAndSwap(false, true) {
+		return
+	}
+	defer p.ticking.Store(false)
+
+	p.ForceCleanup()
+}
+
+func (p *BytesTrxStore) cleanupShard(s *shard, now time.Time, expired []expiredEntry) []expiredEntry {
+	for {
+		var more bool
+		expired, more = p.cleanupBatch(s, now, expired)
+		if !more {
+			return expired
 		}
 	}
-	p.cacheLock.RUnlock()
+}
 
-	if len(entriesForRemove) > 0 {
-		p.cacheLock.Lock()
-		for entryId := range entriesForRemove {
-			delete(p.cache, entryId)
-			delete(p.cacheExpire, entryId)
+// cleanupBatch removes due entries of s under one lock acquisition, at
+// most the configured batch size of them, and reports whether more may be
+// due. Due entries are found and removed under the same lock, so an entry
+// stored or touched between batches is checked against its new deadline.
+func (p *BytesTrxStore) cleanupBatch(s *shard, now time.Time, expired []expiredEntry) ([]expiredEntry, bool) {
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	for removed := 0; p.opts.cleanupBatchSize <= 0 || removed < p.opts.cleanupBatchSize; removed++ {
+		entry, exists := s.popExpired(now)
+		if !exists {
+			return expired, false
 		}
-		p.cacheLock.Unlock()
+		expired = append(expired, entry)
+	}
+
+	return expired, true
+}
+
+func validateTTL(ttl time.Duration) error {
+	if ttl < 0 {
+		return ErrInvalidTTL
 	}
+
+	return nil
+}
+
+func shardLimit(limit int64, shards int) int64 {
+	if limit <= 0 {
+		return 0
+	}
+
+	return (limit + int64(shards) - 1) / int64(shards)
 }
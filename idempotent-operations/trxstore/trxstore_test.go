@@ -2,7 +2,15 @@ package trxstore
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -28,3 +36,836 @@ func TestNewTRXStore(t *testing.T) {
 		t.Fatalf("trx not expired")
 	}
 }
+
+func TestNew(t *testing.T) {
+	store := New()
+	if store.TTL() != defaultTTL {
+		t.Fatalf("expected default ttl, got %v", store.TTL())
+	}
+	store.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	store = New(WithTTL(time.Minute), WithContext(ctx), WithShards(2))
+	defer store.Close()
+	if store.TTL() != time.Minute || len(store.shards) != 2 {
+		t.Fatalf("options not applied")
+	}
+	cancel()
+	select {
+	case <-store.janitor.done:
+	case <-time.After(time.Second):
+		t.Fatalf("cleanup not stopped by context")
+	}
+
+	if _, err := NewE(WithTTL(-time.Second)); !errors.Is(err, ErrInvalidTTL) {
+		t.Fatalf("expected ErrInvalidTTL, got %v", err)
+	}
+	positional := NewBytesTRXStore(time.Second, WithTTL(time.Hour))
+	defer positional.Close()
+	if positional.TTL() != time.Second {
+		t.Fatalf("positional ttl overridden by option")
+	}
+}
+
+func TestFork(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	var expired atomic.Int32
+	parent := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(4), WithMaxEntries(8), WithOnExpire(func(uuid.UUID, []byte) {
+		expired.Add(1)
+	}))
+	defer parent.Close()
+	if err := parent.SetTTL(time.Hour); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	parent.Store(trx, []byte("parent"))
+
+	fork := parent.Fork()
+	defer fork.Close()
+	if fork.Len() != 0 {
+		t.Fatalf("fork inherited %d entries", fork.Len())
+	}
+	if fork.TTL() != time.Hour || fork.Shards() != 4 || fork.MaxEntries() != 8 {
+		t.Fatalf("configuration not inherited: ttl %v, %d shards, %d entries", fork.TTL(), fork.Shards(), fork.MaxEntries())
+	}
+
+	fork.Store(trx, []byte("fork"))
+	if !bytes.Equal(parent.Check(trx), []byte("parent")) || !bytes.Equal(fork.Check(trx), []byte("fork")) {
+		t.Fatalf("stores not isolated")
+	}
+	fork.Delete(trx)
+	if _, exists := parent.Lookup(trx); !exists {
+		t.Fatalf("delete in the fork reached the parent")
+	}
+
+	fork.Store(trx, nil)
+	parent.Close()
+	clock.Advance(2 * time.Hour)
+	waitFor(t, func() bool { return fork.Len() == 0 })
+	if expired.Load() != 1 {
+		t.Fatalf("expected the fork cleanup to report 1 expiry, got %d", expired.Load())
+	}
+}
+
+func TestLookupEmptyValue(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("new trx already exists")
+	}
+
+	store.Store(trx, []byte{})
+	res, exists := store.Lookup(trx)
+	if !exists {
+		t.Fatalf("empty trx not exists")
+	}
+	if res == nil || len(res) != 0 {
+		t.Fatalf("empty value not retained: %v", res)
+	}
+}
+
+func TestGet(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	if _, err := store.Get(trx); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	store.Store(trx, []byte{})
+	if res, err := store.Get(trx); err != nil || res == nil || len(res) != 0 {
+		t.Fatalf("empty value not returned: %v, %v", res, err)
+	}
+}
+
+func TestDelete(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	if store.Delete(trx) {
+		t.Fatalf("deleted not existing trx")
+	}
+
+	store.Store(trx, []byte("test"))
+	if !store.Delete(trx) {
+		t.Fatalf("trx not deleted")
+	}
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("trx exists after delete")
+	}
+}
+
+func TestLen(t *testing.T) {
+	store := NewBytesTRXStore(10 * time.Millisecond)
+	if store.Len() != 0 {
+		t.Fatalf("new store is not empty")
+	}
+
+	first, second := uuid.New(), uuid.New()
+	store.Store(first, []byte("first"))
+	store.Store(first, []byte("first"))
+	store.Store(second, []byte("second"))
+	if store.Len() != 2 {
+		t.Fatalf("expected 2 entries, got %d", store.Len())
+	}
+
+	store.Delete(first)
+	if store.Len() != 1 {
+		t.Fatalf("expected 1 entry after delete, got %d", store.Len())
+	}
+
+	time.Sleep(1 * time.Second)
+	if store.Len() != 0 {
+		t.Fatalf("expected 0 entries after expire, got %d", store.Len())
+	}
+}
+
+func TestStoreCopiesValue(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	input := []byte("test")
+	store.Store(trx, input)
+	input[0] = 'x'
+
+	output := store.Check(trx)
+	output[1] = 'x'
+
+	if res := store.Check(trx); !bytes.Equal(res, []byte("test")) {
+		t.Fatalf("stored value mutated: %q", res)
+	}
+}
+
+func TestClose(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(10 * time.Millisecond)
+	store.Close()
+	store.Close()
+
+	store.Store(trx, []byte("test"))
+	time.Sleep(3 * sleepBetweenExpireCheck)
+	if store.Len() != 1 {
+		t.Fatalf("trx cleaned up after close")
+	}
+}
+
+func TestLookupExpiredBeforeCleanup(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(10 * time.Millisecond)
+	store.Close()
+
+	store.Store(trx, []byte("test"))
+	time.Sleep(20 * time.Millisecond)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("expired trx returned")
+	}
+	if store.Check(trx) != nil {
+		t.Fatalf("expired trx returned")
+	}
+}
+
+func TestStoreWithTTL(t *testing.T) {
+	short, long, fallback := uuid.New(), uuid.New(), uuid.New()
+	store := NewBytesTRXStore(200 * time.Millisecond)
+	store.StoreWithTTL(short, []byte("short"), 10*time.Millisecond)
+	store.StoreWithTTL(long, []byte("long"), time.Minute)
+	store.Store(fallback, []byte("fallback"))
+
+	time.Sleep(50 * time.Millisecond)
+	if store.Check(short) != nil {
+		t.Fatalf("short trx not expired")
+	}
+	if store.Check(fallback) == nil {
+		t.Fatalf("fallback trx expired too early")
+	}
+
+	time.Sleep(1 * time.Second)
+	if store.Check(fallback) != nil {
+		t.Fatalf("fallback trx not expired")
+	}
+	if store.Check(long) == nil {
+		t.Fatalf("long trx expired")
+	}
+	if store.Len() != 1 {
+		t.Fatalf("expected 1 entry after cleanup, got %d", store.Len())
+	}
+}
+
+func TestSlidingExpiration(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(300*time.Millisecond, WithSlidingExpiration(true))
+	store.Store(trx, []byte("test"))
+
+	for i := 0; i < 5; i++ {
+		time.Sleep(150 * time.Millisecond)
+		if store.Check(trx) == nil {
+			t.Fatalf("accessed trx expired")
+		}
+	}
+
+	time.Sleep(500 * time.Millisecond)
+	if store.Check(trx) != nil {
+		t.Fatalf("idle trx not expired")
+	}
+}
+
+func TestGetOrStoreComputesOnce(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	var calls atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := store.GetOrStore(trx, func() []byte {
+				calls.Add(1)
+				time.Sleep(time.Millisecond)
+				return []byte("test")
+			})
+			if !bytes.Equal(res, []byte("test")) {
+				t.Errorf("unexpected result %q", res)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Fatalf("compute called %d times", calls.Load())
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	if store.CompareAndSwap(trx, []byte("test"), []byte("new")) {
+		t.Fatalf("swapped missing trx with non-nil old")
+	}
+	if !store.CompareAndSwap(trx, nil, []byte("first")) {
+		t.Fatalf("nil old not swapped for missing trx")
+	}
+	if store.CompareAndSwap(trx, []byte("stale"), []byte("second")) {
+		t.Fatalf("swapped on mismatch")
+	}
+	if !store.CompareAndSwap(trx, []byte("first"), []byte("second")) {
+		t.Fatalf("not swapped on match")
+	}
+	if res := store.Check(trx); !bytes.Equal(res, []byte("second")) {
+		t.Fatalf("unexpected value %q", res)
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	store.Store(trx, []byte("old"))
+
+	var swapped atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if store.CompareAndSwap(trx, []byte("old"), []byte{byte(i)}) {
+				swapped.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if swapped.Load() != 1 {
+		t.Fatalf("expected exactly one swap, got %d", swapped.Load())
+	}
+}
+
+func TestStoreIfAbsentConcurrent(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	var winners atomic.Int32
+	var winner atomic.Int32
+	results := make([][]byte, 100)
+	var wg sync.WaitGroup
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stored, wasNew := store.StoreIfAbsent(trx, []byte{byte(i)})
+			if wasNew {
+				winners.Add(1)
+				winner.Store(int32(i))
+			}
+			results[i] = stored
+		}(i)
+	}
+	wg.Wait()
+
+	if winners.Load() != 1 {
+		t.Fatalf("expected exactly one new store, got %d", winners.Load())
+	}
+	for i, res := range results {
+		if !bytes.Equal(res, []byte{byte(winner.Load())}) {
+			t.Fatalf("caller %d got %v, expected winner %d", i, res, winner.Load())
+		}
+	}
+}
+
+func TestInvalidTTL(t *testing.T) {
+	for _, ttl := range []time.Duration{-time.Nanosecond, -time.Second} {
+		if _, err := NewBytesTRXStoreE(ttl); !errors.Is(err, ErrInvalidTTL) {
+			t.Fatalf("expected ErrInvalidTTL for %v, got %v", ttl, err)
+		}
+
+		func() {
+			defer func() {
+				if recover() != ErrInvalidTTL {
+					t.Fatalf("expected ErrInvalidTTL panic for %v", ttl)
+				}
+			}()
+			NewBytesTRXStore(ttl)
+		}()
+	}
+
+	store, err := NewBytesTRXStoreE(0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	store.Close()
+}
+
+func TestZeroTTLNeverExpires(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(0, WithClock(clock))
+	defer store.Close()
+
+	store.Store(trx, []byte("test"))
+	clock.Advance(100 * 365 * 24 * time.Hour)
+	store.ForceCleanup()
+
+	if !bytes.Equal(store.Check(trx), []byte("test")) || store.Len() != 1 {
+		t.Fatalf("entry with zero ttl expired")
+	}
+	if !store.Delete(trx) || store.Len() != 0 {
+		t.Fatalf("entry with zero ttl not deleted")
+	}
+}
+
+func TestSwap(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+
+	if previous, existed := store.Swap(trx, []byte("first")); existed || previous != nil {
+		t.Fatalf("unexpected previous value %q for missing trx", previous)
+	}
+	previous, existed := store.Swap(trx, []byte("second"))
+	if !existed || !bytes.Equal(previous, []byte("first")) {
+		t.Fatalf("expected previous value first, got %q", previous)
+	}
+	if !bytes.Equal(store.Check(trx), []byte("second")) {
+		t.Fatalf("value not swapped")
+	}
+}
+
+func TestStoreIf(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	newer := func(version byte) func([]byte, bool) bool {
+		return func(existing []byte, existed bool) bool {
+			return !existed || existing[0] < version
+		}
+	}
+
+	if !store.StoreIf(trx, []byte{2}, newer(2)) {
+		t.Fatalf("first version rejected")
+	}
+	if store.StoreIf(trx, []byte{1}, newer(1)) {
+		t.Fatalf("older version accepted")
+	}
+	if !store.StoreIf(trx, []byte{3}, newer(3)) {
+		t.Fatalf("newer version rejected")
+	}
+	if res := store.Check(trx); !bytes.Equal(res, []byte{3}) {
+		t.Fatalf("unexpected value %v", res)
+	}
+}
+
+func TestStoreWithMaxReads(t *testing.T) {
+	trx := uuid.New()
+	var expired []uuid.UUID
+	store := NewBytesTRXStore(time.Minute, WithOnExpire(func(trx uuid.UUID, _ []byte) {
+		expired = append(expired, trx)
+	}))
+	defer store.Close()
+
+	store.StoreWithMaxReads(trx, []byte("token"), 3)
+	for i := 0; i < 3; i++ {
+		if !bytes.Equal(store.Check(trx), []byte("token")) {
+			t.Fatalf("read %d failed", i+1)
+		}
+	}
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("entry outlived its read limit")
+	}
+	if len(expired) != 1 || expired[0] != trx || store.Len() != 0 {
+		t.Fatalf("expected one expiry of trx, got %v with %d left", expired, store.Len())
+	}
+
+	store.StoreWithMaxReads(trx, []byte("token"), 2)
+	if _, exists := store.Peek(trx); !exists {
+		t.Fatalf("peek failed")
+	}
+	if values := store.CheckMany([]uuid.UUID{trx}); len(values) != 1 {
+		t.Fatalf("batch read failed")
+	}
+	store.Store(trx, []byte("plain"))
+	for i := 0; i < 3; i++ {
+		if store.Check(trx) == nil {
+			t.Fatalf("store did not drop the read limit")
+		}
+	}
+}
+
+func TestStoreWithMaxReadsExpires(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.StoreWithMaxReads(trx, []byte("token"), 5)
+	store.Check(trx)
+	clock.Advance(2 * time.Minute)
+	if _, exists := store.Lookup(trx); exists {
+		t.Fatalf("entry with reads left outlived its ttl")
+	}
+}
+
+func TestStoreWithMaxReadsConcurrent(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+	store.StoreWithMaxReads(trx, []byte("token"), 10)
+
+	var wg sync.WaitGroup
+	var hits atomic.Int64
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, exists := store.Lookup(trx); exists {
+				hits.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if hits.Load() != 10 {
+		t.Fatalf("expected exactly 10 reads, got %d", hits.Load())
+	}
+}
+
+func TestMerge(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	sum := func(existing, incoming []byte) []byte {
+		return binary.BigEndian.AppendUint64(nil, binary.BigEndian.Uint64(existing)+binary.BigEndian.Uint64(incoming))
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Merge(trx, binary.BigEndian.AppendUint64(nil, uint64(i)), sum)
+		}()
+	}
+	wg.Wait()
+
+	if total := binary.BigEndian.Uint64(store.Check(trx)); total != 5050 {
+		t.Fatalf("expected merged total 5050, got %d", total)
+	}
+	if res := store.Merge(trx, binary.BigEndian.AppendUint64(nil, 50), sum); binary.BigEndian.Uint64(res) != 5100 {
+		t.Fatalf("unexpected merge result %d", binary.BigEndian.Uint64(res))
+	}
+}
+
+func TestCleanupKeepsEntriesRestoredBetweenBatches(t *testing.T) {
+	first, second := uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithShards(1), WithCleanupBatchSize(1))
+	defer store.Close()
+
+	store.Store(first, []byte("old"))
+	store.Store(second, []byte("old"))
+	clock.Advance(2 * time.Minute)
+	now := clock.Now()
+
+	s := store.shards[0]
+	expired, more := store.cleanupBatch(s, now, nil)
+	if len(expired) != 1 || !more {
+		t.Fatalf("expected one removal with more due, got %d and %t", len(expired), more)
+	}
+	survivor := second
+	if expired[0].trx == second {
+		survivor = first
+	}
+	store.Store(survivor, []byte("new"))
+
+	if expired, more = store.cleanupBatch(s, now, expired); len(expired) != 1 || more {
+		t.Fatalf("re-stored trx removed by the sweep")
+	}
+	if !bytes.Equal(store.Check(survivor), []byte("new")) {
+		t.Fatalf("re-stored trx missing")
+	}
+}
+
+func TestCheckRef(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	store.Store(trx, []byte("first"))
+	first, exists := store.CheckRef(trx)
+	if !exists || !bytes.Equal(first, []byte("first")) {
+		t.Fatalf("unexpected ref %q", first)
+	}
+	if again, _ := store.CheckRef(trx); &again[0] != &first[0] {
+		t.Fatalf("ref is a copy")
+	}
+
+	store.Store(trx, []byte("other"))
+	if !bytes.Equal(first, []byte("first")) {
+		t.Fatalf("store modified a returned ref")
+	}
+	if _, exists := store.CheckRef(uuid.New()); exists {
+		t.Fatalf("missing trx reported")
+	}
+}
+
+func BenchmarkCheck(b *testing.B) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+	store.Store(trx, make([]byte, 1024))
+
+	b.Run("Copy", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			store.Lookup(trx)
+		}
+	})
+	b.Run("Ref", func(b *testing.B) {
+		b.ReportAllocs()
+		for b.Loop() {
+			store.CheckRef(trx)
+		}
+	})
+}
+
+func TestConflictDetection(t *testing.T) {
+	trx, other := uuid.New(), uuid.New()
+	var conflicts []string
+	store := NewBytesTRXStore(time.Minute, WithConflictDetection(func(conflict uuid.UUID, old, new []byte) {
+		if conflict != trx {
+			t.Fatalf("conflict reported for %s", conflict)
+		}
+		conflicts = append(conflicts, string(old)+"->"+string(new))
+	}))
+	defer store.Close()
+
+	store.Store(trx, []byte("first"))
+	store.Store(trx, []byte("first"))
+	store.Store(other, []byte("other"))
+	if len(conflicts) != 0 {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+
+	store.Store(trx, []byte("second"))
+	store.StoreMany(map[uuid.UUID][]byte{trx: []byte("third"), other: []byte("other")})
+	if len(conflicts) != 2 || conflicts[0] != "first->second" || conflicts[1] != "second->third" {
+		t.Fatalf("unexpected conflicts %v", conflicts)
+	}
+	if !bytes.Equal(store.Check(trx), []byte("third")) {
+		t.Fatalf("conflicting store not applied")
+	}
+}
+
+// jsonEquals compares JSON documents regardless of key order and spacing.
+func jsonEquals(a, b []byte) bool {
+	var first, second any
+	if json.Unmarshal(a, &first) != nil || json.Unmarshal(b, &second) != nil {
+		return bytes.Equal(a, b)
+	}
+
+	return reflect.DeepEqual(first, second)
+}
+
+func TestWithEquals(t *testing.T) {
+	trx := uuid.New()
+	var conflicts int
+	store := NewBytesTRXStore(time.Minute, WithEquals(jsonEquals),
+		WithConflictDetection(func(uuid.UUID, []byte, []byte) { conflicts++ }))
+	defer store.Close()
+
+	store.Store(trx, []byte(`{"amount":100,"currency":"USD"}`))
+	store.Store(trx, []byte(`{"currency": "USD", "amount": 100}`))
+	if conflicts != 0 {
+		t.Fatalf("equivalent encodings reported as a conflict")
+	}
+	store.Store(trx, []byte(`{"amount":200,"currency":"USD"}`))
+	if conflicts != 1 {
+		t.Fatalf("got %d conflicts, want 1", conflicts)
+	}
+
+	if !store.CompareAndSwap(trx, []byte(`{"currency":"USD","amount":200}`), []byte(`{"amount":300}`)) {
+		t.Fatalf("swap of an equivalent encoding failed")
+	}
+	if store.CompareAndSwap(trx, []byte(`{"amount":200}`), []byte(`{"amount":400}`)) {
+		t.Fatalf("swap of a different value succeeded")
+	}
+	if store.CompareAndSwap(uuid.New(), []byte(`{}`), nil) {
+		t.Fatalf("swap of a missing trx succeeded")
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	for i := 0; i < 20; i++ {
+		tenant := "a"
+		if i%2 == 1 {
+			tenant = "b"
+		}
+		store.Store(uuid.New(), []byte(tenant+":value"))
+	}
+	store.StoreWithTTL(uuid.New(), []byte("a:expired"), time.Second)
+	clock.Advance(2 * time.Second)
+
+	removed := store.DeleteFunc(func(_ uuid.UUID, value []byte) bool {
+		return bytes.HasPrefix(value, []byte("a:"))
+	})
+	if removed != 10 || store.Len() != 11 {
+		t.Fatalf("expected 10 removed and 11 left, got %d and %d", removed, store.Len())
+	}
+	store.Range(func(_ uuid.UUID, value []byte, _ time.Time) bool {
+		if !bytes.HasPrefix(value, []byte("b:")) {
+			t.Fatalf("matching entry %q left", value)
+		}
+		return true
+	})
+}
+
+func TestTransform(t *testing.T) {
+	short, long, dropped, expired := uuid.New(), uuid.New(), uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	store.StoreWithTTL(short, []byte("v1:short"), 10*time.Second)
+	store.Store(long, []byte("v1:long"))
+	store.Store(dropped, []byte("v0:dropped"))
+	store.StoreWithTTL(expired, []byte("v1:expired"), time.Second)
+	clock.Advance(2 * time.Second)
+	_, version, _ := store.CheckVersioned(long)
+
+	store.Transform(func(trx uuid.UUID, old []byte) ([]byte, bool) {
+		if trx == expired {
+			t.Fatalf("expired entry transformed")
+		}
+		res, found := bytes.CutPrefix(old, []byte("v1:"))
+
+		return append([]byte("v2:"), res...), found
+	})
+
+	if res := store.Check(short); !bytes.Equal(res, []byte("v2:short")) {
+		t.Fatalf("got %q, want re-encoded value", res)
+	}
+	if _, exists := store.Lookup(dropped); exists {
+		t.Fatalf("entry not kept is still stored")
+	}
+	if _, v, _ := store.CheckVersioned(long); v != version+1 {
+		t.Fatalf("got version %d, want %d", v, version+1)
+	}
+	if store.Bytes() != int64(len("v2:short")+len("v2:long")+len("v1:expired")) {
+		t.Fatalf("bytes not updated, got %d", store.Bytes())
+	}
+
+	clock.Advance(8 * time.Second)
+	if _, exists := store.Lookup(short); !exists {
+		t.Fatalf("transformed entry expired early")
+	}
+	clock.Advance(time.Nanosecond)
+	if _, exists := store.Lookup(short); exists {
+		t.Fatalf("transformed entry outlived its deadline")
+	}
+	if _, exists := store.Lookup(long); !exists {
+		t.Fatalf("transformed entry expired with the wrong ttl")
+	}
+}
+
+func TestMaxValueSize(t *testing.T) {
+	small, large := uuid.New(), uuid.New()
+	store := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, false))
+	defer store.Close()
+
+	store.Store(small, []byte("test"))
+	store.Store(large, []byte("too large"))
+	if _, exists := store.Lookup(large); exists || store.Check(small) == nil {
+		t.Fatalf("limit not applied")
+	}
+	if err := store.StoreCtx(context.Background(), large, []byte("too large")); !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected ErrValueTooLarge, got %v", err)
+	}
+	store.StoreMany(map[uuid.UUID][]byte{large: []byte("too large")})
+	if _, exists := store.Lookup(large); exists {
+		t.Fatalf("batch store not limited")
+	}
+
+	res, err := store.Do(large, func() ([]byte, error) { return []byte("too large"), nil })
+	if err != nil || !bytes.Equal(res, []byte("too large")) {
+		t.Fatalf("unexpected do result %q, %v", res, err)
+	}
+	if _, exists := store.Lookup(large); exists || len(store.shard(large).inFlight) != 0 {
+		t.Fatalf("rejected do result stored or left in flight")
+	}
+
+	truncating := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, true))
+	defer truncating.Close()
+	truncating.Store(large, []byte("too large"))
+	if !bytes.Equal(truncating.Check(large), []byte("too ")) {
+		t.Fatalf("value not truncated: %q", truncating.Check(large))
+	}
+}
+
+func TestMaxValueSizeOtherWrites(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute, WithMaxValueSize(4, false))
+	defer store.Close()
+
+	large := []byte("too large")
+	store.Store(trx, []byte("test"))
+	if previous, _ := store.Swap(trx, large); !bytes.Equal(previous, []byte("test")) {
+		t.Fatalf("unexpected swap result %q", previous)
+	}
+	if store.StoreIf(trx, large, func([]byte, bool) bool { return true }) ||
+		store.CompareAndSwap(trx, []byte("test"), large) {
+		t.Fatalf("conditional store of a large value reported success")
+	}
+	if _, ok := store.StoreVersioned(trx, large, 1); ok {
+		t.Fatalf("versioned store of a large value reported success")
+	}
+	if res := store.Merge(trx, []byte("!"), func(existing, incoming []byte) []byte { return append(existing, incoming...) }); !bytes.Equal(res, []byte("test")) {
+		t.Fatalf("unexpected merge result %q", res)
+	}
+	fresh := uuid.New()
+	if res := store.GetOrStore(fresh, func() []byte { return large }); !bytes.Equal(res, large) {
+		t.Fatalf("unexpected computed result %q", res)
+	}
+	if _, wasNew := store.StoreIfAbsent(fresh, large); wasNew {
+		t.Fatalf("large value reported as stored")
+	}
+	restored := uuid.New()
+	if n := store.Restore(map[uuid.UUID][]byte{restored: large}); n != 0 {
+		t.Fatalf("large value restored")
+	}
+
+	if !bytes.Equal(store.Check(trx), []byte("test")) || store.Len() != 1 {
+		t.Fatalf("large value stored: %q, %d entries", store.Check(trx), store.Len())
+	}
+	if rejected := store.Stats().Rejected.ValueTooLarge; rejected != 8 {
+		t.Fatalf("expected 8 rejections, got %d", rejected)
+	}
+}
+
+func TestClear(t *testing.T) {
+	clock := manualclock.New()
+	var expired atomic.Int32
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithMaxEntries(100), WithOnExpire(func(uuid.UUID, []byte) {
+		expired.Add(1)
+	}))
+	defer store.Close()
+
+	for i := 0; i < 50; i++ {
+		store.Store(uuid.New(), []byte("test"))
+	}
+	store.Clear()
+	if entries, bytes := store.Usage(); entries != 0 || bytes != 0 {
+		t.Fatalf("expected empty store, got %d entries with %d bytes", entries, bytes)
+	}
+
+	trx := uuid.New()
+	store.Store(trx, []byte("test"))
+	if !bytes.Equal(store.Check(trx), []byte("test")) {
+		t.Fatalf("store after clear failed")
+	}
+	clock.Advance(2 * time.Minute)
+	waitFor(t, func() bool { return expired.Load() == 1 })
+	if store.Len() != 0 {
+		t.Fatalf("expired entry left after clear")
+	}
+}
@@ -0,0 +1,15 @@
+package trxstoretest
+
+import (
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+)
+
+// ManualClock is a trxstore.Clock that only moves when Advance is called,
+// so expiry can be tested without sleeping. Its tickers fire as Advance
+// moves past their ticks and, like time.Ticker, drop ticks the receiver
+// is not ready for.
+type ManualClock = manualclock.Clock
+
+func NewManualClock() *ManualClock {
+	return manualclock.New()
+}
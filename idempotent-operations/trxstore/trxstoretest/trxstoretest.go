@@ -0,0 +1,166 @@
+// Package trxstoretest checks that trxstore.TrxStore implementations
+// behave the same way.
+package trxstoretest
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore"
+	"testing"
+	"time"
+)
+
+// Factory creates a store with the given store-wide TTL and returns it
+// together with a function moving the time of the store forward.
+type Factory func(t *testing.T, ttl time.Duration) (store trxstore.TrxStore, advance func(d time.Duration))
+
+// RunTrxStoreConformance checks the TrxStore contract: lookups of absent
+// and stored entries, replacing and deleting entries, expiry after the
+// store-wide TTL and, through RunStoreWithTTL, per-entry TTLs. Backends
+// call it from their tests with their own Factory.
+func RunTrxStoreConformance(t *testing.T, factory Factory) {
+	t.Run("Absent", func(t *testing.T) {
+		store, _ := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		if res, exists := store.Lookup(trx); exists || res != nil {
+			t.Fatalf("absent entry found: %q", res)
+		}
+		if res := store.Check(trx); res != nil {
+			t.Fatalf("absent entry checked: %q", res)
+		}
+	})
+
+	t.Run("StoreReplaces", func(t *testing.T) {
+		store, _ := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		store.Store(trx, []byte("first"))
+		store.Store(trx, []byte("second"))
+		if res, exists := store.Lookup(trx); !exists || !bytes.Equal(res, []byte("second")) {
+			t.Fatalf("got %q, %v, want the second result", res, exists)
+		}
+	})
+
+	t.Run("EmptyResult", func(t *testing.T) {
+		store, _ := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		store.Store(trx, nil)
+		if res, exists := store.Lookup(trx); !exists || len(res) != 0 {
+			t.Fatalf("got %q, %v, want a stored empty result", res, exists)
+		}
+	})
+
+	t.Run("CallerOwnsBuffers", func(t *testing.T) {
+		store, _ := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		result := []byte("result")
+		store.Store(trx, result)
+		result[0] = 'X'
+		res, _ := store.Lookup(trx)
+		if !bytes.Equal(res, []byte("result")) {
+			t.Fatalf("stored result changed with the caller buffer: %q", res)
+		}
+		res[0] = 'X'
+		if !bytes.Equal(store.Check(trx), []byte("result")) {
+			t.Fatalf("stored result changed with a returned buffer")
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		store, advance := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		store.Store(trx, []byte("result"))
+		advance(time.Minute - time.Second)
+		if _, exists := store.Lookup(trx); !exists {
+			t.Fatalf("entry expired before the store ttl")
+		}
+		advance(2 * time.Second)
+		if _, exists := store.Lookup(trx); exists {
+			t.Fatalf("entry outlived the store ttl")
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store, _ := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		if store.Delete(trx) {
+			t.Fatalf("absent entry reported as deleted")
+		}
+		store.Store(trx, []byte("result"))
+		if !store.Delete(trx) {
+			t.Fatalf("stored entry not reported as deleted")
+		}
+		if _, exists := store.Lookup(trx); exists {
+			t.Fatalf("deleted entry found")
+		}
+		if store.Delete(trx) {
+			t.Fatalf("entry deleted twice")
+		}
+	})
+
+	t.Run("StoreWithTTL", func(t *testing.T) {
+		RunStoreWithTTL(t, factory)
+	})
+}
+
+// RunStoreWithTTL checks that per-entry TTLs given to StoreWithTTL take
+// precedence over the store-wide TTL in both directions, and that a zero
+// TTL means the entry does not expire.
+func RunStoreWithTTL(t *testing.T, factory Factory) {
+	t.Run("ShorterThanStoreTTL", func(t *testing.T) {
+		store, advance := factory(t, time.Hour)
+		defer store.Close()
+
+		short, long := uuid.New(), uuid.New()
+		store.StoreWithTTL(short, []byte("short"), time.Minute)
+		store.Store(long, []byte("long"))
+		advance(2 * time.Minute)
+
+		if _, exists := store.Lookup(short); exists {
+			t.Fatalf("entry outlived its own ttl")
+		}
+		if !bytes.Equal(store.Check(long), []byte("long")) {
+			t.Fatalf("entry with store ttl expired")
+		}
+	})
+
+	t.Run("LongerThanStoreTTL", func(t *testing.T) {
+		store, advance := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		store.StoreWithTTL(trx, []byte("long"), time.Hour)
+		advance(2 * time.Minute)
+		if !bytes.Equal(store.Check(trx), []byte("long")) {
+			t.Fatalf("entry expired with the store ttl")
+		}
+
+		advance(time.Hour)
+		if _, exists := store.Lookup(trx); exists {
+			t.Fatalf("entry outlived its own ttl")
+		}
+	})
+
+	t.Run("ZeroNeverExpires", func(t *testing.T) {
+		store, advance := factory(t, time.Minute)
+		defer store.Close()
+
+		trx := uuid.New()
+		store.StoreWithTTL(trx, []byte("forever"), 0)
+		advance(24 * time.Hour)
+		if !bytes.Equal(store.Check(trx), []byte("forever")) {
+			t.Fatalf("entry with zero ttl expired")
+		}
+	})
+}
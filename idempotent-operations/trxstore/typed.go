@@ -0,0 +1,234 @@
+package trxstore
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// KeyedTrxStore keeps results of any type without marshaling them, keyed
+// by any comparable type, such as composite string keys. Values are stored
+// as is, so callers must not mutate results they share with the store,
+// unless the store encodes them with a Codec given by WithCodec. Entries
+// expire through the same deadline heap as the shards of a
+// BytesTrxStore, but a KeyedTrxStore has a single lock and none of the
+// limits or callbacks of one.
+type KeyedTrxStore[K comparable, V any] struct {
+	cache     map[K]*keyedEntry[K, V]
+	cacheLock sync.RWMutex
+	expiry    deadlineHeap[*keyedEntry[K, V]]
+	ttl       time.Duration
+	clock     Clock
+	interval  time.Duration
+	codec     Codec[V]
+	logger    *slog.Logger
+	janitor   *janitor
+}
+
+// keyedEntry is an entry of a KeyedTrxStore, live until the clock passes
+// its deadline. With a codec the value is held encoded in data.
+type keyedEntry[K comparable, V any] struct {
+	key      K
+	value    V
+	data     []byte
+	deadline time.Time
+	index    int
+}
+
+func (e *keyedEntry[K, V]) due() time.Time {
+	return e.deadline
+}
+
+func (e *keyedEntry[K, V]) setIndex(i int) {
+	e.index = i
+}
+
+// TypedTrxStore is a KeyedTrxStore keyed by UUIDs like the other stores.
+type TypedTrxStore[T any] = KeyedTrxStore[uuid.UUID, T]
+
+// NewKeyedTRXStoreWithContext creates a store whose cleanup stops once ctx
+// is done. Of the options only WithClock, WithCleanupInterval, WithLogger
+// and WithCodec apply to typed stores: the store takes its TTL and context
+// as arguments, and ignores the other options. Like New, it panics with
+// ErrInvalidTTL if ttl is negative, and a zero ttl means entries never
+// expire and no background cleanup is started. It also panics if the
+// codec of WithCodec is not one for V.
+func NewKeyedTRXStoreWithContext[K comparable, V any](ctx context.Context, ttl time.Duration, opts ...Option) *KeyedTrxStore[K, V] {
+	if err := validateTTL(ttl); err != nil {
+		panic(err)
+	}
+	options := newOptions(opts)
+	var codec Codec[V]
+	if options.codec != nil {
+		var ok bool
+		if codec, ok = options.codec.(Codec[V]); !ok {
+			panic(fmt.Errorf("trxstore: codec %T does not encode %T values", options.codec, *new(V)))
+		}
+	}
+	res := &KeyedTrxStore[K, V]{
+		cache:     map[K]*keyedEntry[K, V]{},
+		cacheLock: sync.RWMutex{},
+		ttl:       ttl,
+		clock:     options.clock,
+		interval:  options.cleanupInterval,
+		codec:     codec,
+		logger:    options.logger,
+		janitor:   newJanitor(options.logger),
+	}
+
+	if ttl > 0 {
+		res.janitor.start(ctx, options.clock, options.cleanupInterval, res.cleanupExpired)
+	}
+
+	return res
+}
+
+func NewKeyedTRXStore[K comparable, V any](ttl time.Duration, opts ...Option) *KeyedTrxStore[K, V] {
+	return NewKeyedTRXStoreWithContext[K, V](nil, ttl, opts...)
+}
+
+func NewTypedTRXStoreWithContext[T any](ctx context.Context, ttl time.Duration, opts ...Option) *TypedTrxStore[T] {
+	return NewKeyedTRXStoreWithContext[uuid.UUID, T](ctx, ttl, opts...)
+}
+
+func NewTypedTRXStore[T any](ttl time.Duration, opts ...Option) *TypedTrxStore[T] {
+	return NewTypedTRXStoreWithContext[T](nil, ttl, opts...)
+}
+
+// Check returns the live result of trx. A value that fails to decode is
+// logged and reported as missing.
+func (p *KeyedTrxStore[K, V]) Check(trx K) (V, bool) {
+	res, exists, err := p.CheckE(trx)
+	if err != nil {
+		p.logger.Error("trxstore: failed to decode stored value", "error", err)
+
+		return res, false
+	}
+
+	return res, exists
+}
+
+// CheckE is Check with decoding failures reported: a value that fails to
+// decode is reported as present together with the error.
+func (p *KeyedTrxStore[K, V]) CheckE(trx K) (V, bool, error) {
+	var res V
+	var data []byte
+	p.cacheLock.RLock()
+	entry, exists := p.cache[trx]
+	exists = exists && !p.clock.Now().After(entry.deadline)
+	if exists {
+		res, data = entry.value, entry.data
+	}
+	p.cacheLock.RUnlock()
+	if !exists || p.codec == nil {
+		return res, exists, nil
+	}
+
+	decoded, err := p.codec.Decode(data)
+	if err != nil {
+		return res, true, fmt.Errorf("failed to decode %v: %w", trx, err)
+	}
+
+	return decoded, true, nil
+}
+
+// Store keeps result for the TTL. A value that fails to encode is logged
+// and not stored.
+func (p *KeyedTrxStore[K, V]) Store(trx K, result V) {
+	if err := p.StoreE(trx, result); err != nil {
+		p.logger.Warn("trxstore: value not stored", "error", err)
+	}
+}
+
+// StoreE is Store with encoding failures reported. Nothing is stored when
+// result fails to encode.
+func (p *KeyedTrxStore[K, V]) StoreE(trx K, result V) error {
+	_, err := p.set(trx, result)
+
+	return err
+}
+
+// set stores result for the TTL and reports whether trx held a live
+// entry before. With a codec, result is encoded first and nothing is
+// stored if that fails.
+func (p *KeyedTrxStore[K, V]) set(trx K, result V) (bool, error) {
+	var data []byte
+	if p.codec != nil {
+		var err error
+		if data, err = p.codec.Encode(result); err != nil {
+			return false, fmt.Errorf("failed to encode %v: %w", trx, err)
+		}
+		var empty V
+		result = empty
+	}
+
+	p.cacheLock.Lock()
+	defer p.cacheLock.Unlock()
+
+	now := p.clock.Now()
+	expiresAt := deadline(now, p.ttl)
+	if entry, exists := p.cache[trx]; exists {
+		live := !now.After(entry.deadline)
+		entry.value = result
+		entry.data = data
+		entry.deadline = expiresAt
+		heap.Fix(&p.expiry, entry.index)
+
+		return live, nil
+	}
+	entry := &keyedEntry[K, V]{key: trx, value: result, data: data, deadline: expiresAt}
+	p.cache[trx] = entry
+	heap.Push(&p.expiry, entry)
+
+	return false, nil
+}
+
+func (p *KeyedTrxStore[K, V]) Delete(trx K) bool {
+	p.cacheLock.Lock()
+	entry, exists := p.cache[trx]
+	if exists {
+		delete(p.cache, trx)
+		heap.Remove(&p.expiry, entry.index)
+	}
+	p.cacheLock.Unlock()
+
+	return exists
+}
+
+func (p *KeyedTrxStore[K, V]) Len() int {
+	p.cacheLock.RLock()
+	res := len(p.cache)
+	p.cacheLock.RUnlock()
+
+	return res
+}
+
+// TTL returns the TTL the store was created with.
+func (p *KeyedTrxStore[K, V]) TTL() time.Duration {
+	return p.ttl
+}
+
+// CleanupInterval returns how often expired entries are removed.
+func (p *KeyedTrxStore[K, V]) CleanupInterval() time.Duration {
+	return p.interval
+}
+
+func (p *KeyedTrxStore[K, V]) Close() {
+	p.janitor.close()
+}
+
+// cleanupExpired pops the due entries off the deadline heap, so it only
+// visits entries that are actually expired.
+func (p *KeyedTrxStore[K, V]) cleanupExpired() {
+	p.cacheLock.Lock()
+	now := p.clock.Now()
+	for len(p.expiry) > 0 && now.After(p.expiry[0].deadline) {
+		entry := heap.Pop(&p.expiry).(*keyedEntry[K, V])
+		delete(p.cache, entry.key)
+	}
+	p.cacheLock.Unlock()
+}
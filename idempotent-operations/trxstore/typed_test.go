@@ -0,0 +1,141 @@
+package trxstore
+
+import (
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func ExampleTypedTrxStore() {
+	type Receipt struct {
+		Amount   int64
+		Currency string
+	}
+
+	store := NewTypedTRXStore[Receipt](time.Minute)
+	defer store.Close()
+
+	trx := uuid.New()
+	store.Store(trx, Receipt{Amount: 100, Currency: "USD"})
+
+	receipt, exists := store.Check(trx)
+	fmt.Println(receipt.Amount, receipt.Currency, exists)
+	// Output: 100 USD true
+}
+
+func ExampleKeyedTrxStore() {
+	store := NewKeyedTRXStore[string, int64](time.Minute)
+	defer store.Close()
+
+	store.Store("tenant-1:charge:5f2b", 100)
+
+	amount, exists := store.Check("tenant-1:charge:5f2b")
+	fmt.Println(amount, exists)
+	_, exists = store.Check("tenant-2:charge:5f2b")
+	fmt.Println(exists)
+	// Output:
+	// 100 true
+	// false
+}
+
+func TestTypedTrxStoreExpire(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewTypedTRXStore[int](time.Minute, WithClock(clock))
+	// the cleanup is run by hand below, so the test needs no goroutines
+	store.Close()
+
+	store.Store(trx, 0)
+	clock.Advance(time.Minute)
+	if _, exists := store.Check(trx); !exists {
+		t.Fatalf("trx expired at its ttl")
+	}
+
+	clock.Advance(time.Nanosecond)
+	if _, exists := store.Check(trx); exists {
+		t.Fatalf("trx not expired")
+	}
+	store.cleanupExpired()
+	if store.Len() != 0 {
+		t.Fatalf("trx not cleaned up")
+	}
+}
+
+func TestTypedTrxStoreCleanupUsesClock(t *testing.T) {
+	clock := manualclock.New()
+	store := NewTypedTRXStore[int](time.Minute, WithClock(clock), WithCleanupInterval(time.Second))
+	defer store.Close()
+
+	store.Store(uuid.New(), 0)
+	clock.Advance(time.Minute + time.Second)
+	waitFor(t, func() bool { return store.Len() == 0 })
+}
+
+func TestTypedCleanupKeepsRestoredEntries(t *testing.T) {
+	restored, stale, deleted := uuid.New(), uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewTypedTRXStore[int](time.Minute, WithClock(clock))
+	store.Close()
+
+	store.Store(restored, 1)
+	store.Store(stale, 1)
+	store.Store(deleted, 1)
+	store.Delete(deleted)
+	clock.Advance(time.Minute + time.Nanosecond)
+	store.Store(restored, 2)
+	store.cleanupExpired()
+
+	if res, exists := store.Check(restored); !exists || res != 2 {
+		t.Fatalf("entry stored again after expiring was removed")
+	}
+	if store.Len() != 1 || len(store.expiry) != 1 {
+		t.Fatalf("stale entry not removed")
+	}
+}
+
+func TestTypedStoresRejectNegativeTTL(t *testing.T) {
+	for name, create := range map[string]func(){
+		"typed":  func() { NewTypedTRXStore[int](-time.Second) },
+		"keyed":  func() { NewKeyedTRXStore[string, int](-time.Second) },
+		"seen":   func() { NewSeenTRXStore(-time.Second) },
+		"result": func() { NewResultTRXStore[int](-time.Second) },
+	} {
+		func() {
+			defer func() {
+				if err, _ := recover().(error); !errors.Is(err, ErrInvalidTTL) {
+					t.Fatalf("%s: expected a panic with ErrInvalidTTL, got %v", name, err)
+				}
+			}()
+			create()
+		}()
+	}
+}
+
+func TestTypedStoresZeroTTL(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	typed := NewTypedTRXStore[int](0, WithClock(clock))
+	defer typed.Close()
+	seen := NewSeenTRXStore(0, WithClock(clock))
+	defer seen.Close()
+	result := NewResultTRXStore[int](0)
+	defer result.Close()
+
+	typed.Store(trx, 1)
+	seen.SetSeen(trx)
+	result.StoreResult(trx, 1, nil)
+	clock.Advance(24 * time.Hour)
+	typed.cleanupExpired()
+	if _, exists := typed.Check(trx); !exists || !seen.Seen(trx) {
+		t.Fatalf("entry with a zero ttl expired")
+	}
+	if _, ok, _ := result.CheckResult(trx); !ok {
+		t.Fatalf("outcome with a zero ttl expired")
+	}
+	if typed.janitor.started.Load() {
+		t.Fatalf("cleanup started for a zero ttl")
+	}
+}
@@ -0,0 +1,184 @@
+package trxstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"github.com/google/uuid"
+	"io"
+	"time"
+)
+
+// valueCodec turns values into their stored form and back. Codecs run in
+// order on store and in reverse order on read.
+type valueCodec interface {
+	encode(value []byte) []byte
+	decode(stored []byte) ([]byte, error)
+}
+
+// encode returns the stored form of value, which never shares memory with
+// value.
+func (p *BytesTrxStore) encode(value []byte) []byte {
+	if len(p.codecs) == 0 {
+		return bytes.Clone(value)
+	}
+
+	res := value
+	for _, codec := range p.codecs {
+		res = codec.encode(res)
+	}
+
+	return res
+}
+
+// decode returns a copy of the value held in stored form. Values that fail
+// to decode are logged and reported as missing.
+func (p *BytesTrxStore) decode(stored []byte) ([]byte, bool) {
+	if len(p.codecs) == 0 {
+		return bytes.Clone(stored), true
+	}
+
+	res := stored
+	for i := len(p.codecs) - 1; i >= 0; i-- {
+		var err error
+		if res, err = p.codecs[i].decode(res); err != nil {
+			p.opts.logger.Error("trxstore: failed to decode stored value", "error", err)
+
+			return nil, false
+		}
+	}
+
+	return res, true
+}
+
+// get returns a copy of the live value of trx. It must be called with the
+// shard lock held.
+func (p *BytesTrxStore) get(s *shard, trx uuid.UUID, now time.Time) ([]byte, bool) {
+	res, exists := s.lookup(trx, now)
+	if !exists {
+		return nil, false
+	}
+	if s.tombstoned(trx, now) {
+		return nil, true
+	}
+
+	return p.decode(res)
+}
+
+// getRef is like get, but returns the held slice itself when values are
+// held as is.
+func (p *BytesTrxStore) getRef(s *shard, trx uuid.UUID, now time.Time) ([]byte, bool) {
+	if len(p.codecs) > 0 || s.valueTTL > 0 {
+		return p.get(s, trx, now)
+	}
+
+	return s.lookup(trx, now)
+}
+
+func newCodecs(opts options) ([]valueCodec, error) {
+	var res []valueCodec
+	if opts.compressionThreshold > 0 {
+		res = append(res, compression{threshold: opts.compressionThreshold})
+	}
+	if opts.encryptionKey != nil {
+		codec, err := newEncryption(opts.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, codec)
+	}
+
+	return res, nil
+}
+
+const (
+	valueRaw byte = iota
+	valueFlate
+)
+
+// compression deflates values larger than threshold. Stored values start
+// with a byte telling whether the rest is compressed.
+type compression struct {
+	threshold int
+}
+
+func (c compression) encode(value []byte) []byte {
+	if len(value) > c.threshold {
+		var buf bytes.Buffer
+		buf.WriteByte(valueFlate)
+		w, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+		_, _ = w.Write(value)
+		_ = w.Close()
+		if buf.Len() <= len(value) {
+			return buf.Bytes()
+		}
+	}
+
+	res := make([]byte, 0, len(value)+1)
+	res = append(res, valueRaw)
+
+	return append(res, value...)
+}
+
+func (c compression) decode(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("empty compressed value")
+	}
+
+	switch stored[0] {
+	case valueRaw:
+		return bytes.Clone(stored[1:]), nil
+	case valueFlate:
+		res, err := io.ReadAll(flate.NewReader(bytes.NewReader(stored[1:])))
+		if err != nil {
+			return nil, fmt.Errorf("failed to inflate value: %w", err)
+		}
+
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unknown value encoding %d", stored[0])
+	}
+}
+
+// encryption seals values with AES-GCM. Stored values are a random nonce
+// followed by the sealed value.
+type encryption struct {
+	aead cipher.AEAD
+}
+
+func newEncryption(key []byte) (encryption, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return encryption{}, fmt.Errorf("trxstore: invalid encryption key: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return encryption{}, fmt.Errorf("trxstore: failed to create gcm: %w", err)
+	}
+
+	return encryption{aead: aead}, nil
+}
+
+func (c encryption) encode(value []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize(), c.aead.NonceSize()+len(value)+c.aead.Overhead())
+	_, _ = rand.Read(nonce)
+
+	return c.aead.Seal(nonce, nonce, value, nil)
+}
+
+func (c encryption) decode(stored []byte) ([]byte, error) {
+	if len(stored) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted value too short")
+	}
+
+	nonce, sealed := stored[:c.aead.NonceSize()], stored[c.aead.NonceSize():]
+	res, err := c.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %w", err)
+	}
+
+	return res, nil
+}
@@ -0,0 +1,66 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"testing"
+	"time"
+)
+
+func TestCompression(t *testing.T) {
+	small, large := uuid.New(), uuid.New()
+	store := NewBytesTRXStore(time.Minute, WithCompression(64))
+	defer store.Close()
+
+	largeValue := bytes.Repeat([]byte(`{"status":"ok"}`), 100)
+	store.Store(small, []byte("small"))
+	store.Store(large, largeValue)
+
+	if !bytes.Equal(store.Check(small), []byte("small")) {
+		t.Fatalf("small value not restored")
+	}
+	if !bytes.Equal(store.Check(large), largeValue) {
+		t.Fatalf("large value not restored")
+	}
+	if size := store.Bytes(); size >= int64(len(largeValue)) {
+		t.Fatalf("large value not compressed, %d bytes held", size)
+	}
+
+	var evicted []byte
+	store = NewBytesTRXStore(time.Minute, WithCompression(64), WithShards(1), WithMaxEntries(1),
+		WithOnEvict(func(trx uuid.UUID, value []byte) {
+			evicted = value
+		}))
+	defer store.Close()
+	store.Store(large, largeValue)
+	store.Store(small, nil)
+	if !bytes.Equal(evicted, largeValue) {
+		t.Fatalf("evicted value not decompressed")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	trx := uuid.New()
+	key := bytes.Repeat([]byte{7}, 32)
+	store := NewBytesTRXStore(time.Minute, WithEncryption(key), WithCompression(8))
+	defer store.Close()
+
+	value := bytes.Repeat([]byte("secret"), 10)
+	store.Store(trx, value)
+	if !bytes.Equal(store.Check(trx), value) {
+		t.Fatalf("value not restored")
+	}
+
+	s := store.shard(trx)
+	if bytes.Contains(s.cache[trx], []byte("secret")) {
+		t.Fatalf("value held in plaintext")
+	}
+	s.cache[trx][len(s.cache[trx])-1] ^= 0xff
+	if res, exists := store.Lookup(trx); exists || res != nil {
+		t.Fatalf("corrupted value returned: %q", res)
+	}
+
+	if _, err := NewBytesTRXStoreE(time.Minute, WithEncryption([]byte("short"))); err == nil {
+		t.Fatalf("invalid key accepted")
+	}
+}
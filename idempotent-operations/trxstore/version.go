@@ -0,0 +1,60 @@
+package trxstore
+
+import "github.com/google/uuid"
+
+// CheckVersioned is like Lookup, but also returns the version of the
+// value. Every store of a key bumps its version, starting from 1, and a
+// missing or expired key has version 0.
+func (p *BytesTrxStore) CheckVersioned(trx uuid.UUID) (result []byte, version uint64, ok bool) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	result, ok = p.get(s, trx, now)
+	if ok {
+		p.accessed(s, trx, now)
+		version = s.cacheMeta[trx].version
+	}
+	s.cacheLock.Unlock()
+	p.countLookup(ok)
+
+	return result, version, ok
+}
+
+// StoreVersioned stores result like Store only if the current version of
+// trx is expectedVersion, so a writer holding a stale version cannot
+// overwrite a newer result. Use 0 as expectedVersion to create trx. It
+// returns the new version and whether the write happened. A result over
+// the WithMaxValueSize limit is not stored, and StoreVersioned then
+// returns 0 and false.
+func (p *BytesTrxStore) StoreVersioned(trx uuid.UUID, result []byte, expectedVersion uint64) (newVersion uint64, ok bool) {
+	result, ok = p.limitLogged(trx, result)
+	if !ok {
+		return 0, false
+	}
+	stored := p.encode(result)
+
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	now := p.opts.clock.Now()
+	var current uint64
+	if _, exists := s.lookup(trx, now); exists {
+		current = s.cacheMeta[trx].version
+	}
+	if current != expectedVersion {
+		s.cacheLock.Unlock()
+
+		return current, false
+	}
+	evicted := s.store(trx, stored, p.createdAt(s, trx, now), now, p.entryTTL(trx))
+	newVersion = s.cacheMeta[trx].version
+	write, mirror := p.mirrorEntry(s, trx, result, now)
+	s.cacheLock.Unlock()
+	p.stats.stores.Add(1)
+
+	p.notify(evicted)
+	if mirror {
+		p.mirrorLogged(write)
+	}
+
+	return newVersion, true
+}
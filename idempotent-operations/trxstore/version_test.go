@@ -0,0 +1,44 @@
+package trxstore
+
+import (
+	"bytes"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestStoreVersioned(t *testing.T) {
+	trx := uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock))
+	defer store.Close()
+
+	if version, ok := store.StoreVersioned(trx, []byte("first"), 0); !ok || version != 1 {
+		t.Fatalf("create rejected, version %d", version)
+	}
+	if version, ok := store.StoreVersioned(trx, []byte("second"), 1); !ok || version != 2 {
+		t.Fatalf("update rejected, version %d", version)
+	}
+	if version, ok := store.StoreVersioned(trx, []byte("stale"), 1); ok || version != 2 {
+		t.Fatalf("stale write accepted, version %d", version)
+	}
+
+	res, version, ok := store.CheckVersioned(trx)
+	if !ok || version != 2 || !bytes.Equal(res, []byte("second")) {
+		t.Fatalf("unexpected result %q at version %d", res, version)
+	}
+
+	store.Store(trx, []byte("third"))
+	if _, version, _ := store.CheckVersioned(trx); version != 3 {
+		t.Fatalf("store did not bump version, got %d", version)
+	}
+
+	clock.Advance(2 * time.Minute)
+	if _, version, ok := store.CheckVersioned(trx); ok || version != 0 {
+		t.Fatalf("expired trx reported at version %d", version)
+	}
+	if version, ok := store.StoreVersioned(trx, []byte("again"), 0); !ok || version != 1 {
+		t.Fatalf("recreate rejected, version %d", version)
+	}
+}
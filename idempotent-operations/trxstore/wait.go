@@ -0,0 +1,73 @@
+package trxstore
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"slices"
+)
+
+// WaitFor returns the live value of trx, waiting for it to be stored if it
+// is missing. It returns false once ctx is done, so pass a context with a
+// deadline to bound the wait. All callers waiting for trx are woken by its
+// next store. A caller giving up unregisters itself, so waiting keeps no
+// state for trx once its last waiter has returned.
+func (p *BytesTrxStore) WaitFor(ctx context.Context, trx uuid.UUID) ([]byte, bool) {
+	for {
+		res, exists, f := p.wait(trx)
+		if exists {
+			return res, true
+		}
+
+		select {
+		case <-f.done:
+			if res, ok := p.decode(f.result); ok {
+				return res, true
+			}
+		case <-ctx.Done():
+			p.unwait(trx, f)
+
+			return nil, false
+		}
+	}
+}
+
+// wait returns the live value of trx or registers a waiter resolved by its
+// next store.
+func (p *BytesTrxStore) wait(trx uuid.UUID) ([]byte, bool, *flight) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	now := p.opts.clock.Now()
+	if res, exists := p.get(s, trx, now); exists {
+		p.accessed(s, trx, now)
+		p.countLookup(true)
+
+		return res, true, nil
+	}
+
+	p.countLookup(false)
+	f := newFlight()
+	s.waiters[trx] = append(s.waiters[trx], f)
+
+	return nil, false, f
+}
+
+// unwait unregisters f after its caller gave up. The waiters of a key are
+// dropped together with the key, either here when the last one leaves or
+// by the store resolving them, so keys waited for but never stored do not
+// accumulate.
+func (p *BytesTrxStore) unwait(trx uuid.UUID, f *flight) {
+	s := p.shard(trx)
+	s.cacheLock.Lock()
+	defer s.cacheLock.Unlock()
+
+	waiters := slices.DeleteFunc(s.waiters[trx], func(waiter *flight) bool {
+		return waiter == f
+	})
+	if len(waiters) == 0 {
+		delete(s.waiters, trx)
+	} else {
+		s.waiters[trx] = waiters
+	}
+}
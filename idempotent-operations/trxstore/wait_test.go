@@ -0,0 +1,110 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/uuid"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitFor(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	var wg sync.WaitGroup
+	results := make([][]byte, 5)
+	for i := range results {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			results[i], _ = store.WaitFor(ctx, trx)
+		}()
+	}
+
+	waitFor(t, func() bool {
+		s := store.shard(trx)
+		s.cacheLock.RLock()
+		defer s.cacheLock.RUnlock()
+
+		return len(s.waiters[trx]) == len(results)
+	})
+	store.Store(trx, []byte("ready"))
+	wg.Wait()
+
+	for i, res := range results {
+		if !bytes.Equal(res, []byte("ready")) {
+			t.Fatalf("waiter %d got %q", i, res)
+		}
+	}
+	if res, exists := store.WaitFor(context.Background(), trx); !exists || !bytes.Equal(res, []byte("ready")) {
+		t.Fatalf("stored trx not returned right away")
+	}
+}
+
+func TestWaitForTimeout(t *testing.T) {
+	trx := uuid.New()
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, exists := store.WaitFor(ctx, trx); exists {
+		t.Fatalf("unexpected value for missing trx")
+	}
+
+	s := store.shard(trx)
+	s.cacheLock.RLock()
+	defer s.cacheLock.RUnlock()
+	if len(s.waiters) != 0 {
+		t.Fatalf("cancelled waiter still registered")
+	}
+}
+
+func TestWaitForCancelledWaitersLeaveNoKeys(t *testing.T) {
+	store := NewBytesTRXStore(time.Minute)
+	defer store.Close()
+
+	keys := make([]uuid.UUID, 50)
+	for i := range keys {
+		keys[i] = uuid.New()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	for i := range 500 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.WaitFor(ctx, keys[i%len(keys)])
+		}()
+	}
+
+	waiting := func() (waitedKeys, waiters int) {
+		for _, s := range store.shards {
+			s.cacheLock.RLock()
+			waitedKeys += len(s.waiters)
+			for _, w := range s.waiters {
+				waiters += len(w)
+			}
+			s.cacheLock.RUnlock()
+		}
+
+		return waitedKeys, waiters
+	}
+	waitFor(t, func() bool {
+		_, waiters := waiting()
+		return waiters == 500
+	})
+	// a store resolves the waiters of one key, the rest give up
+	store.Store(keys[0], []byte("ready"))
+	cancel()
+	wg.Wait()
+
+	if waitedKeys, waiters := waiting(); waitedKeys != 0 || waiters != 0 {
+		t.Fatalf("%d waiters of %d keys left registered", waiters, waitedKeys)
+	}
+}
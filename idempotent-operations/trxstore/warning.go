@@ -0,0 +1,67 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// ExpiryWarning reports an entry that expires within the lead time set by
+// WithExpiryWarning.
+type ExpiryWarning struct {
+	Trx       uuid.UUID
+	ExpiresAt time.Time
+}
+
+// ExpiryWarnings returns the channel enabled by WithExpiryWarning, or nil
+// if it is not enabled. It is closed by Close.
+func (p *BytesTrxStore) ExpiryWarnings() <-chan ExpiryWarning {
+	if p.warnings == nil {
+		return nil
+	}
+
+	return p.warnings.ch
+}
+
+// warnExpiring sends a warning for every entry of s due within the lead
+// time that was not warned about yet.
+func (p *BytesTrxStore) warnExpiring(s *shard, now time.Time) {
+	until := now.Add(p.opts.expiryWarning)
+	var warnings []ExpiryWarning
+	s.cacheLock.Lock()
+	s.dueBefore(until, func(meta *entryMeta) {
+		if !meta.warned {
+			meta.warned = true
+			warnings = append(warnings, ExpiryWarning{Trx: meta.trx, ExpiresAt: meta.deadline})
+		}
+	})
+	s.cacheLock.Unlock()
+
+	for _, warning := range warnings {
+		p.warnings.send(warning)
+	}
+}
+
+// dueBefore calls fn for every entry with a deadline not after until. It
+// only visits the part of the expiry heap above until, and must be called
+// with the shard lock held.
+func (s *shard) dueBefore(until time.Time, fn func(meta *entryMeta)) {
+	if len(s.expiry) == 0 {
+		return
+	}
+
+	stack := []int{0}
+	for len(stack) > 0 {
+		i := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.expiry[i].deadline.After(until) {
+			continue
+		}
+
+		fn(s.expiry[i])
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(s.expiry) {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package trxstore
+
+import (
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestExpiryWarning(t *testing.T) {
+	soon, later, never := uuid.New(), uuid.New(), uuid.New()
+	clock := manualclock.New()
+	store := NewBytesTRXStore(time.Minute, WithClock(clock), WithExpiryWarning(10*time.Second))
+	defer store.Close()
+
+	store.StoreWithTTL(soon, nil, 15*time.Second)
+	store.Store(later, nil)
+	store.StoreWithTTL(never, nil, 0)
+	for i := 0; i < 100; i++ {
+		store.Store(uuid.New(), nil)
+	}
+	clock.Advance(6 * time.Second)
+	store.ForceCleanup()
+
+	warnings := store.ExpiryWarnings()
+	select {
+	case warning := <-warnings:
+		if warning.Trx != soon || !warning.ExpiresAt.Equal(clock.Now().Add(9*time.Second)) {
+			t.Fatalf("unexpected warning %+v", warning)
+		}
+	default:
+		t.Fatalf("no warning for entry expiring soon")
+	}
+
+	store.Touch(soon)
+	store.ForceCleanup()
+	select {
+	case warning := <-warnings:
+		t.Fatalf("unexpected second warning %+v", warning)
+	default:
+	}
+
+	store.StoreWithTTL(soon, nil, 5*time.Second)
+	store.ForceCleanup()
+	if warning := <-warnings; warning.Trx != soon {
+		t.Fatalf("store did not re-arm the warning, got %+v", warning)
+	}
+}
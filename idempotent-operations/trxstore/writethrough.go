@@ -0,0 +1,241 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const writeThroughQueueSize = 1024
+
+// WriteThroughFullPolicy decides what happens to an asynchronous
+// write-through store while the queue is full. See WithWriteThroughQueue.
+type WriteThroughFullPolicy int
+
+const (
+	// WriteThroughDropNewest drops the new write.
+	WriteThroughDropNewest WriteThroughFullPolicy = iota
+	// WriteThroughDropOldest drops the oldest queued write to make room
+	// for the new one.
+	WriteThroughDropOldest
+	// WriteThroughBlock makes the store wait for room, and drops the new
+	// write if none frees up within the configured wait.
+	WriteThroughBlock
+)
+
+// WriteThroughStats describes the asynchronous write-through queue.
+// Depth is the number of queued writes, Enqueued and Flushed count the
+// writes queued and applied to the secondary store, and Dropped those lost
+// to a full queue.
+type WriteThroughStats struct {
+	Depth    int
+	Enqueued uint64
+	Flushed  uint64
+	Dropped  uint64
+}
+
+type writeThroughEntry struct {
+	trx    uuid.UUID
+	result []byte
+	ttl    time.Duration
+}
+
+// writeThrough mirrors stores to a secondary store, inline or through a
+// bounded queue drained by one goroutine.
+type writeThrough struct {
+	secondary TrxStore
+	logger    *slog.Logger
+	whenFull  WriteThroughFullPolicy
+	maxWait   time.Duration
+	lock      sync.RWMutex
+	queue     chan writeThroughEntry
+	closed    bool
+	done      chan struct{}
+	enqueued  atomic.Uint64
+	flushed   atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+func newWriteThrough(opts options) *writeThrough {
+	res := &writeThrough{
+		secondary: opts.writeThrough,
+		logger:    opts.logger,
+		whenFull:  opts.writeThroughFull,
+		maxWait:   opts.writeThroughMaxWait,
+	}
+	if opts.writeThroughAsync {
+		res.queue = make(chan writeThroughEntry, opts.writeThroughQueueSize)
+		res.done = make(chan struct{})
+		go res.run()
+	}
+
+	return res
+}
+
+// store hands entry, whose result must not be modified afterwards, to the
+// secondary store. Inline it returns the error of a secondary that
+// implements TrxStoreE, wrapped in ErrWriteThrough; queued writes that
+// fail are logged instead.
+func (w *writeThrough) store(ctx context.Context, entry writeThroughEntry) error {
+	if w.queue == nil {
+		return w.apply(ctx, entry)
+	}
+
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.closed {
+		return nil
+	}
+	if w.enqueue(entry) {
+		w.enqueued.Add(1)
+	} else {
+		w.dropped.Add(1)
+		w.logger.Warn("trxstore: write-through queue full, dropping store", "trx", entry.trx)
+	}
+
+	return nil
+}
+
+func (w *writeThrough) apply(ctx context.Context, entry writeThroughEntry) error {
+	secondary, fallible := w.secondary.(TrxStoreE)
+	if !fallible {
+		w.secondary.StoreWithTTL(entry.trx, entry.result, entry.ttl)
+
+		return nil
+	}
+	if err := secondary.StoreWithTTLE(ctx, entry.trx, entry.result, entry.ttl); err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteThrough, err)
+	}
+
+	return nil
+}
+
+// enqueue queues entry as the policy allows and reports whether it was
+// queued. Writes dropped to make room are counted here.
+func (w *writeThrough) enqueue(entry writeThroughEntry) bool {
+	select {
+	case w.queue <- entry:
+		return true
+	default:
+	}
+
+	switch w.whenFull {
+	case WriteThroughDropOldest:
+		for {
+			select {
+			case w.queue <- entry:
+				return true
+			case oldest := <-w.queue:
+				w.dropped.Add(1)
+				w.logger.Warn("trxstore: write-through queue full, dropping oldest store", "trx", oldest.trx)
+			}
+		}
+	case WriteThroughBlock:
+		timer := time.NewTimer(w.maxWait)
+		defer timer.Stop()
+
+		select {
+		case w.queue <- entry:
+			return true
+		case <-timer.C:
+			return false
+		}
+	default:
+		return false
+	}
+}
+
+func (w *writeThrough) stats() WriteThroughStats {
+	return WriteThroughStats{
+		Depth:    len(w.queue),
+		Enqueued: w.enqueued.Load(),
+		Flushed:  w.flushed.Load(),
+		Dropped:  w.dropped.Load(),
+	}
+}
+
+func (w *writeThrough) run() {
+	defer close(w.done)
+
+	for entry := range w.queue {
+		if err := w.apply(context.Background(), entry); err != nil {
+			w.logger.Warn("trxstore: write-through failed", "trx", entry.trx, "error", err)
+		}
+		w.flushed.Add(1)
+	}
+}
+
+// close waits until queued stores reach the secondary store.
+func (w *writeThrough) close() {
+	if w.queue == nil {
+		return
+	}
+
+	w.lock.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.lock.Unlock()
+	<-w.done
+}
+
+// mirror hands writes to the write-through secondary, if there is one,
+// and returns the first error. It must be called without shard locks
+// held, so that a slow secondary does not stall the shards.
+func (p *BytesTrxStore) mirror(ctx context.Context, writes ...writeThroughEntry) error {
+	if p.mirrored == nil {
+		return nil
+	}
+
+	var res error
+	for _, entry := range writes {
+		if err := p.mirrored.store(ctx, entry); err != nil && res == nil {
+			res = err
+		}
+	}
+
+	return res
+}
+
+// mirrorLogged is mirror for methods without an error result, which log
+// write-through failures instead.
+func (p *BytesTrxStore) mirrorLogged(writes ...writeThroughEntry) {
+	if err := p.mirror(context.Background(), writes...); err != nil {
+		p.opts.logger.Warn("trxstore: write-through failed", "error", err)
+	}
+}
+
+// mirrorEntry returns the write-through of the just stored trx with the
+// time it has left, zero if it never expires, or false if there is no
+// secondary or the entry is already due. result is copied. It must be
+// called with the shard lock held.
+func (p *BytesTrxStore) mirrorEntry(s *shard, trx uuid.UUID, result []byte, now time.Time) (writeThroughEntry, bool) {
+	meta := s.cacheMeta[trx]
+	if p.mirrored == nil || meta == nil {
+		return writeThroughEntry{}, false
+	}
+
+	deadline := meta.deadline
+	if deadline.Equal(neverExpires) {
+		return writeThroughEntry{trx: trx, result: bytes.Clone(result)}, true
+	}
+	left := deadline.Sub(now)
+
+	return writeThroughEntry{trx: trx, result: bytes.Clone(result), ttl: left}, left > 0
+}
+
+// WriteThroughStats returns the state of the asynchronous write-through
+// queue, all zero without one.
+func (p *BytesTrxStore) WriteThroughStats() WriteThroughStats {
+	if p.mirrored == nil {
+		return WriteThroughStats{}
+	}
+
+	return p.mirrored.stats()
+}
@@ -0,0 +1,207 @@
+package trxstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/octopus-foundation/backend-cookbook/idempotent-operations/trxstore/internal/manualclock"
+	"testing"
+	"time"
+)
+
+func TestWriteThrough(t *testing.T) {
+	for _, async := range []bool{false, true} {
+		secondary := NewBytesTRXStore(time.Minute)
+		store := NewBytesTRXStore(time.Minute, WithWriteThrough(secondary, async))
+
+		trx := uuid.New()
+		store.Store(trx, []byte("test"))
+		store.StoreMany(map[uuid.UUID][]byte{uuid.New(): nil, uuid.New(): nil})
+		if !async && !bytes.Equal(secondary.Check(trx), []byte("test")) {
+			t.Fatalf("inline write not mirrored")
+		}
+
+		store.Close()
+		if secondary.Len() != 3 || !bytes.Equal(secondary.Check(trx), []byte("test")) {
+			t.Fatalf("async %v: expected 3 mirrored entries, got %d", async, secondary.Len())
+		}
+		secondary.Close()
+	}
+}
+
+// slowStore is a secondary store whose writes wait until release is closed.
+type slowStore struct {
+	*BytesTrxStore
+	release chan struct{}
+}
+
+func (s *slowStore) StoreWithTTLE(ctx context.Context, trx uuid.UUID, result []byte, ttl time.Duration) error {
+	<-s.release
+	return s.BytesTrxStore.StoreWithTTLE(ctx, trx, result, ttl)
+}
+
+func TestWriteThroughQueueFull(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		whenFull WriteThroughFullPolicy
+		releases time.Duration
+		mirrored []int
+	}{
+		{name: "drop newest", whenFull: WriteThroughDropNewest, mirrored: []int{0, 1}},
+		{name: "drop oldest", whenFull: WriteThroughDropOldest, mirrored: []int{0, 2}},
+		{name: "block times out", whenFull: WriteThroughBlock, mirrored: []int{0, 1}},
+		{name: "block until room", whenFull: WriteThroughBlock, releases: 10 * time.Millisecond, mirrored: []int{0, 1, 2}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			secondary := &slowStore{BytesTrxStore: NewBytesTRXStore(time.Minute), release: make(chan struct{})}
+			defer secondary.Close()
+			maxWait := time.Millisecond
+			if tc.releases > 0 {
+				maxWait = time.Minute
+			}
+			store := NewBytesTRXStore(time.Minute, WithWriteThrough(secondary, true),
+				WithWriteThroughQueue(1, tc.whenFull, maxWait))
+
+			trxs := []uuid.UUID{uuid.New(), uuid.New(), uuid.New()}
+			store.Store(trxs[0], nil)
+			// the first write is taken off the queue and stalls in the secondary
+			waitFor(t, func() bool { return store.WriteThroughStats().Depth == 0 })
+			store.Store(trxs[1], nil)
+			if tc.releases > 0 {
+				time.AfterFunc(tc.releases, func() { close(secondary.release) })
+			}
+			store.Store(trxs[2], nil)
+			if tc.releases == 0 {
+				close(secondary.release)
+			}
+			store.Close()
+
+			if secondary.Len() != len(tc.mirrored) {
+				t.Fatalf("expected %d mirrored entries, got %d", len(tc.mirrored), secondary.Len())
+			}
+			for _, i := range tc.mirrored {
+				if _, exists := secondary.Lookup(trxs[i]); !exists {
+					t.Fatalf("write %d not mirrored", i)
+				}
+			}
+			stats := store.WriteThroughStats()
+			dropped := uint64(len(trxs) - len(tc.mirrored))
+			if stats.Depth != 0 || stats.Flushed != uint64(len(tc.mirrored)) || stats.Dropped != dropped {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestWriteThroughOutsideShardLock(t *testing.T) {
+	secondary := &slowStore{BytesTrxStore: NewBytesTRXStore(time.Minute), release: make(chan struct{})}
+	defer secondary.Close()
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithWriteThrough(secondary, false))
+	defer store.Close()
+
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		store.Store(uuid.New(), nil)
+	}()
+	waitFor(t, func() bool { return store.Len() == 1 })
+
+	// the store is stuck in the secondary, but the shard is free
+	looked := make(chan struct{})
+	go func() {
+		defer close(looked)
+		store.Lookup(uuid.New())
+	}()
+	select {
+	case <-looked:
+	case <-time.After(time.Second):
+		t.Fatalf("lookup blocked by a slow secondary")
+	}
+	close(secondary.release)
+	<-stored
+}
+
+func TestStoreAllMirrorsOutsideShardLocks(t *testing.T) {
+	secondary := &slowStore{BytesTrxStore: NewBytesTRXStore(time.Minute), release: make(chan struct{})}
+	defer secondary.Close()
+	store := NewBytesTRXStore(time.Minute, WithShards(1), WithWriteThrough(secondary, false))
+	defer store.Close()
+
+	stored := make(chan struct{})
+	go func() {
+		defer close(stored)
+		store.StoreAll(map[uuid.UUID][]byte{uuid.New(): nil, uuid.New(): nil})
+	}()
+	waitFor(t, func() bool { return store.Len() == 2 })
+
+	looked := make(chan struct{})
+	go func() {
+		defer close(looked)
+		store.Lookup(uuid.New())
+	}()
+	select {
+	case <-looked:
+	case <-time.After(time.Second):
+		t.Fatalf("lookup blocked by a slow secondary")
+	}
+	close(secondary.release)
+	<-stored
+	if secondary.Len() != 2 {
+		t.Fatalf("expected 2 mirrored entries, got %d", secondary.Len())
+	}
+}
+
+func TestWriteThroughError(t *testing.T) {
+	secondary := failingStore{NewBytesTRXStore(time.Minute)}
+	defer secondary.Close()
+	store := NewBytesTRXStore(time.Minute, WithWriteThrough(secondary, false))
+	defer store.Close()
+
+	trx := uuid.New()
+	err := store.StoreE(context.Background(), trx, []byte("test"))
+	if !errors.Is(err, ErrWriteThrough) || !errors.Is(err, errRemote) {
+		t.Fatalf("expected the secondary's error, got %v", err)
+	}
+	// the store itself keeps the result
+	if !bytes.Equal(store.Check(trx), []byte("test")) {
+		t.Fatalf("result not stored")
+	}
+	if stats := store.Stats(); stats.Rejected != (RejectCounts{}) {
+		t.Fatalf("write-through failure counted as a rejection: %+v", stats.Rejected)
+	}
+}
+
+func TestWriteThroughTTL(t *testing.T) {
+	clock := manualclock.New()
+	secondary := NewBytesTRXStore(time.Hour, WithClock(clock))
+	defer secondary.Close()
+	store := NewBytesTRXStore(time.Hour, WithClock(clock), WithWriteThrough(secondary, false))
+	defer store.Close()
+
+	short, deadline := uuid.New(), uuid.New()
+	store.StoreWithTTL(short, nil, time.Minute)
+	store.StoreWithDeadline(deadline, nil, clock.Now().Add(2*time.Minute))
+	for trx, want := range map[uuid.UUID]time.Duration{short: time.Minute, deadline: 2 * time.Minute} {
+		if ttl, exists := secondary.TTLRemaining(trx); !exists || ttl != want {
+			t.Fatalf("expected the secondary to get ttl %v, got %v", want, ttl)
+		}
+	}
+}
+
+func TestWriteThroughWritePaths(t *testing.T) {
+	secondary := NewBytesTRXStore(time.Minute)
+	defer secondary.Close()
+	store := NewBytesTRXStore(time.Minute, WithWriteThrough(secondary, false))
+	defer store.Close()
+
+	swapped, cas, merged := uuid.New(), uuid.New(), uuid.New()
+	store.Swap(swapped, []byte("swap"))
+	store.CompareAndSwap(cas, nil, []byte("cas"))
+	store.Merge(merged, []byte("merge"), func(old, new []byte) []byte { return new })
+	for trx, want := range map[uuid.UUID]string{swapped: "swap", cas: "cas", merged: "merge"} {
+		if got := secondary.Check(trx); string(got) != want {
+			t.Fatalf("expected %q mirrored, got %q", want, got)
+		}
+	}
+}